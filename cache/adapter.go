@@ -51,8 +51,10 @@ type Adapter interface {
 	// It does not expire if `duration` == 0.
 	// It deletes the `key` if `duration` < 0 or given `value` is nil.
 	//
-	// Note that it differs from function `SetIfNotExistFunc` is that the function `f` is executed within
-	// writing mutex lock for concurrent safety purpose.
+	// Note that it differs from function `SetIfNotExistFunc` is that the function `f` is executed only once
+	// concurrently for the same `key` for concurrent safety purpose, and the execution should
+	// respect the cancellation of `ctx`. It returns true only to the caller that executes `f` and
+	// sets its result, and the concurrent callers sharing the result get false.
 	SetIfNotExistFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (ok bool, err error)
 
 	// Get retrieves and returns the associated value of given `key`.
@@ -86,8 +88,9 @@ type Adapter interface {
 	// It deletes the `key` if `duration` < 0 or given `value` is nil, but it does nothing
 	// if `value` is a function and the function result is nil.
 	//
-	// Note that it differs from function `GetOrSetFunc` is that the function `f` is executed within
-	// writing mutex lock for concurrent safety purpose.
+	// Note that it differs from function `GetOrSetFunc` is that the function `f` is executed only once
+	// concurrently for the same `key` for concurrent safety purpose, and the execution should
+	// respect the cancellation of `ctx`.
 	GetOrSetFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (result *vars.Var, err error)
 
 	// Contains checks and returns true if `key` exists in the cache, or else returns false.
@@ -0,0 +1,639 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gocarp/errors"
+	"github.com/gocarp/go/container/types"
+	"github.com/gocarp/go/container/vars"
+	"github.com/gocarp/go/timer"
+	"github.com/gocarp/go/times"
+	"github.com/gocarp/helpers/intlog"
+	"github.com/gocarp/utils/conv"
+)
+
+// AdapterFile is the cache adapter implements using a single append-only log file.
+//
+// Every writing operation appends a record to the end of the log file, and an in-memory
+// index maps each key to the position of its latest record, so that reading a value
+// costs only one positioned read from the file. Stale records are periodically dropped
+// by compaction, which rewrites the live records to a new file and atomically replaces
+// the old one. On creation, the log file is replayed to rebuild the index, and any torn
+// record at the tail left by a crash is truncated.
+//
+// Note that the keys are stored as strings and the values are stored as JSON.
+type AdapterFile struct {
+	mu      sync.RWMutex                    // mu ensures the concurrent safety of file and index.
+	config  AdapterFileConfig               // config is the configuration of the adapter.
+	file    *os.File                        // file is the opened log file for appending and reading.
+	size    int64                           // size is the current size of the log file, which is also the offset of the next record.
+	garbage int64                           // garbage is the total size of stale records in the log file.
+	index   map[string]adapterFileIndexItem // index is the key to its latest record mapping.
+	loader  *adapterLoader                  // loader executes the cache functions for locking operations.
+	closed  *types.Bool                     // closed controls the cache closed or not.
+}
+
+// AdapterFileConfig is the configuration for AdapterFile.
+type AdapterFileConfig struct {
+	Path            string        // Path of the log file, which is created if it does not exist.
+	SyncWrite       bool          // SyncWrite calls fsync after each writing operation, which is durable but slow.
+	CompactInterval time.Duration // CompactInterval is the interval for checking compaction and expiration, default is one minute.
+	CompactRatio    float64       // CompactRatio triggers compaction if the stale records take more than this ratio of the file, default is 0.5.
+	CompactMinSize  int64         // CompactMinSize is the minimum file size in bytes that triggers compaction, default is 1MB.
+}
+
+// Internal index item for AdapterFile.
+type adapterFileIndexItem struct {
+	offset int64 // Offset of the record in the log file.
+	size   int64 // Total size of the record in the log file.
+	e      int64 // Expire timestamp in milliseconds.
+}
+
+const (
+	defaultAdapterFileCompactInterval = time.Minute
+	defaultAdapterFileCompactRatio    = 0.5
+	defaultAdapterFileCompactMinSize  = 1024 * 1024
+)
+
+// NewAdapterFile creates and returns a new log file cache object with given configuration.
+// It opens or creates the log file, and replays it to rebuild the in-memory index.
+func NewAdapterFile(config AdapterFileConfig) (Adapter, error) {
+	if config.Path == "" {
+		return nil, errors.New(`file path for cache adapter cannot be empty`)
+	}
+	if config.CompactInterval <= 0 {
+		config.CompactInterval = defaultAdapterFileCompactInterval
+	}
+	if config.CompactRatio <= 0 {
+		config.CompactRatio = defaultAdapterFileCompactRatio
+	}
+	if config.CompactMinSize <= 0 {
+		config.CompactMinSize = defaultAdapterFileCompactMinSize
+	}
+	c := &AdapterFile{
+		config: config,
+		index:  make(map[string]adapterFileIndexItem),
+		loader: newAdapterLoader(),
+		closed: types.NewBool(),
+	}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	timer.AddSingleton(context.Background(), config.CompactInterval, c.syncCompactAndClearExpired)
+	return c, nil
+}
+
+// Set sets cache with `key`-`value` pair, which is expired after `duration`.
+//
+// It does not expire if `duration` == 0.
+// It deletes the keys of `data` if `duration` < 0 or given `value` is nil.
+func (c *AdapterFile) Set(ctx context.Context, key interface{}, value interface{}, duration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.doSet(conv.String(key), value, duration)
+}
+
+// SetMap batch sets cache with key-value pairs by `data` map, which is expired after `duration`.
+//
+// It does not expire if `duration` == 0.
+// It deletes the keys of `data` if `duration` < 0 or given `value` is nil.
+func (c *AdapterFile) SetMap(ctx context.Context, data map[interface{}]interface{}, duration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range data {
+		if err := c.doSet(conv.String(k), v, duration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetIfNotExist sets cache with `key`-`value` pair which is expired after `duration`
+// if `key` does not exist in the cache. It returns true the `key` does not exist in the
+// cache, and it sets `value` successfully to the cache, or else it returns false.
+//
+// It does not expire if `duration` == 0.
+// It deletes the `key` if `duration` < 0 or given `value` is nil.
+func (c *AdapterFile) SetIfNotExist(ctx context.Context, key interface{}, value interface{}, duration time.Duration) (bool, error) {
+	f, ok := value.(Func)
+	if !ok {
+		// Compatible with raw function value.
+		f, ok = value.(func(ctx context.Context) (value interface{}, err error))
+	}
+	if ok {
+		return c.SetIfNotExistFuncLock(ctx, key, f, duration)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fileKey := conv.String(key)
+	if c.doContains(fileKey) {
+		return false, nil
+	}
+	if err := c.doSet(fileKey, value, duration); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetIfNotExistFunc sets `key` with result of function `f` and returns true
+// if `key` does not exist in the cache, or else it does nothing and returns false if `key` already exists.
+//
+// The parameter `value` can be type of `func() interface{}`, but it does nothing if its
+// result is nil.
+//
+// It does not expire if `duration` == 0.
+// It deletes the `key` if `duration` < 0 or given `value` is nil.
+func (c *AdapterFile) SetIfNotExistFunc(ctx context.Context, key interface{}, f Func, duration time.Duration) (bool, error) {
+	isContained, err := c.Contains(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if isContained {
+		return false, nil
+	}
+	value, err := f(ctx)
+	if err != nil {
+		return false, err
+	}
+	return c.SetIfNotExist(ctx, key, value, duration)
+}
+
+// SetIfNotExistFuncLock sets `key` with result of function `f` and returns true
+// if `key` does not exist in the cache, or else it does nothing and returns false if `key` already exists.
+//
+// It does not expire if `duration` == 0.
+// It deletes the `key` if `duration` < 0 or given `value` is nil.
+//
+// Note that it differs from function `SetIfNotExistFunc` is that the function `f` is executed only
+// once concurrently for the same `key` for concurrent safety purpose. The execution of `f` does not
+// block other operations of the cache, and it is cancelled if all the callers' `ctx` are done.
+func (c *AdapterFile) SetIfNotExistFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (bool, error) {
+	isContained, err := c.Contains(ctx, key)
+	if err != nil || isContained {
+		return false, err
+	}
+	_, inserted, err := c.doSetFuncWithLoader(ctx, conv.String(key), f, duration)
+	return inserted, err
+}
+
+// Get retrieves and returns the associated value of given `key`.
+// It returns nil if it does not exist, or its value is nil, or it's expired.
+// If you would like to check if the `key` exists in the cache, it's better using function Contains.
+func (c *AdapterFile) Get(ctx context.Context, key interface{}) (*vars.Var, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, ok, err := c.doGet(conv.String(key))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return vars.New(value), nil
+}
+
+// GetOrSet retrieves and returns the value of `key`, or sets `key`-`value` pair and
+// returns `value` if `key` does not exist in the cache. The key-value pair expires
+// after `duration`.
+//
+// It does not expire if `duration` == 0.
+// It deletes the `key` if `duration` < 0 or given `value` is nil, but it does nothing
+// if `value` is a function and the function result is nil.
+func (c *AdapterFile) GetOrSet(ctx context.Context, key interface{}, value interface{}, duration time.Duration) (*vars.Var, error) {
+	v, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v, _, err = c.doGetOrSetWithLock(ctx, conv.String(key), value, duration)
+		return v, err
+	}
+	return v, nil
+}
+
+// GetOrSetFunc retrieves and returns the value of `key`, or sets `key` with result of
+// function `f` and returns its result if `key` does not exist in the cache. The key-value
+// pair expires after `duration`.
+//
+// It does not expire if `duration` == 0.
+// It deletes the `key` if `duration` < 0 or given `value` is nil, but it does nothing
+// if `value` is a function and the function result is nil.
+func (c *AdapterFile) GetOrSetFunc(ctx context.Context, key interface{}, f Func, duration time.Duration) (*vars.Var, error) {
+	v, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		value, err := f(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, nil
+		}
+		v, _, err = c.doGetOrSetWithLock(ctx, conv.String(key), value, duration)
+		return v, err
+	}
+	return v, nil
+}
+
+// GetOrSetFuncLock retrieves and returns the value of `key`, or sets `key` with result of
+// function `f` and returns its result if `key` does not exist in the cache. The key-value
+// pair expires after `duration`.
+//
+// It does not expire if `duration` == 0.
+// It deletes the `key` if `duration` < 0 or given `value` is nil, but it does nothing
+// if `value` is a function and the function result is nil.
+//
+// Note that it differs from function `GetOrSetFunc` is that the function `f` is executed only
+// once concurrently for the same `key` for concurrent safety purpose. The execution of `f` does not
+// block other operations of the cache, and it is cancelled if all the callers' `ctx` are done.
+func (c *AdapterFile) GetOrSetFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (*vars.Var, error) {
+	v, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		value, _, err := c.doSetFuncWithLoader(ctx, conv.String(key), f, duration)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		return vars.New(value), nil
+	}
+	return v, nil
+}
+
+// Contains checks and returns true if `key` exists in the cache, or else returns false.
+func (c *AdapterFile) Contains(ctx context.Context, key interface{}) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.doContains(conv.String(key)), nil
+}
+
+// GetExpire retrieves and returns the expiration of `key` in the cache.
+//
+// Note that,
+// It returns 0 if the `key` does not expire.
+// It returns -1 if the `key` does not exist in the cache.
+func (c *AdapterFile) GetExpire(ctx context.Context, key interface{}) (time.Duration, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	item, ok := c.index[conv.String(key)]
+	if !ok || item.IsExpired() {
+		return -1, nil
+	}
+	if item.e == defaultMaxExpire {
+		return 0, nil
+	}
+	return time.Duration(item.e-times.TimestampMilli()) * time.Millisecond, nil
+}
+
+// Remove deletes one or more keys from cache, and returns its value.
+// If multiple keys are given, it returns the value of the last deleted item.
+func (c *AdapterFile) Remove(ctx context.Context, keys ...interface{}) (*vars.Var, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var lastValue interface{}
+	for _, key := range keys {
+		fileKey := conv.String(key)
+		value, ok, err := c.doGet(fileKey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err = c.doDelete(fileKey); err != nil {
+			return nil, err
+		}
+		lastValue = value
+	}
+	return vars.New(lastValue), nil
+}
+
+// Update updates the value of `key` without changing its expiration and returns the old value.
+// The returned value `exist` is false if the `key` does not exist in the cache.
+//
+// It deletes the `key` if given `value` is nil.
+// It does nothing if `key` does not exist in the cache.
+func (c *AdapterFile) Update(ctx context.Context, key interface{}, value interface{}) (oldValue *vars.Var, exist bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fileKey := conv.String(key)
+	item, ok := c.index[fileKey]
+	if !ok || item.IsExpired() {
+		return nil, false, nil
+	}
+	v, _, err := c.doGet(fileKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if value == nil {
+		err = c.doDelete(fileKey)
+	} else {
+		err = c.doSetWithExpire(fileKey, value, item.e)
+	}
+	return vars.New(v), true, err
+}
+
+// UpdateExpire updates the expiration of `key` and returns the old expiration duration value.
+//
+// It returns -1 and does nothing if the `key` does not exist in the cache.
+// It deletes the `key` if `duration` < 0.
+func (c *AdapterFile) UpdateExpire(ctx context.Context, key interface{}, duration time.Duration) (oldDuration time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fileKey := conv.String(key)
+	item, ok := c.index[fileKey]
+	if !ok || item.IsExpired() {
+		return -1, nil
+	}
+	oldDuration = time.Duration(item.e-times.TimestampMilli()) * time.Millisecond
+	value, _, err := c.doGet(fileKey)
+	if err != nil {
+		return
+	}
+	err = c.doSet(fileKey, value, duration)
+	return
+}
+
+// Size returns the number of items in the cache.
+func (c *AdapterFile) Size(ctx context.Context) (size int, err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, item := range c.index {
+		if !item.IsExpired() {
+			size++
+		}
+	}
+	return
+}
+
+// Data returns a copy of all key-value pairs in the cache as map type.
+// Note that this function reads all values from the log file, which may lead lots of
+// disk reading and memory usage.
+func (c *AdapterFile) Data(ctx context.Context) (map[interface{}]interface{}, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	data := make(map[interface{}]interface{}, len(c.index))
+	for k, item := range c.index {
+		if item.IsExpired() {
+			continue
+		}
+		value, err := c.readValue(k, item)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = value
+	}
+	return data, nil
+}
+
+// Keys returns all keys in the cache as slice.
+func (c *AdapterFile) Keys(ctx context.Context) ([]interface{}, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]interface{}, 0, len(c.index))
+	for k, item := range c.index {
+		if !item.IsExpired() {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// Values returns all values in the cache as slice.
+func (c *AdapterFile) Values(ctx context.Context) ([]interface{}, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	values := make([]interface{}, 0, len(c.index))
+	for k, item := range c.index {
+		if item.IsExpired() {
+			continue
+		}
+		value, err := c.readValue(k, item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Clear clears all data of the cache.
+// Note that this function is sensitive and should be carefully used.
+// It truncates the log file.
+func (c *AdapterFile) Clear(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.file.Truncate(0); err != nil {
+		return errors.Wrapf(err, `truncate cache file "%s" failed`, c.config.Path)
+	}
+	c.size = 0
+	c.garbage = 0
+	c.index = make(map[string]adapterFileIndexItem)
+	return c.sync()
+}
+
+// Close closes the cache.
+// It flushes the log file to disk and closes it.
+func (c *AdapterFile) Close(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed.Val() {
+		return nil
+	}
+	c.closed.Set(true)
+	if err := c.file.Sync(); err != nil {
+		return errors.Wrapf(err, `sync cache file "%s" failed`, c.config.Path)
+	}
+	if err := c.file.Close(); err != nil {
+		return errors.Wrapf(err, `close cache file "%s" failed`, c.config.Path)
+	}
+	return nil
+}
+
+// SetLoaderTimeout sets the execution timeout for the cache function `f` of functions
+// GetOrSetFuncLock and SetIfNotExistFuncLock. The context of `f` is cancelled after `timeout`,
+// and the callers waiting for it return with error.
+//
+// It does not limit the execution if `timeout` <= 0, which is the default.
+func (c *AdapterFile) SetLoaderTimeout(timeout time.Duration) {
+	c.loader.SetTimeout(timeout)
+}
+
+// Compact rewrites the log file with only the live records, which drops all stale and
+// expired records from the file.
+//
+// It is automatically called in background if the stale records take more than
+// `CompactRatio` of the file, you can also call it manually.
+func (c *AdapterFile) Compact(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compact()
+}
+
+// doContains checks whether `key` exists in the cache and is not expired.
+// It should be called within the lock.
+func (c *AdapterFile) doContains(key string) bool {
+	item, ok := c.index[key]
+	return ok && !item.IsExpired()
+}
+
+// doGet retrieves the value of `key` from the log file.
+// It should be called within the lock.
+func (c *AdapterFile) doGet(key string) (value interface{}, ok bool, err error) {
+	item, ok := c.index[key]
+	if !ok || item.IsExpired() {
+		return nil, false, nil
+	}
+	if value, err = c.readValue(key, item); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// doSet writes `key`-`value` pair with `duration` to the log file.
+// It deletes the `key` if `duration` < 0 or given `value` is nil.
+// It should be called within the writing lock.
+func (c *AdapterFile) doSet(key string, value interface{}, duration time.Duration) error {
+	if value == nil || duration < 0 {
+		return c.doDelete(key)
+	}
+	return c.doSetWithExpire(key, value, c.getInternalExpire(duration))
+}
+
+// doSetWithExpire writes `key`-`value` pair with expire timestamp `expire` to the log file.
+// It should be called within the writing lock.
+func (c *AdapterFile) doSetWithExpire(key string, value interface{}, expire int64) error {
+	item, err := c.appendRecord(adapterFileOpSet, key, value, expire)
+	if err != nil {
+		return err
+	}
+	if old, ok := c.index[key]; ok {
+		c.garbage += old.size
+	}
+	c.index[key] = item
+	return c.sync()
+}
+
+// doDelete writes a deletion record of `key` to the log file if it exists in the index.
+// It should be called within the writing lock.
+func (c *AdapterFile) doDelete(key string) error {
+	old, ok := c.index[key]
+	if !ok {
+		return nil
+	}
+	item, err := c.appendRecord(adapterFileOpDelete, key, nil, 0)
+	if err != nil {
+		return err
+	}
+	// Both the deleted record and the deletion record itself are stale.
+	c.garbage += old.size + item.size
+	delete(c.index, key)
+	return c.sync()
+}
+
+// doGetOrSetWithLock sets `key`-`value` pair if `key` does not exist in the cache, or else it
+// returns the existing value. The parameter `value` can be type of Func, which is executed
+// within the writing lock and does nothing if its result is nil.
+// The returned `inserted` is true only if `value` is set.
+func (c *AdapterFile) doGetOrSetWithLock(ctx context.Context, key string, value interface{}, duration time.Duration) (result *vars.Var, inserted bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok, err := c.doGet(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		return vars.New(v), false, nil
+	}
+	f, ok := value.(Func)
+	if !ok {
+		// Compatible with raw function value.
+		f, ok = value.(func(ctx context.Context) (value interface{}, err error))
+	}
+	if ok {
+		if value, err = f(ctx); err != nil {
+			return nil, false, err
+		}
+		if value == nil {
+			return nil, false, nil
+		}
+	}
+	if err = c.doSet(key, value, duration); err != nil {
+		return nil, false, err
+	}
+	return vars.New(value), true, nil
+}
+
+// doSetFuncWithLoader sets `key` with result of function `f` if `key` does not exist in the cache,
+// which is expired after `duration`. The function `f` is executed by the loader, which shares its
+// result among concurrent callers of the same `key`.
+//
+// It returns the value in the cache after setting, or nil if the result of `f` is nil.
+// The returned `inserted` is true only for the caller whose loading sets the value.
+func (c *AdapterFile) doSetFuncWithLoader(ctx context.Context, key string, f Func, duration time.Duration) (value interface{}, inserted bool, err error) {
+	return c.loader.Load(ctx, key, f, func(ctx context.Context, value interface{}) (interface{}, bool, error) {
+		result, inserted, err := c.doGetOrSetWithLock(ctx, key, value, duration)
+		if err != nil {
+			return nil, false, err
+		}
+		return result.Val(), inserted, nil
+	})
+}
+
+// getInternalExpire converts and returns the expiration time with given expired duration in milliseconds.
+func (c *AdapterFile) getInternalExpire(duration time.Duration) int64 {
+	if duration == 0 {
+		return defaultMaxExpire
+	}
+	return times.TimestampMilli() + duration.Nanoseconds()/1000000
+}
+
+// sync flushes the log file to disk if `SyncWrite` is enabled.
+func (c *AdapterFile) sync() error {
+	if !c.config.SyncWrite {
+		return nil
+	}
+	if err := c.file.Sync(); err != nil {
+		return errors.Wrapf(err, `sync cache file "%s" failed`, c.config.Path)
+	}
+	return nil
+}
+
+// syncCompactAndClearExpired does the asynchronous task loop:
+// 1. Drops the expired keys from the in-memory index, marking their records stale.
+// 2. Compacts the log file if the stale records exceed the configured ratio.
+func (c *AdapterFile) syncCompactAndClearExpired(ctx context.Context) {
+	if c.closed.Val() {
+		timer.Exit()
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed.Val() {
+		return
+	}
+	for k, item := range c.index {
+		if item.IsExpired() {
+			c.garbage += item.size
+			delete(c.index, k)
+		}
+	}
+	if c.size < c.config.CompactMinSize || float64(c.garbage) < float64(c.size)*c.config.CompactRatio {
+		return
+	}
+	if err := c.compact(); err != nil {
+		intlog.Errorf(ctx, `%+v`, err)
+	}
+}
+
+// IsExpired checks whether `item` is expired.
+func (item adapterFileIndexItem) IsExpired() bool {
+	return item.e < times.TimestampMilli()
+}
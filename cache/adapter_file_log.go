@@ -0,0 +1,282 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gocarp/errors"
+	"github.com/gocarp/helpers/intlog"
+	"github.com/gocarp/helpers/json"
+)
+
+// Record layout in the log file, all integers are in little endian:
+//
+//	| crc32 (4) | op (1) | expire (8) | key length (4) | value length (4) | key | value |
+//
+// The crc32 checksum covers all the bytes after itself.
+const (
+	adapterFileHeaderSize     = 21
+	adapterFileMaxKeySize     = 1 << 16
+	adapterFileMaxValueSize   = 1 << 30
+	adapterFileCompactSuffix  = ".compact"
+	adapterFileOpSet          = byte(1)
+	adapterFileOpDelete       = byte(2)
+	adapterFileOpenFlag       = os.O_CREATE | os.O_RDWR | os.O_APPEND
+	adapterFileOpenPermission = 0666
+)
+
+// open opens or creates the log file and replays it to rebuild the index.
+func (c *AdapterFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(c.config.Path), 0755); err != nil {
+		return errors.Wrapf(err, `create directory for cache file "%s" failed`, c.config.Path)
+	}
+	// The compaction file is useless if it exists, as the process crashed before it replaced
+	// the log file.
+	_ = os.Remove(c.config.Path + adapterFileCompactSuffix)
+	file, err := os.OpenFile(c.config.Path, adapterFileOpenFlag, adapterFileOpenPermission)
+	if err != nil {
+		return errors.Wrapf(err, `open cache file "%s" failed`, c.config.Path)
+	}
+	c.file = file
+	if err = c.replay(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return nil
+}
+
+// replay reads all records from the beginning of the log file and rebuilds the index.
+// It stops at the first torn or corrupted record, which is the result of a crash during
+// appending, and truncates the log file at that position.
+func (c *AdapterFile) replay() error {
+	if _, err := c.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrapf(err, `seek cache file "%s" failed`, c.config.Path)
+	}
+	var (
+		offset int64
+		reader = bufio.NewReader(c.file)
+	)
+	for {
+		op, key, _, expire, size, err := c.readRecord(reader)
+		if err != nil {
+			if err != io.EOF {
+				intlog.Printf(
+					context.TODO(),
+					`cache file "%s" is truncated at offset %d for broken record: %v`,
+					c.config.Path, offset, err,
+				)
+				if err = c.file.Truncate(offset); err != nil {
+					return errors.Wrapf(err, `truncate cache file "%s" failed`, c.config.Path)
+				}
+			}
+			break
+		}
+		old, exists := c.index[key]
+		switch op {
+		case adapterFileOpSet:
+			if exists {
+				c.garbage += old.size
+			}
+			c.index[key] = adapterFileIndexItem{
+				offset: offset,
+				size:   size,
+				e:      expire,
+			}
+		case adapterFileOpDelete:
+			if exists {
+				c.garbage += old.size
+				delete(c.index, key)
+			}
+			c.garbage += size
+		}
+		offset += size
+	}
+	c.size = offset
+	return nil
+}
+
+// readRecord reads and verifies the next record from `reader`.
+// It returns io.EOF only if there's no more data at the record boundary.
+func (c *AdapterFile) readRecord(reader io.Reader) (op byte, key string, value []byte, expire, size int64, err error) {
+	header := make([]byte, adapterFileHeaderSize)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New(`unexpected end of record header`)
+		}
+		return
+	}
+	var (
+		checksum    = binary.LittleEndian.Uint32(header[0:4])
+		keyLength   = binary.LittleEndian.Uint32(header[13:17])
+		valueLength = binary.LittleEndian.Uint32(header[17:21])
+	)
+	op = header[4]
+	expire = int64(binary.LittleEndian.Uint64(header[5:13]))
+	if (op != adapterFileOpSet && op != adapterFileOpDelete) ||
+		keyLength > adapterFileMaxKeySize || valueLength > adapterFileMaxValueSize {
+		err = errors.New(`invalid record header`)
+		return
+	}
+	body := make([]byte, keyLength+valueLength)
+	if _, err = io.ReadFull(reader, body); err != nil {
+		err = errors.New(`unexpected end of record body`)
+		return
+	}
+	hash := crc32.NewIEEE()
+	_, _ = hash.Write(header[4:])
+	_, _ = hash.Write(body)
+	if hash.Sum32() != checksum {
+		err = errors.New(`record checksum mismatch`)
+		return
+	}
+	key = string(body[:keyLength])
+	value = body[keyLength:]
+	size = int64(adapterFileHeaderSize + len(body))
+	return
+}
+
+// encodeRecord encodes and returns the record bytes.
+func (c *AdapterFile) encodeRecord(op byte, key string, value []byte, expire int64) []byte {
+	buffer := make([]byte, adapterFileHeaderSize+len(key)+len(value))
+	buffer[4] = op
+	binary.LittleEndian.PutUint64(buffer[5:13], uint64(expire))
+	binary.LittleEndian.PutUint32(buffer[13:17], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buffer[17:21], uint32(len(value)))
+	copy(buffer[adapterFileHeaderSize:], key)
+	copy(buffer[adapterFileHeaderSize+len(key):], value)
+	binary.LittleEndian.PutUint32(buffer[0:4], crc32.ChecksumIEEE(buffer[4:]))
+	return buffer
+}
+
+// appendRecord encodes `value` as JSON and appends the record to the end of the log file.
+// It should be called within the writing lock.
+func (c *AdapterFile) appendRecord(op byte, key string, value interface{}, expire int64) (item adapterFileIndexItem, err error) {
+	if len(key) > adapterFileMaxKeySize {
+		return item, errors.Newf(`key size %d exceeds the limit %d`, len(key), adapterFileMaxKeySize)
+	}
+	var valueBytes []byte
+	if op == adapterFileOpSet {
+		if valueBytes, err = json.Marshal(value); err != nil {
+			return item, errors.Wrapf(err, `encode value for key "%s" failed`, key)
+		}
+		if len(valueBytes) > adapterFileMaxValueSize {
+			return item, errors.Newf(`value size %d exceeds the limit %d`, len(valueBytes), adapterFileMaxValueSize)
+		}
+	}
+	return c.appendRawRecord(op, key, valueBytes, expire)
+}
+
+// appendRawRecord appends the record with encoded `value` to the end of the log file.
+// It should be called within the writing lock.
+func (c *AdapterFile) appendRawRecord(op byte, key string, value []byte, expire int64) (item adapterFileIndexItem, err error) {
+	buffer := c.encodeRecord(op, key, value, expire)
+	if _, err = c.file.Write(buffer); err != nil {
+		// Drop the possibly partially written record.
+		_ = c.file.Truncate(c.size)
+		return item, errors.Wrapf(err, `write cache file "%s" failed`, c.config.Path)
+	}
+	item = adapterFileIndexItem{
+		offset: c.size,
+		size:   int64(len(buffer)),
+		e:      expire,
+	}
+	c.size += item.size
+	return item, nil
+}
+
+// readRawValue reads and returns the encoded value bytes of `key` from the log file.
+func (c *AdapterFile) readRawValue(key string, item adapterFileIndexItem) ([]byte, error) {
+	var (
+		valueOffset = item.offset + adapterFileHeaderSize + int64(len(key))
+		valueBytes  = make([]byte, item.size-adapterFileHeaderSize-int64(len(key)))
+	)
+	if _, err := c.file.ReadAt(valueBytes, valueOffset); err != nil {
+		return nil, errors.Wrapf(err, `read cache file "%s" failed for key "%s"`, c.config.Path, key)
+	}
+	return valueBytes, nil
+}
+
+// readValue reads and decodes the value of `key` from the log file.
+func (c *AdapterFile) readValue(key string, item adapterFileIndexItem) (value interface{}, err error) {
+	valueBytes, err := c.readRawValue(key, item)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.UnmarshalUseNumber(valueBytes, &value); err != nil {
+		return nil, errors.Wrapf(err, `decode value for key "%s" failed`, key)
+	}
+	return value, nil
+}
+
+// compact writes all live records to a temporary file, and then atomically replaces
+// the log file with it. It should be called within the writing lock.
+//
+// The temporary file is opened for appending at the beginning and its handle is kept after
+// replacing, so that the state is either fully swapped or left as it was if any step fails.
+func (c *AdapterFile) compact() (err error) {
+	var (
+		compactPath = c.config.Path + adapterFileCompactSuffix
+		newIndex    = make(map[string]adapterFileIndexItem, len(c.index))
+		newOffset   int64
+	)
+	compactFile, err := os.OpenFile(compactPath, adapterFileOpenFlag|os.O_TRUNC, adapterFileOpenPermission)
+	if err != nil {
+		return errors.Wrapf(err, `create compaction file "%s" failed`, compactPath)
+	}
+	defer func() {
+		if err != nil {
+			_ = compactFile.Close()
+			_ = os.Remove(compactPath)
+		}
+	}()
+	writer := bufio.NewWriter(compactFile)
+	for key, item := range c.index {
+		if item.IsExpired() {
+			continue
+		}
+		var valueBytes []byte
+		if valueBytes, err = c.readRawValue(key, item); err != nil {
+			return err
+		}
+		buffer := c.encodeRecord(adapterFileOpSet, key, valueBytes, item.e)
+		if _, err = writer.Write(buffer); err != nil {
+			return errors.Wrapf(err, `write compaction file "%s" failed`, compactPath)
+		}
+		newIndex[key] = adapterFileIndexItem{
+			offset: newOffset,
+			size:   int64(len(buffer)),
+			e:      item.e,
+		}
+		newOffset += int64(len(buffer))
+	}
+	if err = writer.Flush(); err != nil {
+		return errors.Wrapf(err, `write compaction file "%s" failed`, compactPath)
+	}
+	if err = compactFile.Sync(); err != nil {
+		return errors.Wrapf(err, `sync compaction file "%s" failed`, compactPath)
+	}
+	if err = os.Rename(compactPath, c.config.Path); err != nil {
+		return errors.Wrapf(err, `replace cache file "%s" failed`, c.config.Path)
+	}
+	// The log file is already replaced, so the old file handle is useless.
+	_ = c.file.Close()
+	intlog.Printf(
+		context.TODO(),
+		`cache file "%s" compacted from %d bytes to %d bytes`,
+		c.config.Path, c.size, newOffset,
+	)
+	c.file = compactFile
+	c.index = newIndex
+	c.size = newOffset
+	c.garbage = 0
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestAdapterFile(t *testing.T, path string) *AdapterFile {
+	t.Helper()
+	adapter, err := NewAdapterFile(AdapterFileConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adapter.(*AdapterFile)
+}
+
+func TestAdapterFile_Compact(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		path = filepath.Join(t.TempDir(), "cache.log")
+		c    = newTestAdapterFile(t, path)
+	)
+	for i := 0; i < 100; i++ {
+		if err := c.Set(ctx, "k", i, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = c.Set(ctx, "removed", 1, 0)
+	_, _ = c.Remove(ctx, "removed")
+	before, _ := os.Stat(path)
+	if err := c.Compact(ctx); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf(`file size %d is not reduced from %d`, after.Size(), before.Size())
+	}
+	if _, err := os.Stat(path + adapterFileCompactSuffix); !os.IsNotExist(err) {
+		t.Fatalf(`compaction file is left: %v`, err)
+	}
+	// The writes after compaction go to the replaced file.
+	if err := c.Set(ctx, "after", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get(ctx, "k"); v.Int() != 99 {
+		t.Fatalf(`got %v, want 99`, v)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c = newTestAdapterFile(t, path)
+	defer c.Close(ctx)
+	if v, _ := c.Get(ctx, "k"); v.Int() != 99 {
+		t.Fatalf(`got %v after reopening, want 99`, v)
+	}
+	if v, _ := c.Get(ctx, "after"); v.String() != "v" {
+		t.Fatalf(`got %v after reopening, want "v"`, v)
+	}
+	if ok, _ := c.Contains(ctx, "removed"); ok {
+		t.Fatal(`removed key exists after reopening`)
+	}
+}
+
+func TestAdapterFile_ReplayTornRecord(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		path = filepath.Join(t.TempDir(), "cache.log")
+		c    = newTestAdapterFile(t, path)
+	)
+	_ = c.Set(ctx, "k", "v", 0)
+	_ = c.Close(ctx)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.Write([]byte{1, 2, 3, 4, 5})
+	_ = file.Close()
+
+	c = newTestAdapterFile(t, path)
+	defer c.Close(ctx)
+	if v, _ := c.Get(ctx, "k"); v.String() != "v" {
+		t.Fatalf(`got %v, want "v"`, v)
+	}
+	if err = c.Set(ctx, "k2", "v2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get(ctx, "k2"); v.String() != "v2" {
+		t.Fatalf(`got %v, want "v2"`, v)
+	}
+}
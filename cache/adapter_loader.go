@@ -0,0 +1,175 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+	"github.com/gocarp/go/container/types"
+)
+
+// adapterLoader executes the cache function `Func` of the same key only once at a time,
+// and shares its result among all concurrent callers of the key.
+//
+// The function is executed outside any data lock in its own goroutine, with a context that
+// is detached from the callers. Each caller waits for the result until its own context is
+// done, and abandoning the waiting does not affect the other callers. The function context
+// is cancelled only if all the callers abandon it, or the loader timeout is reached, in which
+// case the loading is detached from the key and its result is not stored to the cache.
+type adapterLoader struct {
+	mu      sync.Mutex                         // mu ensures the concurrent safety of calls.
+	calls   map[interface{}]*adapterLoaderCall // calls is the key to its in-flight loading mapping.
+	timeout *types.Int64                       // timeout is the execution timeout in nanoseconds for Func, 0 means no timeout.
+}
+
+// adapterLoaderCall is an in-flight or completed loading of a key.
+type adapterLoaderCall struct {
+	ctx      context.Context    // ctx is the context for Func execution.
+	cancel   context.CancelFunc // cancel cancels the context of Func execution.
+	done     chan struct{}      // done is closed when the loading completes.
+	waiters  int                // waiters is the number of callers waiting for the result.
+	value    interface{}        // value is the loaded value.
+	inserted bool               // inserted marks that the loaded value is inserted to the cache by this loading.
+	err      error              // err is the loading error.
+}
+
+// adapterLoaderStoreFunc stores the value loaded by Func to the cache and returns the value
+// that is finally in the cache. The returned `inserted` is true only if the `value` is inserted
+// by this storing, but not existing in the cache already.
+type adapterLoaderStoreFunc func(ctx context.Context, value interface{}) (result interface{}, inserted bool, err error)
+
+func newAdapterLoader() *adapterLoader {
+	return &adapterLoader{
+		calls:   make(map[interface{}]*adapterLoaderCall),
+		timeout: types.NewInt64(),
+	}
+}
+
+// SetTimeout sets the execution timeout for Func.
+// It does not limit the execution if `timeout` <= 0.
+func (l *adapterLoader) SetTimeout(timeout time.Duration) {
+	l.timeout.Set(int64(timeout))
+}
+
+// Load executes `f` for `key` if there's no in-flight loading for `key`, or else it waits
+// for the in-flight loading. The non-nil result of `f` is stored to the cache using `store`.
+//
+// The returned `inserted` is true only for the caller that starts the loading, and only if
+// the value is inserted to the cache by the loading, so that the callers sharing the result
+// can tell that they do not set the value.
+//
+// It returns the error of `ctx` if `ctx` is done before the loading completes, and the
+// loading continues for other callers.
+func (l *adapterLoader) Load(
+	ctx context.Context, key interface{}, f Func, store adapterLoaderStoreFunc,
+) (value interface{}, inserted bool, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, false, errors.Wrapf(err, `loading cache for key "%v" cancelled`, key)
+	}
+	l.mu.Lock()
+	call, joined := l.calls[key]
+	if !joined {
+		call = &adapterLoaderCall{
+			done: make(chan struct{}),
+		}
+		// The loading context keeps the values of caller's context, but not its cancellation.
+		call.ctx, call.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		if timeout := time.Duration(l.timeout.Val()); timeout > 0 {
+			var cancel context.CancelFunc
+			call.ctx, cancel = context.WithTimeout(call.ctx, timeout)
+			parentCancel := call.cancel
+			call.cancel = func() {
+				cancel()
+				parentCancel()
+			}
+		}
+		l.calls[key] = call
+		go l.doLoad(key, call, f, store)
+	}
+	call.waiters++
+	l.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.inserted && !joined, call.err
+
+	case <-call.ctx.Done():
+		// The loading exceeds its timeout, but function `f` does not return yet.
+		select {
+		case <-call.done:
+			return call.value, call.inserted && !joined, call.err
+		default:
+			l.detach(key, call)
+			return nil, false, l.doneError(key, call.ctx.Err())
+		}
+
+	case <-ctx.Done():
+		l.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// All the callers abandon the loading, so the following callers start a new loading
+			// instead of joining the cancelled one.
+			call.cancel()
+			if l.calls[key] == call {
+				delete(l.calls, key)
+			}
+		}
+		l.mu.Unlock()
+		return nil, false, errors.Wrapf(ctx.Err(), `loading cache for key "%v" cancelled`, key)
+	}
+}
+
+// detach removes `call` from the in-flight loadings of `key` if it is still there.
+func (l *adapterLoader) detach(key interface{}, call *adapterLoaderCall) {
+	l.mu.Lock()
+	if l.calls[key] == call {
+		delete(l.calls, key)
+	}
+	l.mu.Unlock()
+}
+
+// doLoad executes `f` and stores its result, and then notifies all the waiters.
+// The result is not stored if the loading is cancelled or timeout, as it may be stale
+// for the loading started after it.
+func (l *adapterLoader) doLoad(key interface{}, call *adapterLoaderCall, f Func, store adapterLoaderStoreFunc) {
+	defer func() {
+		if exception := recover(); exception != nil {
+			if e, ok := exception.(error); ok {
+				call.err = errors.WrapCode(codes.CodeInternalPanic, e)
+			} else {
+				call.err = errors.NewCodef(codes.CodeInternalPanic, "%+v", exception)
+			}
+		}
+		l.detach(key, call)
+		close(call.done)
+		call.cancel()
+	}()
+	value, err := f(call.ctx)
+	if err != nil {
+		call.err = err
+		return
+	}
+	if err = call.ctx.Err(); err != nil {
+		call.err = l.doneError(key, err)
+		return
+	}
+	if value == nil {
+		return
+	}
+	call.value, call.inserted, call.err = store(call.ctx, value)
+}
+
+// doneError wraps the error `err` of the done loading context, which is labelled as timeout
+// only if the loader timeout is reached, or else as cancelled.
+func (l *adapterLoader) doneError(key interface{}, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errors.Wrapf(err, `loading cache for key "%v" timeout`, key)
+	}
+	return errors.Wrapf(err, `loading cache for key "%v" cancelled`, key)
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestLoaderFunc returns a cache function returning `value` after `release` is closed,
+// which counts its executions to `calls`.
+func newTestLoaderFunc(value interface{}, release chan struct{}, calls *int32) Func {
+	return func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(calls, 1)
+		select {
+		case <-release:
+			return value, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestAdapterLoader_Shared(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		c       = New()
+		calls   int32
+		release = make(chan struct{})
+		f       = newTestLoaderFunc("v", release, &calls)
+		wg      sync.WaitGroup
+		results = make(chan interface{}, 10)
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrSetFuncLock(ctx, "k", f, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results <- v.Val()
+		}()
+	}
+	// The other operations are not blocked by the loading.
+	time.Sleep(10 * time.Millisecond)
+	if err := c.Set(ctx, "other", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+	for v := range results {
+		if v != "v" {
+			t.Fatalf(`got %v, want "v"`, v)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf(`function executed %d times, want 1`, n)
+	}
+}
+
+func TestAdapterLoader_WaiterLeaves(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		c       = New()
+		calls   int32
+		release = make(chan struct{})
+		f       = newTestLoaderFunc("v", release, &calls)
+		done    = make(chan interface{})
+	)
+	go func() {
+		v, err := c.GetOrSetFuncLock(ctx, "k", f, 0)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- v.Val()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	leaveCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetOrSetFuncLock(leaveCtx, "k", f, 0); err == nil {
+		t.Fatal(`expected error of leaving waiter`)
+	}
+	// The leaving waiter does not affect the others.
+	close(release)
+	if v := <-done; v != "v" {
+		t.Fatalf(`got %v, want "v"`, v)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf(`function executed %d times, want 1`, n)
+	}
+}
+
+func TestAdapterLoader_Abandoned(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		c       = New()
+		blocked = make(chan struct{})
+		started = make(chan struct{})
+	)
+	abandonCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err := c.GetOrSetFuncLock(abandonCtx, "k", func(ctx context.Context) (interface{}, error) {
+		close(started)
+		// It ignores the cancellation and returns after the following loading.
+		<-blocked
+		return "stale", nil
+	}, 0)
+	if err == nil {
+		t.Fatal(`expected error of abandoned loading`)
+	}
+	// The following caller does not join the abandoned loading.
+	v, err := c.GetOrSetFuncLock(ctx, "k", func(ctx context.Context) (interface{}, error) {
+		return "fresh", nil
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Val() != "fresh" {
+		t.Fatalf(`got %v, want "fresh"`, v)
+	}
+	// The result of the abandoned loading is not stored.
+	close(blocked)
+	time.Sleep(10 * time.Millisecond)
+	if v, _ = c.Get(ctx, "k"); v.Val() != "fresh" {
+		t.Fatalf(`got %v after abandoned loading returns, want "fresh"`, v)
+	}
+}
+
+func TestAdapterLoader_Timeout(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		c       = New()
+		calls   int32
+		release = make(chan struct{})
+		f       = newTestLoaderFunc("v", release, &calls)
+	)
+	defer close(release)
+	c.GetAdapter().(*AdapterMemory).SetLoaderTimeout(10 * time.Millisecond)
+	_, err := c.GetOrSetFuncLock(ctx, "k", f, 0)
+	if err == nil {
+		t.Fatal(`expected timeout error`)
+	}
+	if ok, _ := c.Contains(ctx, "k"); ok {
+		t.Fatal(`key is set after timeout`)
+	}
+}
+
+func TestAdapterLoader_SetIfNotExistFuncLock(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		adapters = map[string]Adapter{
+			"memory": NewAdapterMemory(),
+			"file":   newTestAdapterFile(t, t.TempDir()+"/cache.log"),
+		}
+	)
+	for name, adapter := range adapters {
+		t.Run(name, func(t *testing.T) {
+			var (
+				wg       sync.WaitGroup
+				inserted int32
+				calls    int32
+				release  = make(chan struct{})
+				f        = newTestLoaderFunc("v", release, &calls)
+			)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					ok, err := adapter.SetIfNotExistFuncLock(ctx, "k", f, 0)
+					if err != nil {
+						t.Error(err)
+					}
+					if ok {
+						atomic.AddInt32(&inserted, 1)
+					}
+				}()
+			}
+			time.Sleep(10 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			if n := atomic.LoadInt32(&inserted); n != 1 {
+				t.Fatalf(`%d callers get true, want 1`, n)
+			}
+			// The key set concurrently is not reported as inserted.
+			ok, err := adapter.SetIfNotExistFuncLock(ctx, "k2", func(ctx context.Context) (interface{}, error) {
+				_ = adapter.Set(ctx, "k2", "other", 0)
+				return "v", nil
+			}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				t.Fatal(`got true for the key set concurrently`)
+			}
+			if v, _ := adapter.Get(ctx, "k2"); v.Val() != "other" {
+				t.Fatalf(`got %v, want "other"`, v)
+			}
+			_ = adapter.Close(ctx)
+		})
+	}
+}
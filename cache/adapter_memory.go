@@ -30,13 +30,15 @@ type AdapterMemory struct {
 	lru         *adapterMemoryLru         // lru is the LRU manager, which is enabled when attribute cap > 0.
 	lruGetList  *list.List                // lruGetList is the LRU history according to Get function.
 	eventList   *list.List                // eventList is the asynchronous event list for internal data synchronization.
+	loader      *adapterLoader            // loader executes the cache functions for locking operations.
 	closed      *types.Bool               // closed controls the cache closed or not.
 }
 
 // Internal cache item.
 type adapterMemoryItem struct {
-	v interface{} // Value.
-	e int64       // Expire timestamp in milliseconds.
+	v interface{}            // Value.
+	e int64                  // Expire timestamp in milliseconds.
+	m *adapterMemoryItemMeta // Metadata for inspection.
 }
 
 // Internal event item.
@@ -59,6 +61,7 @@ func NewAdapterMemory(lruCap ...int) Adapter {
 		expireTimes: newAdapterMemoryExpireTimes(),
 		expireSets:  newAdapterMemoryExpireSets(),
 		eventList:   list.New(true),
+		loader:      newAdapterLoader(),
 		closed:      types.NewBool(),
 	}
 	if len(lruCap) > 0 {
@@ -80,6 +83,7 @@ func (c *AdapterMemory) Set(ctx context.Context, key interface{}, value interfac
 	c.data.Set(key, adapterMemoryItem{
 		v: value,
 		e: expireTime,
+		m: newAdapterMemoryItemMeta(),
 	})
 	c.eventList.PushBack(&adapterMemoryEvent{
 		k: key,
@@ -121,10 +125,8 @@ func (c *AdapterMemory) SetIfNotExist(ctx context.Context, key interface{}, valu
 		return false, err
 	}
 	if !isContained {
-		if _, err = c.doSetWithLockCheck(ctx, key, value, duration); err != nil {
-			return false, err
-		}
-		return true, nil
+		_, inserted, err := c.doSetWithLockCheck(ctx, key, value, duration)
+		return inserted, err
 	}
 	return false, nil
 }
@@ -147,10 +149,8 @@ func (c *AdapterMemory) SetIfNotExistFunc(ctx context.Context, key interface{},
 		if err != nil {
 			return false, err
 		}
-		if _, err = c.doSetWithLockCheck(ctx, key, value, duration); err != nil {
-			return false, err
-		}
-		return true, nil
+		_, inserted, err := c.doSetWithLockCheck(ctx, key, value, duration)
+		return inserted, err
 	}
 	return false, nil
 }
@@ -161,18 +161,17 @@ func (c *AdapterMemory) SetIfNotExistFunc(ctx context.Context, key interface{},
 // It does not expire if `duration` == 0.
 // It deletes the `key` if `duration` < 0 or given `value` is nil.
 //
-// Note that it differs from function `SetIfNotExistFunc` is that the function `f` is executed within
-// writing mutex lock for concurrent safety purpose.
+// Note that it differs from function `SetIfNotExistFunc` is that the function `f` is executed only
+// once concurrently for the same `key` for concurrent safety purpose. The execution of `f` does not
+// block other operations of the cache, and it is cancelled if all the callers' `ctx` are done.
 func (c *AdapterMemory) SetIfNotExistFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (bool, error) {
 	isContained, err := c.Contains(ctx, key)
 	if err != nil {
 		return false, err
 	}
 	if !isContained {
-		if _, err = c.doSetWithLockCheck(ctx, key, f, duration); err != nil {
-			return false, err
-		}
-		return true, nil
+		_, inserted, err := c.doSetFuncWithLoader(ctx, key, f, duration)
+		return inserted, err
 	}
 	return false, nil
 }
@@ -183,6 +182,7 @@ func (c *AdapterMemory) SetIfNotExistFuncLock(ctx context.Context, key interface
 func (c *AdapterMemory) Get(ctx context.Context, key interface{}) (*vars.Var, error) {
 	item, ok := c.data.Get(key)
 	if ok && !item.IsExpired() {
+		item.m.Touch()
 		// Adding to LRU history if LRU feature is enabled.
 		if c.cap > 0 {
 			c.lruGetList.PushBack(key)
@@ -205,7 +205,8 @@ func (c *AdapterMemory) GetOrSet(ctx context.Context, key interface{}, value int
 		return nil, err
 	}
 	if v == nil {
-		return c.doSetWithLockCheck(ctx, key, value, duration)
+		v, _, err = c.doSetWithLockCheck(ctx, key, value, duration)
+		return v, err
 	}
 	return v, nil
 }
@@ -230,7 +231,8 @@ func (c *AdapterMemory) GetOrSetFunc(ctx context.Context, key interface{}, f Fun
 		if value == nil {
 			return nil, nil
 		}
-		return c.doSetWithLockCheck(ctx, key, value, duration)
+		v, _, err = c.doSetWithLockCheck(ctx, key, value, duration)
+		return v, err
 	}
 	return v, nil
 }
@@ -243,15 +245,20 @@ func (c *AdapterMemory) GetOrSetFunc(ctx context.Context, key interface{}, f Fun
 // It deletes the `key` if `duration` < 0 or given `value` is nil, but it does nothing
 // if `value` is a function and the function result is nil.
 //
-// Note that it differs from function `GetOrSetFunc` is that the function `f` is executed within
-// writing mutex lock for concurrent safety purpose.
+// Note that it differs from function `GetOrSetFunc` is that the function `f` is executed only
+// once concurrently for the same `key` for concurrent safety purpose. The execution of `f` does not
+// block other operations of the cache, and it is cancelled if all the callers' `ctx` are done.
 func (c *AdapterMemory) GetOrSetFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (*vars.Var, error) {
 	v, err := c.Get(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 	if v == nil {
-		return c.doSetWithLockCheck(ctx, key, f, duration)
+		value, _, err := c.doSetFuncWithLoader(ctx, key, f, duration)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		return vars.New(value), nil
 	}
 	return v, nil
 }
@@ -349,6 +356,15 @@ func (c *AdapterMemory) Clear(ctx context.Context) error {
 	return c.data.Clear()
 }
 
+// SetLoaderTimeout sets the execution timeout for the cache function `f` of functions
+// GetOrSetFuncLock and SetIfNotExistFuncLock. The context of `f` is cancelled after `timeout`,
+// and the callers waiting for it return with error.
+//
+// It does not limit the execution if `timeout` <= 0, which is the default.
+func (c *AdapterMemory) SetLoaderTimeout(timeout time.Duration) {
+	c.loader.SetTimeout(timeout)
+}
+
 // Close closes the cache.
 func (c *AdapterMemory) Close(ctx context.Context) error {
 	if c.cap > 0 {
@@ -366,12 +382,28 @@ func (c *AdapterMemory) Close(ctx context.Context) error {
 // function result is nil.
 //
 // It doubly checks the `key` whether exists in the cache using mutex writing lock
-// before setting it to the cache.
-func (c *AdapterMemory) doSetWithLockCheck(ctx context.Context, key interface{}, value interface{}, duration time.Duration) (result *vars.Var, err error) {
+// before setting it to the cache. The returned `inserted` is true only if `value` is set.
+func (c *AdapterMemory) doSetWithLockCheck(ctx context.Context, key interface{}, value interface{}, duration time.Duration) (result *vars.Var, inserted bool, err error) {
 	expireTimestamp := c.getInternalExpire(duration)
-	v, err := c.data.SetWithLock(ctx, key, value, expireTimestamp)
+	v, inserted, err := c.data.SetWithLock(ctx, key, value, expireTimestamp)
 	c.eventList.PushBack(&adapterMemoryEvent{k: key, e: expireTimestamp})
-	return vars.New(v), err
+	return vars.New(v), inserted, err
+}
+
+// doSetFuncWithLoader sets `key` with result of function `f` if `key` does not exist in the cache,
+// which is expired after `duration`. The function `f` is executed by the loader, which shares its
+// result among concurrent callers of the same `key`.
+//
+// It returns the value in the cache after setting, or nil if the result of `f` is nil.
+// The returned `inserted` is true only for the caller whose loading sets the value.
+func (c *AdapterMemory) doSetFuncWithLoader(ctx context.Context, key interface{}, f Func, duration time.Duration) (value interface{}, inserted bool, err error) {
+	return c.loader.Load(ctx, key, f, func(ctx context.Context, value interface{}) (interface{}, bool, error) {
+		result, inserted, err := c.doSetWithLockCheck(ctx, key, value, duration)
+		if err != nil {
+			return nil, false, err
+		}
+		return result.Val(), inserted, nil
+	})
 }
 
 // getInternalExpire converts and returns the expiration time with given expired duration in milliseconds.
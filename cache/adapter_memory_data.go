@@ -13,8 +13,12 @@ import (
 )
 
 type adapterMemoryData struct {
-	mu   sync.RWMutex                      // dataMu ensures the concurrent safety of underlying data map.
-	data map[interface{}]adapterMemoryItem // data is the underlying cache data which is stored in a hash table.
+	mu       sync.RWMutex                      // dataMu ensures the concurrent safety of underlying data map.
+	data     map[interface{}]adapterMemoryItem // data is the writable top layer of cache data, in which the deleted keys of base are tombstones.
+	base     *adapterMemoryLayer               // base is the immutable layers under data shared with snapshots, which is nil if there's no snapshot.
+	size     int                               // size is the number of the items in the cache.
+	version  uint64                            // version is increased by each writing of data.
+	snapshot *AdapterMemorySnapshot            // snapshot is the latest snapshot for reusing.
 }
 
 func newAdapterMemoryData() *adapterMemoryData {
@@ -31,11 +35,12 @@ func newAdapterMemoryData() *adapterMemoryData {
 func (d *adapterMemoryData) Update(key interface{}, value interface{}) (oldValue interface{}, exist bool, err error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	if item, ok := d.data[key]; ok {
-		d.data[key] = adapterMemoryItem{
+	if item, ok := d.lookup(key); ok {
+		d.store(key, adapterMemoryItem{
 			v: value,
 			e: item.e,
-		}
+			m: item.m,
+		}, true)
 		return item.v, true, nil
 	}
 	return nil, false, nil
@@ -48,11 +53,12 @@ func (d *adapterMemoryData) Update(key interface{}, value interface{}) (oldValue
 func (d *adapterMemoryData) UpdateExpire(key interface{}, expireTime int64) (oldDuration time.Duration, err error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	if item, ok := d.data[key]; ok {
-		d.data[key] = adapterMemoryItem{
+	if item, ok := d.lookup(key); ok {
+		d.store(key, adapterMemoryItem{
 			v: item.v,
 			e: expireTime,
-		}
+			m: item.m,
+		}, true)
 		return time.Duration(item.e-times.TimestampMilli()) * time.Millisecond, nil
 	}
 	return -1, nil
@@ -65,10 +71,10 @@ func (d *adapterMemoryData) Remove(keys ...interface{}) (removedKeys []interface
 	defer d.mu.Unlock()
 	removedKeys = make([]interface{}, 0)
 	for _, key := range keys {
-		item, ok := d.data[key]
+		item, ok := d.lookup(key)
 		if ok {
 			value = item.v
-			delete(d.data, key)
+			d.delete(key)
 			removedKeys = append(removedKeys, key)
 		}
 	}
@@ -78,12 +84,13 @@ func (d *adapterMemoryData) Remove(keys ...interface{}) (removedKeys []interface
 // Data returns a copy of all key-value pairs in the cache as map type.
 func (d *adapterMemoryData) Data() (map[interface{}]interface{}, error) {
 	d.mu.RLock()
-	m := make(map[interface{}]interface{}, len(d.data))
-	for k, v := range d.data {
+	m := make(map[interface{}]interface{}, d.size)
+	d.iterator(func(k interface{}, v adapterMemoryItem) bool {
 		if !v.IsExpired() {
 			m[k] = v.v
 		}
-	}
+		return true
+	})
 	d.mu.RUnlock()
 	return m, nil
 }
@@ -93,14 +100,15 @@ func (d *adapterMemoryData) Keys() ([]interface{}, error) {
 	d.mu.RLock()
 	var (
 		index = 0
-		keys  = make([]interface{}, len(d.data))
+		keys  = make([]interface{}, d.size)
 	)
-	for k, v := range d.data {
+	d.iterator(func(k interface{}, v adapterMemoryItem) bool {
 		if !v.IsExpired() {
 			keys[index] = k
 			index++
 		}
-	}
+		return true
+	})
 	d.mu.RUnlock()
 	return keys, nil
 }
@@ -110,14 +118,15 @@ func (d *adapterMemoryData) Values() ([]interface{}, error) {
 	d.mu.RLock()
 	var (
 		index  = 0
-		values = make([]interface{}, len(d.data))
+		values = make([]interface{}, d.size)
 	)
-	for _, v := range d.data {
+	d.iterator(func(k interface{}, v adapterMemoryItem) bool {
 		if !v.IsExpired() {
 			values[index] = v.v
 			index++
 		}
-	}
+		return true
+	})
 	d.mu.RUnlock()
 	return values, nil
 }
@@ -125,7 +134,7 @@ func (d *adapterMemoryData) Values() ([]interface{}, error) {
 // Size returns the size of the cache.
 func (d *adapterMemoryData) Size() (size int, err error) {
 	d.mu.RLock()
-	size = len(d.data)
+	size = d.size
 	d.mu.RUnlock()
 	return size, nil
 }
@@ -136,19 +145,22 @@ func (d *adapterMemoryData) Clear() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.data = make(map[interface{}]adapterMemoryItem)
+	d.base = nil
+	d.size = 0
+	d.version++
 	return nil
 }
 
 func (d *adapterMemoryData) Get(key interface{}) (item adapterMemoryItem, ok bool) {
 	d.mu.RLock()
-	item, ok = d.data[key]
+	item, ok = d.lookup(key)
 	d.mu.RUnlock()
 	return
 }
 
 func (d *adapterMemoryData) Set(key interface{}, value adapterMemoryItem) {
 	d.mu.Lock()
-	d.data[key] = value
+	d.store(key, value, false)
 	d.mu.Unlock()
 }
 
@@ -159,23 +171,21 @@ func (d *adapterMemoryData) Set(key interface{}, value adapterMemoryItem) {
 func (d *adapterMemoryData) SetMap(data map[interface{}]interface{}, expireTime int64) error {
 	d.mu.Lock()
 	for k, v := range data {
-		d.data[k] = adapterMemoryItem{
+		d.store(k, adapterMemoryItem{
 			v: v,
 			e: expireTime,
-		}
+			m: newAdapterMemoryItemMeta(),
+		}, false)
 	}
 	d.mu.Unlock()
 	return nil
 }
 
-func (d *adapterMemoryData) SetWithLock(ctx context.Context, key interface{}, value interface{}, expireTimestamp int64) (interface{}, error) {
+func (d *adapterMemoryData) SetWithLock(ctx context.Context, key interface{}, value interface{}, expireTimestamp int64) (result interface{}, inserted bool, err error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	var (
-		err error
-	)
-	if v, ok := d.data[key]; ok && !v.IsExpired() {
-		return v.v, nil
+	if v, ok := d.lookup(key); ok && !v.IsExpired() {
+		return v.v, false, nil
 	}
 	f, ok := value.(Func)
 	if !ok {
@@ -184,21 +194,87 @@ func (d *adapterMemoryData) SetWithLock(ctx context.Context, key interface{}, va
 	}
 	if ok {
 		if value, err = f(ctx); err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		if value == nil {
-			return nil, nil
+			return nil, false, nil
 		}
 	}
-	d.data[key] = adapterMemoryItem{v: value, e: expireTimestamp}
-	return value, nil
+	d.store(key, adapterMemoryItem{v: value, e: expireTimestamp, m: newAdapterMemoryItemMeta()}, false)
+	return value, true, nil
 }
 
 func (d *adapterMemoryData) DeleteWithDoubleCheck(key interface{}, force ...bool) {
 	d.mu.Lock()
 	// Doubly check before really deleting it from cache.
-	if item, ok := d.data[key]; (ok && item.IsExpired()) || (len(force) > 0 && force[0]) {
-		delete(d.data, key)
+	if item, ok := d.lookup(key); (ok && item.IsExpired()) || (ok && len(force) > 0 && force[0]) {
+		d.delete(key)
 	}
 	d.mu.Unlock()
 }
+
+// lookup retrieves the item of `key` from the top layer, or else from the shared layers.
+// It should be called within the lock.
+func (d *adapterMemoryData) lookup(key interface{}) (item adapterMemoryItem, ok bool) {
+	if item, ok = d.data[key]; ok {
+		return item, !item.isTombstone()
+	}
+	return d.base.lookup(key)
+}
+
+// store writes `item` of `key` to the top layer, and increases the version of data.
+// The `exist` specifies whether the `key` is known existing, or else it's looked up.
+// It should be called within the writing lock.
+//
+// The shared layers are never changed, and they are merged into the top layer once it's
+// not smaller than them, so that the writing costs constant time in amortization.
+func (d *adapterMemoryData) store(key interface{}, item adapterMemoryItem, exist bool) {
+	if !exist {
+		if _, exist = d.lookup(key); !exist {
+			d.size++
+		}
+	}
+	d.data[key] = item
+	d.version++
+	d.collapse()
+}
+
+// delete deletes `key` that exists in the cache, and increases the version of data.
+// It should be called within the writing lock.
+func (d *adapterMemoryData) delete(key interface{}) {
+	if _, ok := d.base.lookup(key); ok {
+		// It masks the item of shared layers.
+		d.data[key] = adapterMemoryTombstone
+	} else {
+		delete(d.data, key)
+	}
+	d.size--
+	d.version++
+	d.collapse()
+}
+
+// collapse merges the shared layers into the top layer if the top layer is not smaller
+// than them, after which there's only the top layer.
+func (d *adapterMemoryData) collapse() {
+	if d.base == nil || len(d.data) < d.base.count {
+		return
+	}
+	d.base.iterator(func(k interface{}, v adapterMemoryItem) bool {
+		if _, ok := d.data[k]; !ok {
+			d.data[k] = v
+		}
+		return true
+	})
+	for k, v := range d.data {
+		if v.isTombstone() {
+			delete(d.data, k)
+		}
+	}
+	d.base = nil
+}
+
+// iterator iterates the items of all layers with `f`, in which the items masked by the
+// upper layers are skipped. It should be called within the lock.
+func (d *adapterMemoryData) iterator(f func(k interface{}, v adapterMemoryItem) bool) {
+	(&adapterMemoryLayer{items: d.data, parent: d.base}).iterator(f)
+}
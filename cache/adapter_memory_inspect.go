@@ -0,0 +1,62 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocarp/go/times"
+)
+
+// Inspect retrieves and returns the metadata of `key`.
+// It returns nil if the `key` does not exist in the cache.
+//
+// Note that inspecting does not count as an access of the item.
+func (c *AdapterMemory) Inspect(ctx context.Context, key interface{}) (*ItemInfo, error) {
+	item, ok := c.data.Get(key)
+	if !ok || item.IsExpired() {
+		return nil, nil
+	}
+	info := &ItemInfo{
+		Key:  key,
+		Hits: -1,
+		Size: estimateValueSize(item.v),
+	}
+	if item.e != defaultMaxExpire {
+		info.TTL = time.Duration(item.e-times.TimestampMilli()) * time.Millisecond
+	}
+	if item.m != nil {
+		info.CreatedAt = time.UnixMilli(item.m.c)
+		info.Hits = item.m.h.Load()
+		if accessed := item.m.a.Load(); accessed > 0 {
+			info.AccessedAt = time.UnixMilli(accessed)
+		}
+	}
+	return info, nil
+}
+
+// InspectPage retrieves and returns the metadata of at most `limit` items starting from
+// `cursor`, in the order of the hashes of keys. The paging does not sort all the keys, and
+// the items existing during the whole paging are returned exactly once.
+// The returned `next` cursor is 0 if there are no more items.
+func (c *AdapterMemory) InspectPage(ctx context.Context, cursor uint64, limit int) (items []*ItemInfo, next uint64, err error) {
+	pager := newInspectPager(cursor, limit)
+	c.data.mu.RLock()
+	c.data.iterator(func(k interface{}, v adapterMemoryItem) bool {
+		if !v.IsExpired() {
+			pager.Add(k)
+		}
+		return true
+	})
+	c.data.mu.RUnlock()
+	pageKeys, next := pager.Page()
+	for _, key := range pageKeys {
+		if info, _ := c.Inspect(ctx, key); info != nil {
+			items = append(items, info)
+		}
+	}
+	return items, next, nil
+}
@@ -4,7 +4,25 @@
 
 package cache
 
-import "github.com/gocarp/go/times"
+import (
+	"sync/atomic"
+
+	"github.com/gocarp/go/times"
+)
+
+// Internal cache item metadata, which is shared by the copies of the same item
+// and updated atomically on reading.
+type adapterMemoryItemMeta struct {
+	c int64        // Creation timestamp in milliseconds.
+	a atomic.Int64 // Last access timestamp in milliseconds.
+	h atomic.Int64 // Hit count.
+}
+
+func newAdapterMemoryItemMeta() *adapterMemoryItemMeta {
+	return &adapterMemoryItemMeta{
+		c: times.TimestampMilli(),
+	}
+}
 
 // IsExpired checks whether `item` is expired.
 func (item *adapterMemoryItem) IsExpired() bool {
@@ -13,3 +31,12 @@ func (item *adapterMemoryItem) IsExpired() bool {
 
 	return item.e < times.TimestampMilli()
 }
+
+// Touch records a hit of the item.
+func (m *adapterMemoryItemMeta) Touch() {
+	if m == nil {
+		return
+	}
+	m.a.Store(times.TimestampMilli())
+	m.h.Add(1)
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"math"
+	"time"
+
+	"github.com/gocarp/go/container/vars"
+	"github.com/gocarp/go/times"
+)
+
+// AdapterMemorySnapshot is a read-only and consistent view of AdapterMemory at a point in time.
+//
+// Taking a snapshot does not copy the cache data. It freezes the data into immutable layers
+// shared with the cache instead, and the cache writes the changes after the snapshot to a new
+// layer on top of them (copy-on-write per key). So the readers of the snapshot never block the
+// writers of the cache, and the writing does not copy the whole data.
+//
+// The items are judged expired or not by the time of the snapshot.
+type AdapterMemorySnapshot struct {
+	layer   *adapterMemoryLayer // layer is the top of the immutable layers shared with the cache.
+	time    int64               // time is the timestamp in milliseconds of the snapshot.
+	version uint64              // version is the data version of the snapshot.
+}
+
+// adapterMemoryLayer is an immutable layer of the cache data, which holds the items written
+// after its parent layer. The items deleted from the parent layer are tombstones.
+type adapterMemoryLayer struct {
+	items  map[interface{}]adapterMemoryItem // items is the items written in this layer.
+	parent *adapterMemoryLayer               // parent is the layer under this layer, which is nil for the bottom layer.
+	count  int                               // count is the number of the items in this layer and all its parent layers.
+}
+
+var (
+	// adapterMemoryTombstone is the item marking a deleted key in the layer.
+	adapterMemoryTombstone = adapterMemoryItem{e: math.MinInt64}
+)
+
+// Snapshot returns a read-only snapshot of the cache.
+//
+// The optional parameter `maxStaleness` specifies the bounded staleness of the snapshot, which
+// allows reusing the latest snapshot if it was taken within `maxStaleness`, even if the cache
+// was changed after it. It reduces the layering of data for frequent snapshots of a cache
+// that is frequently written. It always returns the up-to-date snapshot in default.
+func (c *AdapterMemory) Snapshot(maxStaleness ...time.Duration) *AdapterMemorySnapshot {
+	var staleness time.Duration
+	if len(maxStaleness) > 0 {
+		staleness = maxStaleness[0]
+	}
+	return c.data.Snapshot(staleness)
+}
+
+// Snapshot returns a snapshot of the data, which reuses the latest snapshot if it's not older
+// than `maxStaleness`, or there's no writing after it.
+func (d *adapterMemoryData) Snapshot(maxStaleness time.Duration) *AdapterMemorySnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := times.TimestampMilli()
+	if latest := d.snapshot; latest != nil {
+		if latest.version == d.version {
+			if latest.time == now {
+				return latest
+			}
+			// Nothing changed but the time for expiration judgement.
+			d.snapshot = &AdapterMemorySnapshot{
+				layer:   latest.layer,
+				time:    now,
+				version: latest.version,
+			}
+			return d.snapshot
+		}
+		if maxStaleness > 0 && now-latest.time <= maxStaleness.Milliseconds() {
+			return latest
+		}
+	}
+	d.freeze()
+	d.snapshot = &AdapterMemorySnapshot{
+		layer:   d.base,
+		time:    now,
+		version: d.version,
+	}
+	return d.snapshot
+}
+
+// freeze makes the top layer immutable and shared as the top of the base layers, and starts
+// a new top layer for writing. The upper layer is merged into its parent layer if it's not
+// smaller than half of its parent, so that there are logarithmic layers for reading.
+func (d *adapterMemoryData) freeze() {
+	if d.base != nil && len(d.data) == 0 {
+		return
+	}
+	layer := &adapterMemoryLayer{items: d.data, parent: d.base}
+	for layer.parent != nil && len(layer.items)*2 >= len(layer.parent.items) {
+		layer = layer.parent.merge(layer.items)
+	}
+	layer.count = len(layer.items)
+	if layer.parent != nil {
+		layer.count += layer.parent.count
+	}
+	d.base = layer
+	d.data = make(map[interface{}]adapterMemoryItem)
+}
+
+// merge returns a new layer replacing `l`, which holds the items of `l` overwritten by `items`.
+// The tombstones are dropped if it's the bottom layer.
+func (l *adapterMemoryLayer) merge(items map[interface{}]adapterMemoryItem) *adapterMemoryLayer {
+	merged := &adapterMemoryLayer{
+		items:  make(map[interface{}]adapterMemoryItem, len(l.items)+len(items)),
+		parent: l.parent,
+	}
+	for k, v := range l.items {
+		merged.items[k] = v
+	}
+	for k, v := range items {
+		if v.isTombstone() && merged.parent == nil {
+			delete(merged.items, k)
+			continue
+		}
+		merged.items[k] = v
+	}
+	return merged
+}
+
+// lookup retrieves the item of `key` from the layer, or else from its parent layers.
+func (l *adapterMemoryLayer) lookup(key interface{}) (item adapterMemoryItem, ok bool) {
+	for ; l != nil; l = l.parent {
+		if item, ok = l.items[key]; ok {
+			return item, !item.isTombstone()
+		}
+	}
+	return adapterMemoryItem{}, false
+}
+
+// iterator iterates the items of the layer and its parent layers with `f`, in which the items
+// masked by the upper layers are skipped. If `f` returns true, then it continues iterating;
+// or false to stop.
+func (l *adapterMemoryLayer) iterator(f func(k interface{}, v adapterMemoryItem) bool) {
+	if l == nil {
+		return
+	}
+	if l.parent == nil {
+		for k, v := range l.items {
+			if !v.isTombstone() && !f(k, v) {
+				return
+			}
+		}
+		return
+	}
+	seen := make(map[interface{}]struct{})
+	for ; l != nil; l = l.parent {
+		for k, v := range l.items {
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			if !v.isTombstone() && !f(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// isTombstone checks whether `item` marks a deleted key in the layer.
+func (item *adapterMemoryItem) isTombstone() bool {
+	return item.e == math.MinInt64
+}
+
+// Time returns the time when the snapshot is taken.
+func (s *AdapterMemorySnapshot) Time() time.Time {
+	return time.UnixMilli(s.time)
+}
+
+// Get retrieves and returns the associated value of given `key` in the snapshot.
+// It returns nil if it does not exist, or its value is nil, or it's expired.
+func (s *AdapterMemorySnapshot) Get(key interface{}) *vars.Var {
+	if item, ok := s.layer.lookup(key); ok && !s.isExpired(item) {
+		return vars.New(item.v)
+	}
+	return nil
+}
+
+// Contains checks and returns true if `key` exists in the snapshot, or else returns false.
+func (s *AdapterMemorySnapshot) Contains(key interface{}) bool {
+	item, ok := s.layer.lookup(key)
+	return ok && !s.isExpired(item)
+}
+
+// Size returns the number of items in the snapshot.
+func (s *AdapterMemorySnapshot) Size() (size int) {
+	s.layer.iterator(func(k interface{}, item adapterMemoryItem) bool {
+		if !s.isExpired(item) {
+			size++
+		}
+		return true
+	})
+	return
+}
+
+// Iterator iterates the items of the snapshot readonly with custom callback function `f`.
+// If `f` returns true, then it continues iterating; or false to stop.
+func (s *AdapterMemorySnapshot) Iterator(f func(key, value interface{}) bool) {
+	s.layer.iterator(func(k interface{}, item adapterMemoryItem) bool {
+		if s.isExpired(item) {
+			return true
+		}
+		return f(k, item.v)
+	})
+}
+
+// Data returns a copy of all key-value pairs in the snapshot as map type.
+func (s *AdapterMemorySnapshot) Data() map[interface{}]interface{} {
+	m := make(map[interface{}]interface{}, s.layer.count)
+	s.Iterator(func(key, value interface{}) bool {
+		m[key] = value
+		return true
+	})
+	return m
+}
+
+// Keys returns all keys in the snapshot as slice.
+func (s *AdapterMemorySnapshot) Keys() []interface{} {
+	keys := make([]interface{}, 0, s.layer.count)
+	s.Iterator(func(key, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Values returns all values in the snapshot as slice.
+func (s *AdapterMemorySnapshot) Values() []interface{} {
+	values := make([]interface{}, 0, s.layer.count)
+	s.Iterator(func(key, value interface{}) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
+
+// isExpired checks whether `item` is expired at the time of the snapshot.
+func (s *AdapterMemorySnapshot) isExpired(item adapterMemoryItem) bool {
+	return item.e < s.time
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newTestAdapterMemory(t testing.TB) *AdapterMemory {
+	t.Helper()
+	c := NewAdapterMemory().(*AdapterMemory)
+	t.Cleanup(func() {
+		_ = c.Close(context.Background())
+	})
+	return c
+}
+
+func TestAdapterMemorySnapshot_Isolation(t *testing.T) {
+	var (
+		ctx = context.Background()
+		c   = newTestAdapterMemory(t)
+	)
+	_ = c.Set(ctx, "a", 1, 0)
+	_ = c.Set(ctx, "b", 2, 0)
+	s1 := c.Snapshot()
+	_ = c.Set(ctx, "a", 10, 0)
+	_ = c.Set(ctx, "c", 3, 0)
+	_, _ = c.Remove(ctx, "b")
+	s2 := c.Snapshot()
+	_, _ = c.Remove(ctx, "c")
+	_ = c.Set(ctx, "b", 20, 0)
+
+	if v := s1.Get("a"); v.Int() != 1 {
+		t.Fatalf(`got %v in s1, want 1`, v)
+	}
+	if !s1.Contains("b") || s1.Contains("c") || s1.Size() != 2 {
+		t.Fatalf(`got s1 data %v, want a and b`, s1.Data())
+	}
+	if v := s2.Get("a"); v.Int() != 10 {
+		t.Fatalf(`got %v in s2, want 10`, v)
+	}
+	if s2.Contains("b") || !s2.Contains("c") || s2.Size() != 2 {
+		t.Fatalf(`got s2 data %v, want a and c`, s2.Data())
+	}
+	if data, _ := c.Data(ctx); len(data) != 2 || data["a"] != 10 || data["b"] != 20 {
+		t.Fatalf(`got cache data %v`, data)
+	}
+	if size, _ := c.Size(ctx); size != 2 {
+		t.Fatalf(`got size %d, want 2`, size)
+	}
+}
+
+func TestAdapterMemorySnapshot_Layers(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		c         = newTestAdapterMemory(t)
+		snapshots []*AdapterMemorySnapshot
+		expects   []map[interface{}]interface{}
+		expect    = make(map[interface{}]interface{})
+	)
+	// Interleaves the writing and snapshots, which layers and merges the data in many ways.
+	for i := 0; i < 500; i++ {
+		key := i % 37
+		switch {
+		case i%5 == 0:
+			_, _ = c.Remove(ctx, key)
+			delete(expect, key)
+		default:
+			_ = c.Set(ctx, key, i, 0)
+			expect[key] = i
+		}
+		if i%7 == 0 {
+			snapshots = append(snapshots, c.Snapshot())
+			copied := make(map[interface{}]interface{}, len(expect))
+			for k, v := range expect {
+				copied[k] = v
+			}
+			expects = append(expects, copied)
+		}
+	}
+	for i, s := range snapshots {
+		if got := s.Data(); fmt.Sprint(got) != fmt.Sprint(expects[i]) {
+			t.Fatalf(`got snapshot %d data %v, want %v`, i, got, expects[i])
+		}
+		if s.Size() != len(expects[i]) {
+			t.Fatalf(`got snapshot %d size %d, want %d`, i, s.Size(), len(expects[i]))
+		}
+	}
+	keys, _ := c.Keys(ctx)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].(int) < keys[j].(int) })
+	if len(keys) != len(expect) {
+		t.Fatalf(`got keys %v, want %d keys`, keys, len(expect))
+	}
+	if size, _ := c.Size(ctx); size != len(expect) {
+		t.Fatalf(`got size %d, want %d`, size, len(expect))
+	}
+}
+
+func TestAdapterMemorySnapshot_Staleness(t *testing.T) {
+	var (
+		ctx = context.Background()
+		c   = newTestAdapterMemory(t)
+	)
+	s1 := c.Snapshot(time.Hour)
+	_ = c.Set(ctx, "c", 3, 0)
+	if s2 := c.Snapshot(time.Hour); s2 != s1 || s2.Contains("c") {
+		t.Fatal(`snapshot within staleness is not reused`)
+	}
+	if !c.Snapshot().Contains("c") {
+		t.Fatal(`up-to-date snapshot does not contain the change`)
+	}
+}
+
+func TestAdapterMemorySnapshot_Expired(t *testing.T) {
+	var (
+		ctx = context.Background()
+		c   = newTestAdapterMemory(t)
+	)
+	_ = c.Set(ctx, "k", 1, 5*time.Millisecond)
+	s := c.Snapshot()
+	time.Sleep(10 * time.Millisecond)
+	if !s.Contains("k") {
+		t.Fatal(`item is judged expired after the snapshot time`)
+	}
+	if c.Snapshot().Contains("k") {
+		t.Fatal(`expired item exists in the new snapshot`)
+	}
+}
+
+// BenchmarkAdapterMemorySnapshot_FirstWrite benchmarks the first writing after a snapshot,
+// which should not copy the whole data.
+func BenchmarkAdapterMemorySnapshot_FirstWrite(b *testing.B) {
+	for _, size := range []int{1000, 100000} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			var (
+				ctx = context.Background()
+				c   = newTestAdapterMemory(b)
+			)
+			for i := 0; i < size; i++ {
+				_ = c.Set(ctx, i, i, 0)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Snapshot()
+				_ = c.Set(ctx, i%size, i, 0)
+			}
+		})
+	}
+}
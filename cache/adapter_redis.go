@@ -15,13 +15,15 @@ import (
 
 // AdapterRedis is the cache adapter implements using Redis server.
 type AdapterRedis struct {
-	redis *redis.Redis
+	redis  *redis.Redis
+	loader *adapterLoader // loader executes the cache function `Func` only once concurrently for the same key.
 }
 
 // NewAdapterRedis creates and returns a new memory cache object.
 func NewAdapterRedis(redis *redis.Redis) Adapter {
 	return &AdapterRedis{
-		redis: redis,
+		redis:  redis,
+		loader: newAdapterLoader(),
 	}
 }
 
@@ -154,14 +156,17 @@ func (c *AdapterRedis) SetIfNotExistFunc(ctx context.Context, key interface{}, f
 // It does not expire if `duration` == 0.
 // It deletes the `key` if `duration` < 0 or given `value` is nil.
 //
-// Note that it differs from function `SetIfNotExistFunc` is that the function `f` is executed within
-// writing mutex lock for concurrent safety purpose.
+// Note that it differs from function `SetIfNotExistFunc` is that the function `f` is executed only
+// once concurrently for the same `key` in current process for concurrent safety purpose, and it is
+// cancelled if all the callers' `ctx` are done. It does not lock the `key` among processes, but the
+// value is set using SETNX, so that only one of the processes sets the value.
 func (c *AdapterRedis) SetIfNotExistFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (ok bool, err error) {
-	value, err := f(ctx)
-	if err != nil {
+	isContained, err := c.Contains(ctx, key)
+	if err != nil || isContained {
 		return false, err
 	}
-	return c.SetIfNotExist(ctx, key, value, duration)
+	_, inserted, err := c.doSetFuncWithLoader(ctx, key, f, duration)
+	return inserted, err
 }
 
 // Get retrieves and returns the associated value of given <key>.
@@ -222,10 +227,56 @@ func (c *AdapterRedis) GetOrSetFunc(ctx context.Context, key interface{}, f Func
 // It deletes the `key` if `duration` < 0 or given `value` is nil, but it does nothing
 // if `value` is a function and the function result is nil.
 //
-// Note that it differs from function `GetOrSetFunc` is that the function `f` is executed within
-// writing mutex lock for concurrent safety purpose.
+// Note that it differs from function `GetOrSetFunc` is that the function `f` is executed only
+// once concurrently for the same `key` in current process for concurrent safety purpose, and it is
+// cancelled if all the callers' `ctx` are done. It does not lock the `key` among processes, but the
+// value is set using SETNX, so that all the processes get the same value.
 func (c *AdapterRedis) GetOrSetFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (result *vars.Var, err error) {
-	return c.GetOrSetFunc(ctx, key, f, duration)
+	v, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !v.IsNil() {
+		return v, nil
+	}
+	value, _, err := c.doSetFuncWithLoader(ctx, key, f, duration)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return vars.New(value), nil
+}
+
+// SetLoaderTimeout sets the execution timeout for the cache function `f` of functions
+// GetOrSetFuncLock and SetIfNotExistFuncLock. The context of `f` is cancelled after `timeout`,
+// and the callers waiting for it return with error.
+//
+// It does not limit the execution if `timeout` <= 0, which is the default.
+func (c *AdapterRedis) SetLoaderTimeout(timeout time.Duration) {
+	c.loader.SetTimeout(timeout)
+}
+
+// doSetFuncWithLoader sets `key` with result of function `f` if `key` does not exist in the cache,
+// which is expired after `duration`. The function `f` is executed by the loader, which shares its
+// result among concurrent callers of the same `key` in current process.
+//
+// It returns the value in the cache after setting, or nil if the result of `f` is nil.
+// The returned `inserted` is true only for the caller whose loading sets the value.
+func (c *AdapterRedis) doSetFuncWithLoader(ctx context.Context, key interface{}, f Func, duration time.Duration) (value interface{}, inserted bool, err error) {
+	return c.loader.Load(ctx, conv.String(key), f, func(ctx context.Context, value interface{}) (interface{}, bool, error) {
+		inserted, err := c.SetIfNotExist(ctx, key, value, duration)
+		if err != nil {
+			return nil, false, err
+		}
+		if inserted {
+			return value, true, nil
+		}
+		// The key is set by others concurrently, which takes precedence.
+		result, err := c.Get(ctx, key)
+		if err != nil {
+			return nil, false, err
+		}
+		return result.Val(), false, nil
+	})
 }
 
 // Contains checks and returns true if `key` exists in the cache, or else returns false.
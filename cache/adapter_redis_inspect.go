@@ -0,0 +1,79 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocarp/utils/conv"
+)
+
+// Inspect retrieves and returns the metadata of `key`.
+// It returns nil if the `key` does not exist in the cache.
+//
+// The metadata is approximated using commands of redis server:
+// `PTTL` for the TTL, `OBJECT IDLETIME` for the last access time, `OBJECT FREQ` for the hits,
+// which is the logarithmic access frequency counter and only available for LFU maxmemory policy,
+// and `MEMORY USAGE` for the size. The creation time is not available.
+func (c *AdapterRedis) Inspect(ctx context.Context, key interface{}) (*ItemInfo, error) {
+	redisKey := conv.String(key)
+	pttl, err := c.redis.PTTL(ctx, redisKey)
+	if err != nil {
+		return nil, err
+	}
+	if pttl == -2 || pttl == 0 {
+		// It does not exist or expired.
+		return nil, nil
+	}
+	info := &ItemInfo{
+		Key:  key,
+		Hits: -1,
+		Size: -1,
+	}
+	if pttl > 0 {
+		info.TTL = time.Duration(pttl) * time.Millisecond
+	}
+	// The following commands might be unavailable for the server configuration or version,
+	// so their errors are ignored.
+	if v, err := c.redis.Do(ctx, "OBJECT", "IDLETIME", redisKey); err == nil && !v.IsNil() {
+		info.AccessedAt = time.Now().Add(-time.Duration(v.Int64()) * time.Second)
+	}
+	if v, err := c.redis.Do(ctx, "OBJECT", "FREQ", redisKey); err == nil && !v.IsNil() {
+		info.Hits = v.Int64()
+	}
+	if v, err := c.redis.Do(ctx, "MEMORY", "USAGE", redisKey); err == nil && !v.IsNil() {
+		info.Size = v.Int64()
+	}
+	return info, nil
+}
+
+// InspectPage retrieves and returns the metadata of items using command `SCAN` with
+// `cursor` and `COUNT` of `limit`.
+//
+// Note that, as the `COUNT` of `SCAN` is only a hint for the server, the number of returned
+// items might be different from `limit`. The returned `next` cursor is 0 if the iteration
+// is complete.
+func (c *AdapterRedis) InspectPage(ctx context.Context, cursor uint64, limit int) (items []*ItemInfo, next uint64, err error) {
+	v, err := c.redis.Do(ctx, "SCAN", cursor, "COUNT", limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := v.Vars()
+	if len(result) != 2 {
+		return nil, 0, nil
+	}
+	next = result[0].Uint64()
+	for _, key := range result[1].Strings() {
+		var info *ItemInfo
+		if info, err = c.Inspect(ctx, key); err != nil {
+			return nil, 0, err
+		}
+		if info != nil {
+			items = append(items, info)
+		}
+	}
+	return items, next, nil
+}
@@ -18,6 +18,7 @@ import (
 // Cache struct.
 type Cache struct {
 	localAdapter
+	hotKeys *cacheHotKeys // hotKeys detects the hot keys, which is nil if it's not enabled.
 }
 
 type localAdapter = Adapter // localAdapter is alias of Adapter, for embedded attribute purpose only.
@@ -76,8 +77,10 @@ func SetIfNotExistFunc(ctx context.Context, key interface{}, f Func, duration ti
 // It does not expire if `duration` == 0.
 // It deletes the `key` if `duration` < 0 or given `value` is nil.
 //
-// Note that it differs from function `SetIfNotExistFunc` is that the function `f` is executed within
-// writing mutex lock for concurrent safety purpose.
+// Note that it differs from function `SetIfNotExistFunc` is that the function `f` is executed only once
+// concurrently for the same `key` for concurrent safety purpose, and the execution should
+// respect the cancellation of `ctx`. It returns true only to the caller that executes `f` and
+// sets its result, and the concurrent callers sharing the result get false.
 func SetIfNotExistFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (bool, error) {
 	return defaultCache.SetIfNotExistFuncLock(ctx, key, f, duration)
 }
@@ -119,8 +122,9 @@ func GetOrSetFunc(ctx context.Context, key interface{}, f Func, duration time.Du
 // It deletes the `key` if `duration` < 0 or given `value` is nil, but it does nothing
 // if `value` is a function and the function result is nil.
 //
-// Note that it differs from function `GetOrSetFunc` is that the function `f` is executed within
-// writing mutex lock for concurrent safety purpose.
+// Note that it differs from function `GetOrSetFunc` is that the function `f` is executed only once
+// concurrently for the same `key` for concurrent safety purpose, and the execution should
+// respect the cancellation of `ctx`.
 func GetOrSetFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (*vars.Var, error) {
 	return defaultCache.GetOrSetFuncLock(ctx, key, f, duration)
 }
@@ -0,0 +1,323 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocarp/go/container/types"
+	"github.com/gocarp/go/container/vars"
+	"github.com/gocarp/go/timer"
+	"github.com/gocarp/utils/conv"
+)
+
+// HotKeysConfig is the configuration for hot keys detection of Cache.
+type HotKeysConfig struct {
+	Width         int           // Width is the number of counters in each row of the count-min sketch, default is 2048.
+	Depth         int           // Depth is the number of rows of the count-min sketch, default is 4.
+	TopK          int           // TopK is the number of the most frequent keys tracked, default is 16.
+	MinCount      int64         // MinCount is the minimum estimated count for a top key being hot, default is 100.
+	DecayInterval time.Duration // DecayInterval is the interval for halving all counters, default is one minute.
+	Promote       bool          // Promote enables auto-promoting hot keys into a local memory tier.
+	PromoteTTL    time.Duration // PromoteTTL is the expiration of the promoted values in the local tier, default is one second.
+}
+
+// HotKey is a frequently accessed key with its estimated access count.
+type HotKey struct {
+	Key   interface{} `json:"key"`   // Key of the item.
+	Count int64       `json:"count"` // Count is the estimated recent access count.
+}
+
+// cacheHotKeys detects the hot keys of Cache and manages the local tier for promoted keys.
+type cacheHotKeys struct {
+	config   HotKeysConfig                        // config is the configuration of hot keys detection.
+	sketch   *hotKeysSketch                       // sketch estimates the access frequency of keys.
+	local    *AdapterMemory                       // local is the memory tier for promoted hot keys, which is nil if promotion is disabled.
+	versions [hotKeysVersionStripes]atomic.Uint64 // versions is the invalidation versions of keys in stripes, which guards the promotion against concurrent writing.
+	closed   *types.Bool                          // closed controls the detection closed or not.
+}
+
+const (
+	defaultHotKeysWidth         = 2048
+	defaultHotKeysDepth         = 4
+	defaultHotKeysTopK          = 16
+	defaultHotKeysMinCount      = 100
+	defaultHotKeysDecayInterval = time.Minute
+	defaultHotKeysPromoteTTL    = time.Second
+	hotKeysVersionStripes       = 64 // Number of the version stripes of keys for promotion.
+)
+
+// EnableHotKeys enables the hot keys detection for the cache with given configuration.
+//
+// It tracks the access frequency of keys by reading functions like Get, GetOrSet and so on,
+// using a count-min sketch, and the most frequently accessed keys can be retrieved by HotKeys.
+// If `Promote` is enabled, the values of hot keys are replicated into a local memory tier
+// for `PromoteTTL`, and the reading of hot keys is served from the local tier, which is
+// useful for remote adapters like Redis. The writing functions of the Cache remove the
+// local replication of the key, but the changes from other processes are only visible
+// after the local replication expires.
+//
+// Be very note that, this setting function is not concurrent-safe, which means you should
+// not call this setting function concurrently with other functions of the cache.
+func (c *Cache) EnableHotKeys(config HotKeysConfig) {
+	if config.Width <= 0 {
+		config.Width = defaultHotKeysWidth
+	}
+	if config.Depth <= 0 {
+		config.Depth = defaultHotKeysDepth
+	}
+	if config.TopK <= 0 {
+		config.TopK = defaultHotKeysTopK
+	}
+	if config.MinCount <= 0 {
+		config.MinCount = defaultHotKeysMinCount
+	}
+	if config.DecayInterval <= 0 {
+		config.DecayInterval = defaultHotKeysDecayInterval
+	}
+	if config.PromoteTTL <= 0 {
+		config.PromoteTTL = defaultHotKeysPromoteTTL
+	}
+	if c.hotKeys != nil {
+		c.hotKeys.Close(context.Background())
+	}
+	hotKeys := &cacheHotKeys{
+		config: config,
+		sketch: newHotKeysSketch(config.Width, config.Depth, config.TopK),
+		closed: types.NewBool(),
+	}
+	if config.Promote {
+		hotKeys.local = NewAdapterMemory(config.TopK).(*AdapterMemory)
+	}
+	timer.AddSingleton(context.Background(), config.DecayInterval, hotKeys.decay)
+	c.hotKeys = hotKeys
+}
+
+// HotKeys returns the current hot keys sorted by their estimated access count in descending
+// order. It returns nil if the hot keys detection is not enabled.
+func (c *Cache) HotKeys() []HotKey {
+	if c.hotKeys == nil {
+		return nil
+	}
+	var (
+		keys = c.hotKeys.sketch.Top()
+		hot  = make([]HotKey, 0, len(keys))
+	)
+	for _, key := range keys {
+		if key.Count >= c.hotKeys.config.MinCount {
+			hot = append(hot, key)
+		}
+	}
+	return hot
+}
+
+// Get retrieves and returns the associated value of given `key`.
+// It returns nil if it does not exist, or its value is nil, or it's expired.
+//
+// It serves the reading from the local tier if `key` is a promoted hot key.
+func (c *Cache) Get(ctx context.Context, key interface{}) (*vars.Var, error) {
+	if c.hotKeys == nil {
+		return c.localAdapter.Get(ctx, key)
+	}
+	return c.hotKeys.Get(ctx, key, func() (*vars.Var, error) {
+		return c.localAdapter.Get(ctx, key)
+	})
+}
+
+// GetOrSet retrieves and returns the value of `key`, or sets `key`-`value` pair and
+// returns `value` if `key` does not exist in the cache.
+//
+// It serves the reading from the local tier if `key` is a promoted hot key.
+func (c *Cache) GetOrSet(ctx context.Context, key interface{}, value interface{}, duration time.Duration) (*vars.Var, error) {
+	if c.hotKeys == nil {
+		return c.localAdapter.GetOrSet(ctx, key, value, duration)
+	}
+	return c.hotKeys.Get(ctx, key, func() (*vars.Var, error) {
+		return c.localAdapter.GetOrSet(ctx, key, value, duration)
+	})
+}
+
+// GetOrSetFunc retrieves and returns the value of `key`, or sets `key` with result of
+// function `f` and returns its result if `key` does not exist in the cache.
+//
+// It serves the reading from the local tier if `key` is a promoted hot key.
+func (c *Cache) GetOrSetFunc(ctx context.Context, key interface{}, f Func, duration time.Duration) (*vars.Var, error) {
+	if c.hotKeys == nil {
+		return c.localAdapter.GetOrSetFunc(ctx, key, f, duration)
+	}
+	return c.hotKeys.Get(ctx, key, func() (*vars.Var, error) {
+		return c.localAdapter.GetOrSetFunc(ctx, key, f, duration)
+	})
+}
+
+// GetOrSetFuncLock retrieves and returns the value of `key`, or sets `key` with result of
+// function `f` and returns its result if `key` does not exist in the cache.
+//
+// It serves the reading from the local tier if `key` is a promoted hot key.
+func (c *Cache) GetOrSetFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (*vars.Var, error) {
+	if c.hotKeys == nil {
+		return c.localAdapter.GetOrSetFuncLock(ctx, key, f, duration)
+	}
+	return c.hotKeys.Get(ctx, key, func() (*vars.Var, error) {
+		return c.localAdapter.GetOrSetFuncLock(ctx, key, f, duration)
+	})
+}
+
+// Set sets cache with `key`-`value` pair, which is expired after `duration`.
+// It also removes the local replication of `key` if it's a promoted hot key.
+func (c *Cache) Set(ctx context.Context, key interface{}, value interface{}, duration time.Duration) error {
+	defer c.hotKeys.Invalidate(ctx, key)
+	return c.localAdapter.Set(ctx, key, value, duration)
+}
+
+// SetMap batch sets cache with key-value pairs by `data` map, which is expired after `duration`.
+// It also removes the local replication of the keys if they're promoted hot keys.
+func (c *Cache) SetMap(ctx context.Context, data map[interface{}]interface{}, duration time.Duration) error {
+	defer func() {
+		for k := range data {
+			c.hotKeys.Invalidate(ctx, k)
+		}
+	}()
+	return c.localAdapter.SetMap(ctx, data, duration)
+}
+
+// SetIfNotExist sets cache with `key`-`value` pair which is expired after `duration`
+// if `key` does not exist in the cache.
+// It also removes the local replication of `key` if it's a promoted hot key.
+func (c *Cache) SetIfNotExist(ctx context.Context, key interface{}, value interface{}, duration time.Duration) (ok bool, err error) {
+	defer c.hotKeys.Invalidate(ctx, key)
+	return c.localAdapter.SetIfNotExist(ctx, key, value, duration)
+}
+
+// SetIfNotExistFunc sets `key` with result of function `f` and returns true
+// if `key` does not exist in the cache.
+// It also removes the local replication of `key` if it's a promoted hot key.
+func (c *Cache) SetIfNotExistFunc(ctx context.Context, key interface{}, f Func, duration time.Duration) (ok bool, err error) {
+	defer c.hotKeys.Invalidate(ctx, key)
+	return c.localAdapter.SetIfNotExistFunc(ctx, key, f, duration)
+}
+
+// SetIfNotExistFuncLock sets `key` with result of function `f` and returns true
+// if `key` does not exist in the cache, and the function `f` is executed only once
+// concurrently for the same `key`.
+// It also removes the local replication of `key` if it's a promoted hot key.
+func (c *Cache) SetIfNotExistFuncLock(ctx context.Context, key interface{}, f Func, duration time.Duration) (ok bool, err error) {
+	defer c.hotKeys.Invalidate(ctx, key)
+	return c.localAdapter.SetIfNotExistFuncLock(ctx, key, f, duration)
+}
+
+// Update updates the value of `key` without changing its expiration and returns the old value.
+// It also removes the local replication of `key` if it's a promoted hot key.
+func (c *Cache) Update(ctx context.Context, key interface{}, value interface{}) (oldValue *vars.Var, exist bool, err error) {
+	defer c.hotKeys.Invalidate(ctx, key)
+	return c.localAdapter.Update(ctx, key, value)
+}
+
+// UpdateExpire updates the expiration of `key` and returns the old expiration duration value.
+// It also removes the local replication of `key` if it's a promoted hot key.
+func (c *Cache) UpdateExpire(ctx context.Context, key interface{}, duration time.Duration) (oldDuration time.Duration, err error) {
+	defer c.hotKeys.Invalidate(ctx, key)
+	return c.localAdapter.UpdateExpire(ctx, key, duration)
+}
+
+// Remove deletes one or more keys from cache, and returns its value.
+// It also removes the local replication of the keys if they're promoted hot keys.
+func (c *Cache) Remove(ctx context.Context, keys ...interface{}) (lastValue *vars.Var, err error) {
+	defer func() {
+		for _, key := range keys {
+			c.hotKeys.Invalidate(ctx, key)
+		}
+	}()
+	return c.localAdapter.Remove(ctx, keys...)
+}
+
+// Clear clears all data of the cache, including the local tier of promoted hot keys.
+func (c *Cache) Clear(ctx context.Context) error {
+	defer c.hotKeys.InvalidateAll(ctx)
+	return c.localAdapter.Clear(ctx)
+}
+
+// Close closes the cache, and stops the hot keys detection.
+func (c *Cache) Close(ctx context.Context) error {
+	if c.hotKeys != nil {
+		c.hotKeys.Close(ctx)
+	}
+	return c.localAdapter.Close(ctx)
+}
+
+// Get records the access of `key`, and retrieves the value of `key` from the local tier if
+// it's a promoted hot key, or else it retrieves the value using `get`.
+//
+// The value retrieved by `get` is not kept in the local tier if `key` is invalidated during
+// the retrieving, as the value might be retrieved before the writing that invalidates it.
+func (h *cacheHotKeys) Get(ctx context.Context, key interface{}, get func() (*vars.Var, error)) (*vars.Var, error) {
+	keyStr := conv.String(key)
+	count, isTop := h.sketch.Add(key, keyStr)
+	if h.local == nil || !isTop || int64(count) < h.config.MinCount {
+		return get()
+	}
+	if v, _ := h.local.Get(ctx, key); v != nil {
+		return v, nil
+	}
+	var (
+		version = h.version(keyStr)
+		last    = version.Load()
+	)
+	v, err := get()
+	if err != nil || v == nil || v.IsNil() {
+		return v, err
+	}
+	_ = h.local.Set(ctx, key, v.Val(), h.config.PromoteTTL)
+	if version.Load() != last {
+		_, _ = h.local.Remove(ctx, key)
+	}
+	return v, nil
+}
+
+// Invalidate removes the local replication of `key`, which should be called after writing
+// `key`. It does nothing if `h` is nil or promotion is disabled.
+func (h *cacheHotKeys) Invalidate(ctx context.Context, key interface{}) {
+	if h == nil || h.local == nil {
+		return
+	}
+	h.version(conv.String(key)).Add(1)
+	_, _ = h.local.Remove(ctx, key)
+}
+
+// InvalidateAll removes the local replications of all keys, which should be called after
+// clearing. It does nothing if `h` is nil or promotion is disabled.
+func (h *cacheHotKeys) InvalidateAll(ctx context.Context) {
+	if h == nil || h.local == nil {
+		return
+	}
+	for i := range h.versions {
+		h.versions[i].Add(1)
+	}
+	_ = h.local.Clear(ctx)
+}
+
+// version returns the invalidation version of `keyStr`.
+func (h *cacheHotKeys) version(keyStr string) *atomic.Uint64 {
+	return &h.versions[h.sketch.hash(keyStr)%hotKeysVersionStripes]
+}
+
+// Close stops the hot keys detection and closes the local tier.
+func (h *cacheHotKeys) Close(ctx context.Context) {
+	h.closed.Set(true)
+	if h.local != nil {
+		_ = h.local.Close(ctx)
+	}
+}
+
+// decay periodically ages the access frequency of keys.
+func (h *cacheHotKeys) decay(ctx context.Context) {
+	if h.closed.Val() {
+		timer.Exit()
+		return
+	}
+	h.sketch.Decay()
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// hotKeysSketch estimates the access frequency of keys using a count-min sketch, and
+// tracks the top-k most frequent keys.
+type hotKeysSketch struct {
+	mu       sync.Mutex                   // mu ensures the concurrent safety of the sketch.
+	width    uint64                       // width is the number of counters in each row.
+	counters [][]uint32                   // counters is the count-min sketch matrix with depth rows.
+	topK     int                          // topK is the number of the most frequent keys tracked.
+	top      map[string]*hotKeysSketchTop // top is the tracked most frequent keys.
+}
+
+// hotKeysSketchTop is a tracked frequent key.
+type hotKeysSketchTop struct {
+	key   interface{} // Original key.
+	count uint32      // Estimated access count.
+}
+
+func newHotKeysSketch(width, depth, topK int) *hotKeysSketch {
+	counters := make([][]uint32, depth)
+	for i := range counters {
+		counters[i] = make([]uint32, width)
+	}
+	return &hotKeysSketch{
+		width:    uint64(width),
+		counters: counters,
+		topK:     topK,
+		top:      make(map[string]*hotKeysSketchTop, topK),
+	}
+}
+
+// Add increases the counters of `key`, and returns its estimated count and whether it is
+// in the top-k keys.
+func (s *hotKeysSketch) Add(key interface{}, keyStr string) (count uint32, isTop bool) {
+	hash := s.hash(keyStr)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The estimated count is the minimum of all rows, using the double hashing for positions.
+	var (
+		h1 = hash & 0xffffffff
+		h2 = hash>>32 | 1
+	)
+	for i, row := range s.counters {
+		index := (h1 + uint64(i)*h2) % s.width
+		if row[index] < ^uint32(0) {
+			row[index]++
+		}
+		if i == 0 || row[index] < count {
+			count = row[index]
+		}
+	}
+	// Update the top-k keys.
+	if top, ok := s.top[keyStr]; ok {
+		top.count = count
+		return count, true
+	}
+	if len(s.top) < s.topK {
+		s.top[keyStr] = &hotKeysSketchTop{key: key, count: count}
+		return count, true
+	}
+	var (
+		minKey   string
+		minCount = ^uint32(0)
+	)
+	for k, top := range s.top {
+		if top.count < minCount {
+			minKey, minCount = k, top.count
+		}
+	}
+	if count > minCount {
+		delete(s.top, minKey)
+		s.top[keyStr] = &hotKeysSketchTop{key: key, count: count}
+		return count, true
+	}
+	return count, false
+}
+
+// Top returns the tracked top-k keys sorted by their estimated count in descending order.
+func (s *hotKeysSketch) Top() []HotKey {
+	s.mu.Lock()
+	keys := make([]HotKey, 0, len(s.top))
+	for _, top := range s.top {
+		keys = append(keys, HotKey{Key: top.key, Count: int64(top.count)})
+	}
+	s.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Count > keys[j].Count
+	})
+	return keys
+}
+
+// Decay halves all the counters, which ages the history access so that the sketch
+// reflects the recent access frequency. The keys whose count drop to zero are no
+// longer tracked as top keys.
+func (s *hotKeysSketch) Decay() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range s.counters {
+		for i := range row {
+			row[i] >>= 1
+		}
+	}
+	for k, top := range s.top {
+		if top.count >>= 1; top.count == 0 {
+			delete(s.top, k)
+		}
+	}
+}
+
+// hash returns the 64-bit FNV-1a hash of `keyStr`.
+func (s *hotKeysSketch) hash(keyStr string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(keyStr))
+	return h.Sum64()
+}
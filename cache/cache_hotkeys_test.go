@@ -0,0 +1,145 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gocarp/go/container/vars"
+)
+
+// newTestHotKeysCache returns a cache with promotion enabled, in which `key` is promoted.
+func newTestHotKeysCache(t *testing.T, key interface{}) *Cache {
+	t.Helper()
+	var (
+		ctx = context.Background()
+		c   = New()
+	)
+	c.EnableHotKeys(HotKeysConfig{TopK: 4, MinCount: 10, Promote: true, PromoteTTL: time.Minute})
+	t.Cleanup(func() {
+		_ = c.Close(ctx)
+	})
+	_ = c.Set(ctx, key, "old", 0)
+	for i := 0; i < 20; i++ {
+		_, _ = c.Get(ctx, key)
+	}
+	return c
+}
+
+func TestCache_HotKeys(t *testing.T) {
+	var (
+		ctx = context.Background()
+		c   = New()
+	)
+	defer c.Close(ctx)
+	c.EnableHotKeys(HotKeysConfig{TopK: 3, MinCount: 10})
+	for i := 0; i < 50; i++ {
+		_ = c.Set(ctx, i, i, 0)
+	}
+	for i := 0; i < 200; i++ {
+		_, _ = c.Get(ctx, i%50)
+		_, _ = c.Get(ctx, 7)
+	}
+	hot := c.HotKeys()
+	if len(hot) == 0 || hot[0].Key != 7 {
+		t.Fatalf(`got hot keys %v, want 7 the hottest`, hot)
+	}
+	for _, key := range hot {
+		if key.Count < 10 {
+			t.Fatalf(`got hot key %v under the minimum count`, key)
+		}
+	}
+}
+
+func TestCache_HotKeysInvalidate(t *testing.T) {
+	var (
+		ctx = context.Background()
+		f   = func(ctx context.Context) (interface{}, error) {
+			return "new", nil
+		}
+	)
+	for name, set := range map[string]func(c *Cache, key interface{}) (bool, error){
+		"Set": func(c *Cache, key interface{}) (bool, error) {
+			return true, c.Set(ctx, key, "new", 0)
+		},
+		"SetIfNotExist": func(c *Cache, key interface{}) (bool, error) {
+			return c.SetIfNotExist(ctx, key, "new", 0)
+		},
+		"SetIfNotExistFunc": func(c *Cache, key interface{}) (bool, error) {
+			return c.SetIfNotExistFunc(ctx, key, f, 0)
+		},
+		"SetIfNotExistFuncLock": func(c *Cache, key interface{}) (bool, error) {
+			return c.SetIfNotExistFuncLock(ctx, key, f, 0)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := newTestHotKeysCache(t, "k")
+			if v, _ := c.hotKeys.local.Get(ctx, "k"); v.String() != "old" {
+				t.Fatalf(`got %v in local tier, want "old"`, v)
+			}
+			// The key is removed by others, like expiring or another process.
+			_, _ = c.GetAdapter().Remove(ctx, "k")
+			ok, err := set(c, "k")
+			if err != nil || !ok {
+				t.Fatalf(`got %v, %v, want true`, ok, err)
+			}
+			if v, _ := c.Get(ctx, "k"); v.String() != "new" {
+				t.Fatalf(`got %v, want "new"`, v)
+			}
+		})
+	}
+}
+
+// blockingAdapter is the adapter blocking Get after reading the value, until it's released.
+type blockingAdapter struct {
+	Adapter
+	read    chan struct{} // read is notified after reading the value, if it's not nil.
+	release chan struct{} // release releases the blocked Get.
+}
+
+func (a *blockingAdapter) Get(ctx context.Context, key interface{}) (*vars.Var, error) {
+	v, err := a.Adapter.Get(ctx, key)
+	if a.read != nil {
+		a.read <- struct{}{}
+		<-a.release
+	}
+	return v, err
+}
+
+func TestCache_HotKeysConcurrentWrite(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		adapter = &blockingAdapter{Adapter: NewAdapterMemory()}
+		c       = NewWithAdapter(adapter)
+		got     = make(chan *vars.Var)
+	)
+	defer c.Close(ctx)
+	c.EnableHotKeys(HotKeysConfig{TopK: 4, MinCount: 10, Promote: true, PromoteTTL: time.Minute})
+	_ = c.Set(ctx, "k", "old", 0)
+	for i := 0; i < 20; i++ {
+		_, _ = c.Get(ctx, "k")
+	}
+	c.hotKeys.Invalidate(ctx, "k")
+	adapter.read, adapter.release = make(chan struct{}), make(chan struct{})
+	go func() {
+		v, _ := c.Get(ctx, "k")
+		got <- v
+	}()
+	// The old value read before the writing is not promoted after the writing.
+	<-adapter.read
+	adapter.read = nil
+	if err := c.Set(ctx, "k", "new", 0); err != nil {
+		t.Fatal(err)
+	}
+	close(adapter.release)
+	if v := <-got; v.String() != "old" {
+		t.Fatalf(`got %v, want "old" read before writing`, v)
+	}
+	if v, _ := c.Get(ctx, "k"); v.String() != "new" {
+		t.Fatalf(`got %v after writing, want "new"`, v)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/gocarp/helpers/json"
+	"github.com/gocarp/utils/conv"
+)
+
+// ItemInfo is the metadata of a cache item, which is used for debugging and inspection purpose.
+// The fields that the adapter cannot provide are left as their zero values, or -1 for numbers.
+type ItemInfo struct {
+	Key        interface{}   `json:"key"`        // Key of the item.
+	CreatedAt  time.Time     `json:"createdAt"`  // CreatedAt is the time when the item is set.
+	AccessedAt time.Time     `json:"accessedAt"` // AccessedAt is the time when the item is last read.
+	Hits       int64         `json:"hits"`       // Hits is the number of reads of the item, -1 if unknown.
+	TTL        time.Duration `json:"ttl"`        // TTL is the remaining time to live, 0 if it does not expire.
+	Size       int64         `json:"size"`       // Size is the estimated size of the value in bytes, -1 if unknown.
+}
+
+// AdapterInspector is the interface for adapters which support item inspection.
+// The Cache falls back to approximating the item metadata with functions of Adapter
+// if its adapter does not implement this interface.
+type AdapterInspector interface {
+	// Inspect retrieves and returns the metadata of `key`.
+	// It returns nil if the `key` does not exist in the cache.
+	Inspect(ctx context.Context, key interface{}) (*ItemInfo, error)
+
+	// InspectPage retrieves and returns the metadata of at most `limit` items starting from
+	// `cursor`. The `cursor` is 0 for the first page, and the returned `next` cursor should be
+	// passed for the next page. The returned `next` cursor is 0 if there are no more items.
+	InspectPage(ctx context.Context, cursor uint64, limit int) (items []*ItemInfo, next uint64, err error)
+}
+
+const (
+	defaultInspectPageLimit = 100 // Default items limit of one page for inspection.
+)
+
+// Inspect retrieves and returns the metadata of `key` in the default cache.
+// It returns nil if the `key` does not exist in the cache.
+func Inspect(ctx context.Context, key interface{}) (*ItemInfo, error) {
+	return defaultCache.Inspect(ctx, key)
+}
+
+// InspectPage retrieves and returns the metadata of at most `limit` items starting from
+// `cursor` in the default cache. The returned `next` cursor is 0 if there are no more items.
+func InspectPage(ctx context.Context, cursor uint64, limit int) ([]*ItemInfo, uint64, error) {
+	return defaultCache.InspectPage(ctx, cursor, limit)
+}
+
+// Inspect retrieves and returns the metadata of `key`.
+// It returns nil if the `key` does not exist in the cache.
+//
+// If the adapter does not implement AdapterInspector, only the TTL and size of the item
+// are available. The item is read from the adapter directly for its size, so that the
+// inspection is not counted as an access by the hot keys detection.
+func (c *Cache) Inspect(ctx context.Context, key interface{}) (*ItemInfo, error) {
+	if inspector, ok := c.localAdapter.(AdapterInspector); ok {
+		return inspector.Inspect(ctx, key)
+	}
+	ttl, err := c.localAdapter.GetExpire(ctx, key)
+	if err != nil || ttl < 0 {
+		return nil, err
+	}
+	v, err := c.localAdapter.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(-1)
+	if v != nil {
+		size = estimateValueSize(v.Val())
+	}
+	return &ItemInfo{
+		Key:  key,
+		Hits: -1,
+		TTL:  ttl,
+		Size: size,
+	}, nil
+}
+
+// InspectPage retrieves and returns the metadata of at most `limit` items starting from
+// `cursor`. The `cursor` is 0 for the first page, and the returned `next` cursor should be
+// passed for the next page. The returned `next` cursor is 0 if there are no more items.
+//
+// If the adapter does not implement AdapterInspector, the items are paged in the order of
+// the hashes of keys, and all the keys are retrieved from the adapter for each page.
+//
+// It uses default limit 100 if `limit` <= 0.
+func (c *Cache) InspectPage(ctx context.Context, cursor uint64, limit int) (items []*ItemInfo, next uint64, err error) {
+	if limit <= 0 {
+		limit = defaultInspectPageLimit
+	}
+	if inspector, ok := c.localAdapter.(AdapterInspector); ok {
+		return inspector.InspectPage(ctx, cursor, limit)
+	}
+	keys, err := c.Keys(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	pager := newInspectPager(cursor, limit)
+	for _, key := range keys {
+		pager.Add(key)
+	}
+	pageKeys, next := pager.Page()
+	for _, key := range pageKeys {
+		var info *ItemInfo
+		if info, err = c.Inspect(ctx, key); err != nil {
+			return nil, 0, err
+		}
+		if info != nil {
+			items = append(items, info)
+		}
+	}
+	return items, next, nil
+}
+
+// inspectPager selects the keys of a page for inspection in the order of the hashes of keys.
+// The cursor is the hash that the page starts from, so the paging is stable across writes,
+// and it costs O(n log limit) for each page without sorting all the keys.
+type inspectPager struct {
+	cursor uint64                   // cursor is the hash that the page starts from.
+	limit  int                      // limit is the number of the hashes of the page.
+	hashes inspectHashHeap          // hashes is the smallest hashes from cursor in max-heap.
+	keys   map[uint64][]interface{} // keys is the hash to keys mapping of the selected hashes.
+	more   bool                     // more marks that there are keys after the page.
+}
+
+// inspectHashHeap is the max-heap of hashes.
+type inspectHashHeap []uint64
+
+func newInspectPager(cursor uint64, limit int) *inspectPager {
+	if limit <= 0 {
+		limit = defaultInspectPageLimit
+	}
+	return &inspectPager{
+		cursor: cursor,
+		limit:  limit,
+		keys:   make(map[uint64][]interface{}),
+	}
+}
+
+// Add adds `key` as the candidate of the page.
+func (p *inspectPager) Add(key interface{}) {
+	hash := inspectKeyHash(key)
+	if hash < p.cursor {
+		return
+	}
+	// The keys of the same hash are in the same page.
+	if keys, ok := p.keys[hash]; ok {
+		p.keys[hash] = append(keys, key)
+		return
+	}
+	if len(p.hashes) == p.limit {
+		p.more = true
+		if hash > p.hashes[0] {
+			return
+		}
+		delete(p.keys, heap.Pop(&p.hashes).(uint64))
+	}
+	heap.Push(&p.hashes, hash)
+	p.keys[hash] = []interface{}{key}
+}
+
+// Page returns the keys of the page in the order of hashes, and the cursor of the next page,
+// which is 0 if there are no more keys.
+func (p *inspectPager) Page() (keys []interface{}, next uint64) {
+	hashes := make([]uint64, len(p.hashes))
+	copy(hashes, p.hashes)
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i] < hashes[j]
+	})
+	for _, hash := range hashes {
+		keys = append(keys, p.keys[hash]...)
+	}
+	if p.more {
+		next = hashes[len(hashes)-1] + 1
+	}
+	return keys, next
+}
+
+// inspectKeyHash returns the hash of the string form of `key` for paging.
+func inspectKeyHash(key interface{}) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(conv.String(key)))
+	return h.Sum64()
+}
+
+func (h inspectHashHeap) Len() int            { return len(h) }
+func (h inspectHashHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h inspectHashHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *inspectHashHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+func (h *inspectHashHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// estimateValueSize estimates and returns the size of `value` in bytes.
+// It uses the length for string and bytes, and the length of JSON encoding for other types.
+func estimateValueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return -1
+	}
+	return int64(len(b))
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache_Inspect(t *testing.T) {
+	var (
+		ctx = context.Background()
+		c   = New()
+	)
+	defer c.Close(ctx)
+	if err := c.Set(ctx, "k", "value", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = c.Get(ctx, "k")
+	_, _ = c.Get(ctx, "k")
+	info, err := c.Inspect(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Hits != 2 {
+		t.Fatalf(`got hits %d, want 2`, info.Hits)
+	}
+	if info.Size != int64(len("value")) {
+		t.Fatalf(`got size %d, want %d`, info.Size, len("value"))
+	}
+	if info.TTL <= 0 || info.TTL > time.Minute {
+		t.Fatalf(`got ttl %v, want in (0, 1m]`, info.TTL)
+	}
+	if info.CreatedAt.IsZero() || info.AccessedAt.Before(info.CreatedAt) {
+		t.Fatalf(`invalid times %v, %v`, info.CreatedAt, info.AccessedAt)
+	}
+	if info, err = c.Inspect(ctx, "none"); err != nil || info != nil {
+		t.Fatalf(`got %v, %v for absent key, want nil`, info, err)
+	}
+}
+
+func TestCache_InspectPage(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		c    = New()
+		seen = make(map[interface{}]int)
+	)
+	defer c.Close(ctx)
+	for i := 0; i < 50; i++ {
+		_ = c.Set(ctx, i, i, 0)
+	}
+	var cursor uint64
+	for {
+		items, next, err := c.InspectPage(ctx, cursor, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) > 7 {
+			t.Fatalf(`got %d items, want at most 7`, len(items))
+		}
+		for _, item := range items {
+			seen[item.Key]++
+		}
+		// The writes during paging do not change the pages of the existing keys.
+		_ = c.Set(ctx, fmt.Sprintf("new-%d", cursor), 0, 0)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	for i := 0; i < 50; i++ {
+		if seen[i] != 1 {
+			t.Fatalf(`got key %d returned %d times, want once`, i, seen[i])
+		}
+	}
+}
+
+func TestInspectPager_SameHash(t *testing.T) {
+	var (
+		cursor uint64
+		seen   int
+	)
+	// The keys of the same string form have the same hash, which are in the same page.
+	for {
+		pager := newInspectPager(cursor, 1)
+		for _, key := range []interface{}{1, "1", 2} {
+			pager.Add(key)
+		}
+		keys, next := pager.Page()
+		if fmt.Sprint(keys) != "[1 1]" && fmt.Sprint(keys) != "[2]" {
+			t.Fatalf(`got page keys %v, want [1 1] or [2]`, keys)
+		}
+		seen += len(keys)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if seen != 3 {
+		t.Fatalf(`got %d keys by paging, want 3`, seen)
+	}
+}
+
+func TestCache_InspectFallback(t *testing.T) {
+	var (
+		ctx = context.Background()
+		c   = NewWithAdapter(newTestAdapterFile(t, filepath.Join(t.TempDir(), "cache.log")))
+	)
+	defer c.Close(ctx)
+	c.EnableHotKeys(HotKeysConfig{MinCount: 1})
+	_ = c.Set(ctx, "k", "value", 0)
+	for i := 0; i < 10; i++ {
+		info, err := c.Inspect(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Hits != -1 || info.Size != int64(len("value")) {
+			t.Fatalf(`got %+v, want unknown hits and size %d`, info, len("value"))
+		}
+	}
+	// The inspection is not counted as access.
+	if keys := c.HotKeys(); len(keys) != 0 {
+		t.Fatalf(`got hot keys %v, want none`, keys)
+	}
+}
@@ -29,6 +29,24 @@ func (e *Event) IsRename() bool {
 	return e.Op&RENAME == RENAME
 }
 
+// IsMove checks whether current event is file/folder moving event, whose OldPath is the
+// path before moving. Note that the moving event is also the create event of its Path.
+func (e *Event) IsMove() bool {
+	return e.Op&MOVE == MOVE
+}
+
+// IsOverflow checks whether current event is the losing of events. The Path of the event is
+// the bound path of the callback, under which the changes might be missed.
+func (e *Event) IsOverflow() bool {
+	return e.Op&OVERFLOW == OVERFLOW
+}
+
+// IsInitial checks whether current event is the synthetic CREATE event for the existing entry
+// at registration, which is emitted if WatchOptions.InitialEvents is enabled.
+func (e *Event) IsInitial() bool {
+	return e.initial
+}
+
 // IsChmod checks whether current event contains file/folder chmod event.
 func (e *Event) IsChmod() bool {
 	return e.Op&CHMOD == CHMOD
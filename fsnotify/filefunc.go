@@ -6,6 +6,8 @@ package fsnotify
 
 import (
 	"fmt"
+	"hash/fnv"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -56,7 +58,9 @@ func fileIsDir(path string) bool {
 }
 
 // fileAllDirs returns all sub-folders including itself of given `path` recursively.
-func fileAllDirs(path string) (list []string) {
+// The optional parameter `skip` specifies the sub-folders that are not returned and not
+// scanned recursively.
+func fileAllDirs(path string, skip ...func(path string) bool) (list []string) {
 	list = []string{path}
 	file, err := os.Open(path)
 	if err != nil {
@@ -70,7 +74,10 @@ func fileAllDirs(path string) (list []string) {
 	for _, name := range names {
 		tempPath := fmt.Sprintf("%s%s%s", path, string(filepath.Separator), name)
 		if fileIsDir(tempPath) {
-			if array := fileAllDirs(tempPath); len(array) > 0 {
+			if len(skip) > 0 && skip[0] != nil && skip[0](tempPath) {
+				continue
+			}
+			if array := fileAllDirs(tempPath, skip...); len(array) > 0 {
 				list = append(list, array...)
 			}
 		}
@@ -130,3 +137,17 @@ func doFileScanDir(path string, pattern string, recursive ...bool) ([]string, er
 	}
 	return list, nil
 }
+
+// fileContentHash returns the 64-bit FNV-1a hash of the content of file `path`.
+func fileContentHash(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, errors.Wrapf(err, `os.Open failed for path "%s"`, path)
+	}
+	defer file.Close()
+	h := fnv.New64a()
+	if _, err = io.Copy(h, file); err != nil {
+		return 0, errors.Wrapf(err, `read file failed for path "%s"`, path)
+	}
+	return h.Sum64(), nil
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows || plan9
+
+package fsnotify
+
+import (
+	"os"
+)
+
+// fileInode returns 0 as the inode number is not available from `info` in this system.
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
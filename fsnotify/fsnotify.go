@@ -15,7 +15,6 @@ import (
 	"github.com/gocarp/codes"
 	"github.com/gocarp/errors"
 	"github.com/gocarp/go/cache"
-	"github.com/gocarp/go/container/list"
 	"github.com/gocarp/go/container/maps"
 	"github.com/gocarp/go/container/queue"
 	"github.com/gocarp/go/container/set"
@@ -25,30 +24,154 @@ import (
 
 // Watcher is the monitor for file changes.
 type Watcher struct {
-	watcher   *fsnotify.Watcher // Underlying fsnotify object.
-	events    *queue.Queue      // Used for internal event management.
-	cache     *cache.Cache      // Used for repeated event filter.
-	nameSet   *set.StrSet       // Used for AddOnce feature.
-	callbacks *maps.StrAnyMap   // Path(file/folder) to callbacks mapping.
-	closeChan chan struct{}     // Used for watcher closing notification.
+	watcher     Backend         // Underlying monitor.
+	events      *queue.Queue    // Used for internal event management.
+	cache       *cache.Cache    // Used for repeated event filter.
+	nameSet     *set.StrSet     // Used for AddOnce feature.
+	callbacks   *callbackTrie   // Path(file/folder) to callbacks mapping.
+	callbackIds *maps.IntAnyMap // Id to callback mapping.
+	idGenerator *types.Int      // Atomic id generator for callback.
+	renames     *renameTracker  // Used for pairing RENAME and CREATE events as MOVE event.
+	config      WatcherConfig   // Configuration of the watcher.
+	journal     *journal        // History of the handled events, which is nil if journal is disabled.
+	contents    *contentTracker // Content hashes of files for suppressing unchanged WRITE events.
+	dirs        *set.StrSet     // Known directories, which tells whether the removed paths are directories.
+	workers     chan struct{}   // Slots of the workers calling callback functions, which is nil if unlimited.
+	budgetMu    sync.Mutex      // Used for checking and adding watches within budget in serial.
+	closed      *types.Bool     // Used for marking the watcher closed.
+	closeChan   chan struct{}   // Used for watcher closing notification.
+}
+
+// WatcherConfig is the configuration for creating a Watcher.
+type WatcherConfig struct {
+	// Backend is the type of the underlying monitor, default is BackendAuto.
+	Backend BackendType
+
+	// PollInterval is the interval of polling for BackendPoll, or for the fallback polling
+	// of BackendAuto, default is one second.
+	PollInterval time.Duration
+
+	// ErrorHandler handles the errors of the watcher, like the errors of the underlying monitor
+	// and the failures of re-adding monitors. It also recovers the panics of callback functions
+	// as errors with code CodeInternalPanic. The errors are only logged internally if it's nil,
+	// and the panics of callback functions are not recovered.
+	ErrorHandler func(err error)
+
+	// LifecycleHandler handles the notifications of the bound paths of callbacks, like the
+	// bound path is removed or becomes unwatchable.
+	// Note that it's called in the event loop, so it should not block.
+	LifecycleHandler func(event *LifecycleEvent)
+
+	// JournalSize is the number of the recent events kept in the journal with sequence numbers
+	// and timestamps, which can be read by Watcher.Since. The journal is disabled if it's 0.
+	// Note that the events that no callback subscribes are not recorded.
+	JournalSize int
+
+	// JournalPath is the file persisting the journal, so that the events and sequence numbers
+	// are kept across restarts. The journal is kept only in memory if it's empty.
+	JournalPath string
+
+	// MaxWorkers limits the number of the callback functions called concurrently, including
+	// the events waiting for the serial callbacks. The watcher stops taking events from its
+	// queue if all the workers are busy, which keeps the events in order in the queue.
+	// It calls each callback function in a new goroutine without limit if it's 0.
+	MaxWorkers int
+
+	// WatchBudget limits the number of the watches of the system notification that the watcher
+	// holds, like the watches limited by fs.inotify.max_user_watches in linux systems, which is
+	// shared by all the watchers of the user. The adding checks the budget before monitoring
+	// any path, see Preflight. It is not limited if it's 0.
+	WatchBudget int
+
+	// BudgetPolicy is the policy when WatchBudget is exhausted, default is BudgetFail.
+	BudgetPolicy BudgetPolicy
 }
 
 // Callback is the callback function for Watcher.
 type Callback struct {
-	Id        int                // Unique id for callback object.
-	Func      func(event *Event) // Callback function.
-	Path      string             // Bound file path (absolute).
-	name      string             // Registered name for AddOnce.
-	elem      *list.Element      // Element in the callbacks of watcher.
-	recursive bool               // Is bound to path recursively or not.
+	Id           int                  // Unique id for callback object.
+	Func         func(event *Event)   // Callback function.
+	Path         string               // Bound file path (absolute).
+	name         string               // Registered name for AddOnce.
+	recursive    bool                 // Is bound to path recursively or not.
+	options      WatchOptions         // Options for the callback.
+	debouncer    *debouncer           // Debouncer for merging events, which is nil if debounce is disabled.
+	filter       *pathFilter          // Filter for sub-paths, which is nil if there are no patterns.
+	subscription *subscription        // Subscription for channel delivery, which is nil if it's not created by Subscribe.
+	initializer  *callbackInitializer // Initializer for synthetic events, which is nil if InitialEvents is disabled.
+	serial       *serialExecutor      // Executor calling the callback function in order, which is nil if Serial is disabled.
+	snapshot     *treeSnapshot        // Last known state for resynchronization, which is nil if Resync is disabled.
+	file         *fileWatch           // Single file watch, which is nil if it's not created by WatchFile.
+	created      *set.StrSet          // Monitors added for the entries created under the path, which is nil if it's recursive.
+	mu           sync.Mutex           // Mutex for concurrent safety of stopContext and released.
+	stopContext  func() bool          // Stops the context cleanup, which is nil if it's not created by AddContext.
+	released     bool                 // Is released after removed from watcher or not.
+}
+
+// WatchOptions is the options for adding a callback to the watcher.
+type WatchOptions struct {
+	// NoRecursive specifies not monitoring the path recursively.
+	// It monitors the path recursively in default.
+	NoRecursive bool
+
+	// Debounce is the window for merging the events of the same path into one delivery,
+	// whose Op is the union of all the merged events. The window restarts on each new
+	// event of the path. It delivers every event separately if Debounce is 0.
+	Debounce time.Duration
+
+	// DebounceLeading delivers the first event of the path immediately, and merges the
+	// following events in the window into one delivery at the end of the window.
+	// It delivers only at the end of the window (trailing edge) in default.
+	DebounceLeading bool
+
+	// Include is the gitignore-style patterns of paths relative to the monitored path,
+	// the events of sub-paths are dispatched only if they match any of the patterns.
+	// All sub-paths are dispatched if Include is empty.
+	Include []string
+
+	// Exclude is the gitignore-style patterns of paths relative to the monitored path,
+	// the events of sub-paths matching the patterns are not dispatched, and the matched
+	// directories are not monitored, like ".git/", "node_modules/" or "*.swp".
+	Exclude []string
+
+	// Ops is the bits union of operations that the callback subscribes, like CREATE|WRITE.
+	// The callback receives only the events containing any of the operations.
+	// It subscribes all operations if Ops is 0.
+	Ops Op
+
+	// InitialEvents emits synthetic CREATE events for the existing entries under the path at
+	// registration, which are dispatched in order before any real events of the callback, and
+	// delivered the same as the real events, like limited by MaxWorkers.
+	// The synthetic events can be told by Event.IsInitial.
+	InitialEvents bool
+
+	// Resync keeps the last known state of the entries under the path, and rescans the path
+	// if events are lost, which dispatches the missed changes after the OVERFLOW event.
+	// Note that the missed change of the path having dispatched event is detected by its
+	// modification time, so the missed CHMOD after the event is not dispatched.
+	Resync bool
+
+	// SuppressUnchangedWrites drops the WRITE events that do not change the content of files,
+	// like the files are rewritten with identical content. The existing files are hashed in
+	// background at registration, and the content is hashed again only if the modification
+	// time of the file changes but the size does not. Note that the WRITE event of a file
+	// without known hash, like the file created after registration, is always dispatched.
+	SuppressUnchangedWrites bool
+
+	// Serial calls the callback function with the events one by one in order, instead of
+	// calling it concurrently for each event.
+	Serial bool
 }
 
 // Event is the event produced by underlying fsnotify.
 type Event struct {
 	event   fsnotify.Event // Underlying event.
 	Path    string         // Absolute file path.
+	OldPath string         // Absolute file path before moving, which is only set for MOVE event.
 	Op      Op             // File operation.
 	Watcher *Watcher       // Parent watcher.
+	initial bool           // Is synthetic event for the existing entry at registration or not.
+	flushed func()         // Called after the event is flushed, which is only set for the internal flushing event.
 }
 
 // Op is the bits union for file operations.
@@ -63,6 +186,26 @@ const (
 	REMOVE
 	RENAME
 	CHMOD
+	MOVE     // MOVE is the moving from OldPath to Path, which pairs the RENAME and CREATE events and is always with CREATE.
+	OVERFLOW // OVERFLOW is the losing of events, which is dispatched to all callbacks subscribing it with their bound paths.
+)
+
+// Match checks whether `op` contains any of the operations of `ops`, which is the operation
+// mask of WatchOptions.Ops. It always returns true if `ops` is 0, which means all operations.
+func (op Op) Match(ops Op) bool {
+	return ops == 0 || op&ops != 0
+}
+
+const (
+	// opsInternal is the operations that the watcher handles internally for maintaining the
+	// monitors, which are always queued even if no callback subscribes them.
+	opsInternal = CREATE | REMOVE | RENAME
+)
+
+const (
+	// renamePairDuration is the duration waiting for the CREATE event pairing with a RENAME
+	// event as MOVE event. The RENAME event is dispatched as it is if no pairing.
+	renamePairDuration = 10 * time.Millisecond
 )
 
 const (
@@ -71,29 +214,61 @@ const (
 )
 
 var (
-	mu                  sync.Mutex                // Mutex for concurrent safety of defaultWatcher.
-	defaultWatcher      *Watcher                  // Default watcher.
-	callbackIdMap       = maps.NewIntAnyMap(true) // Id to callback mapping.
-	callbackIdGenerator = types.NewInt()          // Atomic id generator for callback.
+	mu             sync.Mutex // Mutex for concurrent safety of defaultWatcher.
+	defaultWatcher *Watcher   // Default watcher.
 )
 
 // New creates and returns a new watcher.
 // Note that the watcher number is limited by the file handle setting of the system.
 // Eg: fs.inotify.max_user_instances system variable in linux systems.
+// It falls back to polling if the limit is exhausted.
 func New() (*Watcher, error) {
-	w := &Watcher{
-		cache:     cache.New(),
-		events:    queue.New(),
-		nameSet:   set.NewStrSet(true),
-		closeChan: make(chan struct{}),
-		callbacks: maps.NewStrAnyMap(true),
-	}
-	if watcher, err := fsnotify.NewWatcher(); err == nil {
-		w.watcher = watcher
-	} else {
+	return NewWithConfig(WatcherConfig{})
+}
+
+// NewWithConfig creates and returns a new watcher with custom configuration `config`.
+func NewWithConfig(config WatcherConfig) (*Watcher, error) {
+	watcher, err := newBackend(config.Backend, config.PollInterval)
+	if err != nil {
 		intlog.Printf(context.TODO(), "New watcher failed: %v", err)
 		return nil, err
 	}
+	return NewWithBackend(watcher, config)
+}
+
+// NewWithBackend creates and returns a new watcher using custom underlying monitor `backend`
+// with custom configuration `config`, in which the Backend and PollInterval are ignored.
+// The `backend` is closed when the watcher is closed, or if it fails creating the watcher.
+func NewWithBackend(backend Backend, config WatcherConfig) (*Watcher, error) {
+	w := &Watcher{
+		watcher:     backend,
+		cache:       cache.New(),
+		events:      queue.New(),
+		nameSet:     set.NewStrSet(true),
+		closed:      types.NewBool(),
+		closeChan:   make(chan struct{}),
+		callbacks:   newCallbackTrie(),
+		callbackIds: maps.NewIntAnyMap(true),
+		idGenerator: types.NewInt(),
+		contents:    newContentTracker(),
+		dirs:        set.NewStrSet(true),
+		config:      config,
+	}
+	if config.MaxWorkers > 0 {
+		w.workers = make(chan struct{}, config.MaxWorkers)
+	}
+	if config.JournalSize > 0 {
+		journal, err := newJournal(config.JournalSize, config.JournalPath)
+		if err != nil {
+			intlog.Printf(context.TODO(), "New watcher failed: %v", err)
+			_ = backend.Close()
+			return nil, err
+		}
+		w.journal = journal
+	}
+	w.renames = newRenameTracker(func(event *Event) {
+		w.events.Push(event)
+	})
 	w.watchLoop()
 	w.eventLoop()
 	return w, nil
@@ -109,6 +284,46 @@ func Add(path string, callbackFunc func(event *Event), recursive ...bool) (callb
 	return w.Add(path, callbackFunc, recursive...)
 }
 
+// AddWithOptions monitors `path` using default watcher with callback function `callbackFunc`
+// and custom options `options`.
+func AddWithOptions(path string, callbackFunc func(event *Event), options WatchOptions) (callback *Callback, err error) {
+	w, err := getDefaultWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return w.AddWithOptions(path, callbackFunc, options)
+}
+
+// AddContext monitors `path` using default watcher with callback function `callbackFunc` and
+// custom options `options`, which is removed automatically when `ctx` is done.
+func AddContext(ctx context.Context, path string, callbackFunc func(event *Event), options WatchOptions) (callback *Callback, err error) {
+	w, err := getDefaultWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return w.AddContext(ctx, path, callbackFunc, options)
+}
+
+// Subscribe monitors `path` using default watcher, and returns the channel receiving the events
+// in order and the function cancelling the subscription.
+func Subscribe(path string, options SubscribeOptions) (events <-chan *Event, cancel func(), err error) {
+	w, err := getDefaultWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	return w.Subscribe(path, options)
+}
+
+// WatchFile monitors the single file `path` using default watcher with callback function
+// `callbackFunc`, which survives the atomic replacements of the file.
+func WatchFile(path string, callbackFunc func(event *Event)) (callback *Callback, err error) {
+	w, err := getDefaultWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return w.WatchFile(path, callbackFunc)
+}
+
 // AddOnce monitors `path` using default watcher with callback function `callbackFunc` only once using unique name `name`.
 // If AddOnce is called multiple times with the same `name` parameter, `path` is only added to monitor once. It returns error
 // if it's called twice with the same `name`.
@@ -137,17 +352,22 @@ func RemoveCallback(callbackId int) error {
 	if err != nil {
 		return err
 	}
-	callback := (*Callback)(nil)
-	if r := callbackIdMap.Get(callbackId); r != nil {
-		callback = r.(*Callback)
-	}
-	if callback == nil {
+	if !w.callbackIds.Contains(callbackId) {
 		return errors.NewCodef(codes.CodeInvalidParameter, `callback for id %d not found`, callbackId)
 	}
 	w.RemoveCallback(callbackId)
 	return nil
 }
 
+// Callbacks returns all the callbacks of default watcher in registering order.
+func Callbacks() ([]*Callback, error) {
+	w, err := getDefaultWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return w.Callbacks(), nil
+}
+
 // Exit is only used in the callback function, which can be used to remove current callback
 // of itself from the watcher.
 func Exit() {
@@ -0,0 +1,131 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testEventTimeout = 2 * time.Second // Timeout for receiving an expected event in tests.
+)
+
+// newTestWatcher creates and returns a watcher using a fake backend, which is closed after the test.
+func newTestWatcher(t *testing.T, config ...WatcherConfig) (*Watcher, *FakeBackend) {
+	t.Helper()
+	var c WatcherConfig
+	if len(config) > 0 {
+		c = config[0]
+	}
+	backend := NewFakeBackend()
+	w, err := NewWithBackend(backend, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Close)
+	return w, backend
+}
+
+// newTestSubscription subscribes `path` of `w` with `options`, which is cancelled after the test.
+func newTestSubscription(t *testing.T, w *Watcher, path string, options SubscribeOptions) <-chan *Event {
+	t.Helper()
+	events, cancel, err := w.Subscribe(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cancel)
+	return events
+}
+
+// newTestDir creates a temporary directory with the files of `names`, and returns its real path.
+func newTestDir(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := fileRealPath(t.TempDir())
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// receiveEvent receives and returns the next event of `events`, and fails the test if no event
+// is received in time.
+func receiveEvent(t *testing.T, events <-chan *Event) *Event {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal(`events channel is closed`)
+		}
+		return event
+	case <-time.After(testEventTimeout):
+		t.Fatal(`timeout waiting for event`)
+	}
+	return nil
+}
+
+// receiveError receives and returns the next error of `errs`, and fails the test if no error
+// is received in time.
+func receiveError(t *testing.T, errs <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(testEventTimeout):
+		t.Fatal(`timeout waiting for error`)
+	}
+	return nil
+}
+
+// expectNoEvent fails the test if any event of `events` is received in `duration`.
+func expectNoEvent(t *testing.T, events <-chan *Event, duration time.Duration) {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Fatalf(`unexpected event %s %v`, event.Path, event.Op)
+		}
+	case <-time.After(duration):
+	}
+}
+
+// expectFlushed fails the test if any event of `events` is received before `backend` is
+// flushed, which means nothing more is dispatched to `events`.
+// Note that the flushing waits for all the subscriptions, whose events should be received.
+func expectFlushed(t *testing.T, backend *FakeBackend, events <-chan *Event) {
+	t.Helper()
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- backend.Flush()
+	}()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Fatalf(`unexpected event %s %v`, event.Path, event.Op)
+		}
+	case err := <-flushed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(testEventTimeout):
+		t.Fatal(`timeout waiting for flushing`)
+	}
+}
+
+// emitEvent injects the event of `op` on `path` to `backend`, which fails the test on error.
+func emitEvent(t *testing.T, backend *FakeBackend, path string, op Op) {
+	t.Helper()
+	if err := backend.Emit(path, op); err != nil {
+		t.Fatal(err)
+	}
+}
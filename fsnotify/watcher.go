@@ -6,10 +6,11 @@ package fsnotify
 
 import (
 	"context"
+	"sort"
 
 	"github.com/gocarp/codes"
 	"github.com/gocarp/errors"
-	"github.com/gocarp/go/container/list"
+	"github.com/gocarp/go/container/set"
 	"github.com/gocarp/helpers/intlog"
 )
 
@@ -29,10 +30,46 @@ func (w *Watcher) Add(path string, callbackFunc func(event *Event), recursive ..
 // The optional parameter `recursive` specifies whether monitoring the `path` recursively,
 // which is true in default.
 func (w *Watcher) AddOnce(name, path string, callbackFunc func(event *Event), recursive ...bool) (callback *Callback, err error) {
+	var options WatchOptions
+	if len(recursive) > 0 {
+		options.NoRecursive = !recursive[0]
+	}
+	return w.addOnceWithOptions(name, path, callbackFunc, options, nil)
+}
+
+// AddWithOptions monitors `path` with callback function `callbackFunc` and custom options
+// `options` to the watcher.
+func (w *Watcher) AddWithOptions(path string, callbackFunc func(event *Event), options WatchOptions) (callback *Callback, err error) {
+	return w.addOnceWithOptions("", path, callbackFunc, options, nil)
+}
+
+// addOnceWithOptions monitors `path` with callback function `callbackFunc` and custom options
+// `options` only once using unique name `name` to the watcher.
+// It always adds the monitor if `name` is empty.
+// The events are delivered to `subscription` instead of `callbackFunc` if it's not nil.
+//
+// If the watch budget is set, it removes the callback and the monitors added for it if any
+// path fails monitoring. Or else, like before the budget, it keeps adding the other sub-folders
+// and returns the callback along with the error of the last sub-folder.
+func (w *Watcher) addOnceWithOptions(
+	name, path string, callbackFunc func(event *Event), options WatchOptions, subscription *subscription,
+) (callback *Callback, err error) {
+	if w.config.WatchBudget > 0 {
+		// The budget is held from checking until the adding is done, so that the concurrent
+		// adding does not pass the checking with the same available watches.
+		w.budgetMu.Lock()
+		defer w.budgetMu.Unlock()
+		// It checks the budget before monitoring any path, which fails without side effects.
+		if _, err = w.Preflight(path, options); err != nil {
+			return nil, err
+		}
+	}
+	var rollback bool
 	w.nameSet.AddIfNotExistFuncLock(name, func() bool {
 		// Firstly add the path to watcher.
-		callback, err = w.addWithCallbackFunc(name, path, callbackFunc, recursive...)
+		callback, err = w.addWithCallbackFunc(name, path, callbackFunc, options, subscription)
 		if err != nil {
+			rollback = callback != nil && w.config.WatchBudget > 0
 			return false
 		}
 		// If it's recursive adding, it then adds all sub-folders to the monitor.
@@ -41,11 +78,15 @@ func (w *Watcher) AddOnce(name, path string, callbackFunc func(event *Event), re
 		//    because if the folders are monitored and their sub-files are also monitored.
 		// 2. It bounds no callbacks to the folders, because it will search the callbacks
 		//    from its parent recursively if any event produced.
-		if fileIsDir(path) && (len(recursive) == 0 || recursive[0]) {
-			for _, subPath := range fileAllDirs(path) {
+		if fileIsDir(path) && !options.NoRecursive {
+			for _, subPath := range fileAllDirs(callback.Path, callback.filter.ExcludesDir) {
 				if fileIsDir(subPath) {
-					if err = w.watcher.Add(subPath); err != nil {
+					if err = w.addWatchLocked(subPath); err != nil {
 						err = errors.Wrapf(err, `add watch failed for path "%s"`, subPath)
+						if w.config.WatchBudget > 0 {
+							rollback = true
+							return false
+						}
 					} else {
 						intlog.Printf(context.TODO(), "watcher adds monitor for: %s", subPath)
 					}
@@ -57,83 +98,112 @@ func (w *Watcher) AddOnce(name, path string, callbackFunc func(event *Event), re
 		}
 		return true
 	})
+	if rollback {
+		// It removes the callback and the monitors added for it, so that the failed adding
+		// leaves no part of the tree monitored. The name is not registered as it fails.
+		callback.name = ""
+		w.removeCallbackAndMonitors(callback)
+		return nil, err
+	}
+	if err == nil && callback != nil && callback.initializer != nil {
+		go w.emitInitialEvents(callback)
+	}
+	if err == nil && callback != nil && options.SuppressUnchangedWrites {
+		go w.seedContents(callback)
+	}
 	return
 }
 
 // addWithCallbackFunc adds the path to underlying monitor, creates and returns a callback object.
 // Very note that if it calls multiple times with the same `path`, the latest one will overwrite the previous one.
-func (w *Watcher) addWithCallbackFunc(name, path string, callbackFunc func(event *Event), recursive ...bool) (callback *Callback, err error) {
+func (w *Watcher) addWithCallbackFunc(
+	name, path string, callbackFunc func(event *Event), options WatchOptions, subscription *subscription,
+) (callback *Callback, err error) {
 	// Check and convert the given path to absolute path.
 	if t := fileRealPath(path); t == "" {
 		return nil, errors.NewCodef(codes.CodeInvalidParameter, `"%s" does not exist`, path)
 	} else {
 		path = t
 	}
+	filter, err := newPathFilter(path, options.Include, options.Exclude)
+	if err != nil {
+		return nil, err
+	}
 	// Create callback object.
 	callback = &Callback{
-		Id:        callbackIdGenerator.Add(1),
-		Func:      callbackFunc,
-		Path:      path,
-		name:      name,
-		recursive: true,
+		Id:           w.idGenerator.Add(1),
+		Func:         callbackFunc,
+		Path:         path,
+		name:         name,
+		recursive:    !options.NoRecursive,
+		options:      options,
+		filter:       filter,
+		subscription: subscription,
 	}
-	if len(recursive) > 0 {
-		callback.recursive = recursive[0]
+	if !callback.recursive {
+		callback.created = set.NewStrSet(true)
+	}
+	if options.InitialEvents {
+		callback.initializer = &callbackInitializer{}
+	}
+	if options.Resync {
+		callback.snapshot = newTreeSnapshot(path, callback.recursive, filter)
+	}
+	if options.Serial {
+		callback.serial = newSerialExecutor(func(event *Event) {
+			w.doCallback(callback, event)
+		}, w.releaseWorker)
+	}
+	if options.Debounce > 0 {
+		callback.debouncer = newDebouncer(options.Debounce, options.DebounceLeading, func(event *Event) {
+			w.deliver(callback, event)
+		})
 	}
 	// Register the callback to watcher.
-	w.callbacks.LockFunc(func(m map[string]interface{}) {
-		l := (*list.List)(nil)
-		if v, ok := m[path]; !ok {
-			l = list.New(true)
-			m[path] = l
-		} else {
-			l = v.(*list.List)
-		}
-		callback.elem = l.PushBack(callback)
-	})
+	w.callbacks.Add(callback)
 	// Add the path to underlying monitor.
-	if err = w.watcher.Add(path); err != nil {
+	if err = w.addWatchLocked(path); err != nil {
 		err = errors.Wrapf(err, `add watch failed for path "%s"`, path)
 	} else {
 		intlog.Printf(context.TODO(), "watcher adds monitor for: %s", path)
 	}
-	// Add the callback to global callback map.
-	callbackIdMap.Set(callback.Id, callback)
+	// Add the callback to the id mapping of the watcher.
+	w.callbackIds.Set(callback.Id, callback)
 	return
 }
 
-// Close closes the watcher.
+// Close closes the watcher, and unregisters all its callbacks.
+// It does nothing if the watcher is already closed.
 func (w *Watcher) Close() {
+	if !w.closed.Cas(false, true) {
+		return
+	}
 	close(w.closeChan)
 	if err := w.watcher.Close(); err != nil {
-		intlog.Errorf(context.TODO(), `%+v`, err)
+		w.handleError(errors.Wrap(err, `close watcher failed`))
 	}
+	w.renames.Close()
 	w.events.Close()
+	if w.journal != nil {
+		if err := w.journal.Close(); err != nil {
+			w.handleError(err)
+		}
+	}
+	// It unregisters all the callbacks, which closes the channels of the subscriptions.
+	for _, callback := range w.allCallbacks() {
+		w.callbacks.Remove(callback)
+		w.unregisterCallback(callback)
+	}
 }
 
 // Remove removes monitor and all callbacks associated with the `path` recursively.
 func (w *Watcher) Remove(path string) error {
 	// Firstly remove the callbacks of the path.
-	if value := w.callbacks.Remove(path); value != nil {
-		list := value.(*list.List)
-		for {
-			if item := list.PopFront(); item != nil {
-				callbackIdMap.Remove(item.(*Callback).Id)
-			} else {
-				break
-			}
-		}
+	for _, callback := range w.callbacks.RemovePath(path) {
+		w.unregisterCallback(callback)
 	}
 	// Secondly remove monitor of all sub-files which have no callbacks.
-	if subPaths, err := fileScanDir(path, "*", true); err == nil && len(subPaths) > 0 {
-		for _, subPath := range subPaths {
-			if w.checkPathCanBeRemoved(subPath) {
-				if internalErr := w.watcher.Remove(subPath); internalErr != nil {
-					intlog.Errorf(context.TODO(), `%+v`, internalErr)
-				}
-			}
-		}
-	}
+	w.removeSubMonitors(path)
 	// Lastly remove the monitor of the path from underlying monitor.
 	err := w.watcher.Remove(path)
 	if err != nil {
@@ -142,55 +212,74 @@ func (w *Watcher) Remove(path string) error {
 	return err
 }
 
-// checkPathCanBeRemoved checks whether the given path have no callbacks bound.
-func (w *Watcher) checkPathCanBeRemoved(path string) bool {
-	// Firstly check the callbacks in the watcher directly.
-	if v := w.callbacks.Get(path); v != nil {
-		return false
-	}
-	// Secondly check its parent whether it has callbacks.
-	dirPath := fileDir(path)
-	if v := w.callbacks.Get(dirPath); v != nil {
-		for _, c := range v.(*list.List).FrontAll() {
-			if c.(*Callback).recursive {
-				return false
-			}
-		}
-		return false
-	}
-	// Recursively check its parent.
-	parentDirPath := ""
-	for {
-		parentDirPath = fileDir(dirPath)
-		if parentDirPath == dirPath {
-			break
-		}
-		if v := w.callbacks.Get(parentDirPath); v != nil {
-			for _, c := range v.(*list.List).FrontAll() {
-				if c.(*Callback).recursive {
-					return false
+// removeSubMonitors removes the monitors of all sub-files of `path` which have no callbacks.
+// The failures are expected as not all the sub-files are monitored, which are only logged.
+func (w *Watcher) removeSubMonitors(path string) {
+	if subPaths, err := fileScanDir(path, "*", true); err == nil && len(subPaths) > 0 {
+		for _, subPath := range subPaths {
+			if w.checkPathCanBeRemoved(subPath) {
+				if internalErr := w.watcher.Remove(subPath); internalErr != nil {
+					intlog.Errorf(context.TODO(), `%+v`, internalErr)
 				}
 			}
-			return false
 		}
-		dirPath = parentDirPath
 	}
-	return true
+}
+
+// checkPathCanBeRemoved checks whether the given path have no callbacks bound,
+// neither to itself nor to its parents.
+func (w *Watcher) checkPathCanBeRemoved(path string) bool {
+	return !w.callbacks.Covers(path)
 }
 
 // RemoveCallback removes callback with given callback id from watcher.
 func (w *Watcher) RemoveCallback(callbackId int) {
-	callback := (*Callback)(nil)
-	if r := callbackIdMap.Get(callbackId); r != nil {
-		callback = r.(*Callback)
+	if r := w.callbackIds.Get(callbackId); r != nil {
+		callback := r.(*Callback)
+		w.callbacks.Remove(callback)
+		w.unregisterCallback(callback)
 	}
-	if callback != nil {
-		if r := w.callbacks.Get(callback.Path); r != nil {
-			r.(*list.List).Remove(callback.elem)
-		}
-		callbackIdMap.Remove(callbackId)
-		if callback.name != "" {
-			w.nameSet.Remove(callback.name)
-		}
+}
+
+// Callbacks returns all the callbacks of the watcher in registering order.
+func (w *Watcher) Callbacks() []*Callback {
+	callbacks := w.callbacks.All()
+	sort.Slice(callbacks, func(i, j int) bool {
+		return callbacks[i].Id < callbacks[j].Id
+	})
+	return callbacks
+}
+
+// unregisterCallback removes the id and name of `callback` from watcher, and releases it.
+// Note that the callback should be removed from the path mapping before calling this.
+func (w *Watcher) unregisterCallback(callback *Callback) {
+	w.callbackIds.Remove(callback.Id)
+	if callback.name != "" {
+		w.nameSet.Remove(callback.name)
+	}
+	callback.release()
+}
+
+// release stops the pending deliveries of the callback, closes its subscription and single
+// file watch if any, and stops its context cleanup if any.
+func (c *Callback) release() {
+	if c.debouncer != nil {
+		c.debouncer.Close()
+	}
+	if c.serial != nil {
+		c.serial.Close()
+	}
+	if c.subscription != nil {
+		c.subscription.Close()
+	}
+	if c.file != nil {
+		c.file.Close()
+	}
+	c.mu.Lock()
+	c.released = true
+	stopContext := c.stopContext
+	c.mu.Unlock()
+	if stopContext != nil {
+		stopContext()
 	}
 }
@@ -0,0 +1,405 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+	"github.com/gocarp/go/container/set"
+	"github.com/gocarp/helpers/intlog"
+)
+
+// BackendType is the type of the underlying monitor of Watcher.
+type BackendType int
+
+const (
+	// BackendAuto uses the notification of the system, and falls back to polling if the
+	// notification is unavailable, or the path cannot be monitored by the notification.
+	BackendAuto BackendType = iota
+
+	// BackendNotify uses only the notification of the system, like inotify in linux systems.
+	BackendNotify
+
+	// BackendPoll uses only the polling of file stats, which works on the file systems that
+	// do not support notification, like some network and FUSE file systems.
+	BackendPoll
+)
+
+// Backend is the underlying monitor of Watcher producing the raw events, which can be
+// implemented for custom monitoring and injected by NewWithBackend, like FakeBackend for tests.
+// The Backend can also implement WatchCounter for the watch budget of Watcher.
+type Backend interface {
+	// Add starts monitoring `path`, which is a file, or a directory of which the direct
+	// children are also monitored.
+	Add(path string) error
+
+	// Remove stops monitoring `path`.
+	Remove(path string) error
+
+	// Close stops monitoring all paths and closes the channels.
+	Close() error
+
+	// Events returns the channel of the raw events.
+	Events() <-chan fsnotify.Event
+
+	// Errors returns the channel of the errors.
+	Errors() <-chan error
+
+	// RenamedFrom returns the old path if `ev` is the CREATE event of moving that the backend
+	// pairs with the RENAME event of the old path, or else it returns empty string.
+	// It is called with the events in the order of receiving, which might update the state
+	// of the backend for pairing.
+	RenamedFrom(ev fsnotify.Event) string
+}
+
+// injectingBackend is the Backend injecting the events for tests, like FakeBackend.
+// Its events are exact, which are not filtered as repeated by the watcher.
+type injectingBackend interface {
+	// flushRequests returns the channel of the flushing requests, each of which is closed
+	// after the events received before the request are flushed.
+	flushRequests() <-chan chan struct{}
+}
+
+// notifyBackend is the backend using the notification of the system.
+type notifyBackend struct {
+	watcher *fsnotify.Watcher            // Underlying fsnotify object.
+	mu      sync.Mutex                   // mu ensures the concurrent safety of watches, inodes and renamed.
+	watches map[string]struct{}          // watches is the monitored paths, which is refreshed for exact counting.
+	inodes  map[string]map[string]uint64 // inodes is the monitored path to the inodes of itself and its direct children mapping.
+	renamed notifyRename                 // renamed is the last RENAME event waiting for pairing with the following CREATE event.
+}
+
+// notifyRename is the path and inode of the RENAME event for pairing moving.
+type notifyRename struct {
+	path  string // Path of the RENAME event.
+	inode uint64 // Inode of the path before renaming.
+}
+
+// autoBackend is the backend using the notification of the system, which falls back to
+// polling for the paths that cannot be monitored by the notification.
+type autoBackend struct {
+	notify    *notifyBackend      // notify is the notification backend.
+	poll      *pollBackend        // poll is the fallback polling backend.
+	polled    *set.StrSet         // polled is the paths monitored by polling.
+	events    chan fsnotify.Event // events merges the events of both backends.
+	errors    chan error          // errors merges the errors of both backends.
+	closeChan chan struct{}       // closeChan is used for closing notification.
+	closeOnce sync.Once           // closeOnce ensures the backend is closed only once.
+}
+
+// newBackend creates and returns the backend of `backendType`.
+func newBackend(backendType BackendType, pollInterval time.Duration) (Backend, error) {
+	switch backendType {
+	case BackendNotify:
+		return newNotifyBackend()
+
+	case BackendPoll:
+		return newPollBackend(pollInterval), nil
+
+	case BackendAuto:
+		notify, err := newNotifyBackend()
+		if err != nil {
+			intlog.Printf(context.TODO(), "notification is unavailable, watcher falls back to polling: %v", err)
+			return newPollBackend(pollInterval), nil
+		}
+		return newAutoBackend(notify, newPollBackend(pollInterval)), nil
+
+	default:
+		return nil, errors.NewCodef(codes.CodeInvalidParameter, `invalid backend type "%d"`, backendType)
+	}
+}
+
+func newNotifyBackend() (*notifyBackend, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, `create notification watcher failed`)
+	}
+	return &notifyBackend{
+		watcher: watcher,
+		watches: make(map[string]struct{}),
+		inodes:  make(map[string]map[string]uint64),
+	}, nil
+}
+
+// Add starts monitoring `path`.
+func (b *notifyBackend) Add(path string) error {
+	inodes := readInodes(path)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.watcher.Add(path); err != nil {
+		return err
+	}
+	b.watches[path] = struct{}{}
+	if inodes != nil {
+		b.inodes[path] = inodes
+	}
+	return nil
+}
+
+// Remove stops monitoring `path`.
+func (b *notifyBackend) Remove(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.watches, path)
+	delete(b.inodes, path)
+	return b.watcher.Remove(path)
+}
+
+// WatchCount returns the number of the monitored paths, which implements WatchCounter.
+//
+// The system removes the watches of the deleted paths by itself, which are still counted
+// until refreshing, so it refreshes the paths from the system if `exact` is true.
+func (b *notifyBackend) WatchCount(exact bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if exact {
+		watches := make(map[string]struct{}, len(b.watches))
+		for _, path := range b.watcher.WatchList() {
+			watches[path] = struct{}{}
+		}
+		b.watches = watches
+	}
+	return len(b.watches)
+}
+
+// IsWatching checks whether `path` is monitored, which implements WatchCounter.
+func (b *notifyBackend) IsWatching(path string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.watches[path]
+	return ok
+}
+
+// Close stops monitoring all paths and closes the channels.
+func (b *notifyBackend) Close() error {
+	return b.watcher.Close()
+}
+
+// Events returns the channel of the raw events.
+func (b *notifyBackend) Events() <-chan fsnotify.Event {
+	return b.watcher.Events
+}
+
+// Errors returns the channel of the errors.
+func (b *notifyBackend) Errors() <-chan error {
+	return b.watcher.Errors
+}
+
+// RenamedFrom returns the old path if `ev` is the CREATE event of moving, or else it returns
+// empty string.
+//
+// The notification produces the RENAME event of the old path followed by the CREATE event of
+// the new path for moving, which are paired if the new path has the inode of the old path.
+// The inodes of the monitored paths and their direct children are recorded for pairing,
+// so the moving is not paired in the systems without inode, like windows.
+func (b *notifyBackend) RenamedFrom(ev fsnotify.Event) string {
+	var inode uint64
+	if ev.Op&fsnotify.Create != 0 {
+		if info, err := os.Lstat(ev.Name); err == nil {
+			inode = fileInode(info)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// The RENAME event is paired only with the CREATE event following it.
+	renamed := b.renamed
+	b.renamed = notifyRename{}
+	switch {
+	case ev.Op&fsnotify.Create != 0:
+		b.setInode(ev.Name, inode)
+		if inode != 0 && inode == renamed.inode {
+			return renamed.path
+		}
+
+	case ev.Op&fsnotify.Rename != 0:
+		if inode = b.getInode(ev.Name); inode != 0 {
+			b.renamed = notifyRename{path: ev.Name, inode: inode}
+		}
+		b.setInode(ev.Name, 0)
+
+	case ev.Op&fsnotify.Remove != 0:
+		b.setInode(ev.Name, 0)
+	}
+	return ""
+}
+
+// getInode returns the recorded inode of `path`, which is 0 if not recorded.
+// Note that it should be called with the lock held.
+func (b *notifyBackend) getInode(path string) uint64 {
+	if inodes, ok := b.inodes[filepath.Dir(path)]; ok {
+		if inode, ok := inodes[path]; ok {
+			return inode
+		}
+	}
+	return b.inodes[path][path]
+}
+
+// setInode records the `inode` of `path` if `path` or its parent is monitored, which removes
+// the record if `inode` is 0.
+// Note that it should be called with the lock held.
+func (b *notifyBackend) setInode(path string, inode uint64) {
+	for _, monitored := range []string{filepath.Dir(path), path} {
+		inodes, ok := b.inodes[monitored]
+		if !ok {
+			continue
+		}
+		if inode == 0 {
+			delete(inodes, path)
+		} else {
+			inodes[path] = inode
+		}
+	}
+}
+
+// readInodes returns the inodes of `path` and its direct children if it's a directory,
+// which is nil if the inode is not available.
+func readInodes(path string) map[string]uint64 {
+	info, err := os.Lstat(path)
+	if err != nil || fileInode(info) == 0 {
+		return nil
+	}
+	inodes := map[string]uint64{path: fileInode(info)}
+	if !info.IsDir() {
+		return inodes
+	}
+	entries, _ := os.ReadDir(path)
+	for _, entry := range entries {
+		if info, err = entry.Info(); err == nil {
+			inodes[filepath.Join(path, entry.Name())] = fileInode(info)
+		}
+	}
+	return inodes
+}
+
+func newAutoBackend(notify *notifyBackend, poll *pollBackend) *autoBackend {
+	b := &autoBackend{
+		notify:    notify,
+		poll:      poll,
+		polled:    set.NewStrSet(true),
+		events:    make(chan fsnotify.Event),
+		errors:    make(chan error),
+		closeChan: make(chan struct{}),
+	}
+	go b.forward(notify)
+	go b.forward(poll)
+	return b
+}
+
+// Add starts monitoring `path` using the notification, or using polling if the notification
+// fails for `path`, like the limit of watches is exhausted.
+func (b *autoBackend) Add(path string) error {
+	err := b.notify.Add(path)
+	if err == nil {
+		return nil
+	}
+	if !fileExists(path) {
+		return err
+	}
+	intlog.Printf(context.TODO(), "notification failed for path %s, watcher falls back to polling: %v", path, err)
+	return b.addPoll(path)
+}
+
+// Remove stops monitoring `path`.
+func (b *autoBackend) Remove(path string) error {
+	if b.polled.Contains(path) {
+		b.polled.Remove(path)
+		return b.poll.Remove(path)
+	}
+	return b.notify.Remove(path)
+}
+
+// Close stops monitoring all paths and closes both backends.
+func (b *autoBackend) Close() (err error) {
+	b.closeOnce.Do(func() {
+		close(b.closeChan)
+		err = b.notify.Close()
+		if pollErr := b.poll.Close(); err == nil {
+			err = pollErr
+		}
+	})
+	return
+}
+
+// Events returns the channel of the raw events.
+func (b *autoBackend) Events() <-chan fsnotify.Event {
+	return b.events
+}
+
+// Errors returns the channel of the errors.
+func (b *autoBackend) Errors() <-chan error {
+	return b.errors
+}
+
+// RenamedFrom returns the old path if `ev` is the CREATE event of moving, or else it returns
+// empty string.
+func (b *autoBackend) RenamedFrom(ev fsnotify.Event) string {
+	if b.polled.Contains(ev.Name) || b.polled.Contains(filepath.Dir(ev.Name)) {
+		return b.poll.RenamedFrom(ev)
+	}
+	return b.notify.RenamedFrom(ev)
+}
+
+// WatchCount returns the number of the paths monitored by the notification, which implements WatchCounter.
+func (b *autoBackend) WatchCount(exact bool) int {
+	return b.notify.WatchCount(exact)
+}
+
+// IsWatching checks whether `path` is monitored by the notification, which implements WatchCounter.
+func (b *autoBackend) IsWatching(path string) bool {
+	return b.notify.IsWatching(path)
+}
+
+// addPoll starts monitoring `path` using polling instead of the notification.
+func (b *autoBackend) addPoll(path string) error {
+	if err := b.poll.Add(path); err != nil {
+		return err
+	}
+	b.polled.Add(path)
+	return nil
+}
+
+// forward forwards the events and errors of `from` until the backend is closed.
+func (b *autoBackend) forward(from Backend) {
+	var (
+		events = from.Events()
+		errs   = from.Errors()
+	)
+	for events != nil || errs != nil {
+		select {
+		case <-b.closeChan:
+			return
+
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			select {
+			case b.events <- ev:
+			case <-b.closeChan:
+				return
+			}
+
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			select {
+			case b.errors <- err:
+			case <-b.closeChan:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+)
+
+// BudgetPolicy is the policy of Watcher when the watch budget is exhausted.
+type BudgetPolicy int
+
+const (
+	// BudgetFail fails the adding that needs more watches than the remaining budget,
+	// without monitoring any path of the adding.
+	BudgetFail BudgetPolicy = iota
+
+	// BudgetPoll monitors the paths beyond the budget by polling, which is only supported
+	// by BackendAuto, and the other backends fail as BudgetFail.
+	BudgetPoll
+)
+
+// WatchCounter is the optional interface of Backend counting the watches of the system
+// notification. The WatcherConfig.WatchBudget and Preflight take effect only if the Backend
+// implements it, and the Backend not implementing it is not limited by the budget.
+type WatchCounter interface {
+	// WatchCount returns the number of the paths monitored by the system notification,
+	// which is refreshed from the system if `exact` is true.
+	WatchCount(exact bool) int
+
+	// IsWatching checks whether `path` is monitored by the system notification.
+	IsWatching(path string) bool
+}
+
+// pollFallback is the Backend that can monitor paths by polling instead of the system notification.
+type pollFallback interface {
+	// addPoll starts monitoring `path` using polling.
+	addPoll(path string) error
+}
+
+// WatchCount returns the number of the watches of the system notification that the watcher
+// holds, which are limited by the system, like fs.inotify.max_user_watches in linux systems.
+// It returns 0 if the backend does not use the system notification, like BackendPoll.
+func (w *Watcher) WatchCount() int {
+	if counter, ok := w.watcher.(WatchCounter); ok {
+		return counter.WatchCount(true)
+	}
+	return 0
+}
+
+// Preflight returns the number of the new watches that adding `path` with `options` needs,
+// without monitoring any path. It scans the sub-folders of `path` if it's recursive.
+//
+// It returns error if the new watches exceed the remaining budget of WatcherConfig.WatchBudget
+// and the BudgetPolicy is BudgetFail, in which case adding `path` fails.
+func (w *Watcher) Preflight(path string, options WatchOptions) (needed int, err error) {
+	paths, err := w.watchPaths(path, options)
+	if err != nil {
+		return 0, err
+	}
+	counter, ok := w.watcher.(WatchCounter)
+	if !ok {
+		return 0, nil
+	}
+	for _, p := range paths {
+		if !counter.IsWatching(p) {
+			needed++
+		}
+	}
+	if w.config.WatchBudget > 0 && w.config.BudgetPolicy == BudgetFail {
+		if available := w.availableWatches(counter, needed); needed > available {
+			return needed, errors.NewCodef(
+				codes.CodeInvalidOperation,
+				`path "%s" needs %d watches, exceeding the available %d of the watch budget %d`,
+				path, needed, available, w.config.WatchBudget,
+			)
+		}
+	}
+	return needed, nil
+}
+
+// watchPaths returns the paths that adding `path` with `options` monitors, which are `path`
+// itself and its sub-folders if it's recursive.
+func (w *Watcher) watchPaths(path string, options WatchOptions) ([]string, error) {
+	realPath := fileRealPath(path)
+	if realPath == "" {
+		return nil, errors.NewCodef(codes.CodeInvalidParameter, `"%s" does not exist`, path)
+	}
+	if options.NoRecursive || !fileIsDir(realPath) {
+		return []string{realPath}, nil
+	}
+	filter, err := newPathFilter(realPath, options.Include, options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	return fileAllDirs(realPath, filter.ExcludesDir), nil
+}
+
+// addWatch adds `path` to the underlying monitor within the watch budget.
+// The `path` is monitored by polling if the budget is exhausted and the BudgetPolicy is
+// BudgetPoll, or else it returns error.
+func (w *Watcher) addWatch(path string) error {
+	if w.config.WatchBudget > 0 {
+		// The checking and adding are in serial, so that the budget is not exceeded concurrently.
+		w.budgetMu.Lock()
+		defer w.budgetMu.Unlock()
+	}
+	return w.addWatchLocked(path)
+}
+
+// addWatchLocked is the same as addWatch, but it should be called with budgetMu held if the
+// watch budget is set.
+func (w *Watcher) addWatchLocked(path string) (err error) {
+	defer func() {
+		if err == nil {
+			w.recordDirs(path)
+		}
+	}()
+	if w.config.WatchBudget <= 0 {
+		return w.watcher.Add(path)
+	}
+	counter, ok := w.watcher.(WatchCounter)
+	if !ok {
+		return w.watcher.Add(path)
+	}
+	if counter.IsWatching(path) || w.availableWatches(counter, 1) > 0 {
+		return w.watcher.Add(path)
+	}
+	if fallback, ok := w.watcher.(pollFallback); ok && w.config.BudgetPolicy == BudgetPoll {
+		return fallback.addPoll(path)
+	}
+	return errors.NewCodef(
+		codes.CodeInvalidOperation, `watch budget %d is exhausted for path "%s"`, w.config.WatchBudget, path,
+	)
+}
+
+// availableWatches returns the number of the watches available in the budget.
+// It refreshes the count from the system if the rough count has less than `needed` available,
+// as the system removes the watches of the deleted paths by itself.
+func (w *Watcher) availableWatches(counter WatchCounter, needed int) int {
+	available := w.config.WatchBudget - counter.WatchCount(false)
+	if available < needed {
+		available = w.config.WatchBudget - counter.WatchCount(true)
+	}
+	return available
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+)
+
+func TestWatcher_Preflight(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t, WatcherConfig{WatchBudget: 4})
+		dir        = newTestDir(t, "s/a", "t/b", "t/u/c")
+	)
+	// The path and its sub-folders s, t and t/u need 4 watches, which are within the budget.
+	needed, err := w.Preflight(dir, WatchOptions{})
+	if err != nil || needed != 4 {
+		t.Fatalf(`got %d, %v, want 4 watches needed`, needed, err)
+	}
+	if needed, err = w.Preflight(dir, WatchOptions{NoRecursive: true}); err != nil || needed != 1 {
+		t.Fatalf(`got %d, %v, want 1 watch needed without recursion`, needed, err)
+	}
+	if needed, err = w.Preflight(dir, WatchOptions{Exclude: []string{"t/**"}}); err != nil || needed != 2 {
+		t.Fatalf(`got %d, %v, want 2 watches needed excluding t`, needed, err)
+	}
+	if len(backend.Watched()) != 0 {
+		t.Fatalf(`got watched paths %v by preflight, want none`, backend.Watched())
+	}
+	// The monitored paths need no more watch.
+	if _, err = w.Add(filepath.Join(dir, "t"), func(event *Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	if needed, err = w.Preflight(dir, WatchOptions{}); err != nil || needed != 2 {
+		t.Fatalf(`got %d, %v, want 2 watches needed`, needed, err)
+	}
+	if count := w.WatchCount(); count != 2 {
+		t.Fatalf(`got watch count %d, want 2`, count)
+	}
+	if _, err = w.Preflight(filepath.Join(dir, "none"), WatchOptions{}); err == nil {
+		t.Fatal(`preflight of path not existing returns no error`)
+	}
+}
+
+func TestWatcher_BudgetFail(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t, WatcherConfig{WatchBudget: 3})
+		dir        = newTestDir(t, "s/a", "t/b", "t/u/c")
+	)
+	needed, err := w.Preflight(dir, WatchOptions{})
+	if needed != 4 || !errors.HasCode(err, codes.CodeInvalidOperation) {
+		t.Fatalf(`got %d, %v, want 4 watches needed exceeding the budget`, needed, err)
+	}
+	if _, err = w.Add(dir, func(event *Event) {}); !errors.HasCode(err, codes.CodeInvalidOperation) {
+		t.Fatalf(`got error %v, want budget exceeded`, err)
+	}
+	if len(backend.Watched()) != 0 || len(w.Callbacks()) != 0 {
+		t.Fatalf(`got watched paths %v after failed adding, want none`, backend.Watched())
+	}
+	if _, err = w.Add(filepath.Join(dir, "t"), func(event *Event) {}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher_BudgetRollback(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t, WatcherConfig{WatchBudget: 10})
+		dir        = newTestDir(t, "s/a", "t/b", "t/u/c")
+		other      = newTestDir(t)
+	)
+	if _, err := w.Add(other, func(event *Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	// The adding fails in the middle, which removes the monitors already added for it.
+	backend.SetAddError(filepath.Join(dir, "t"), errors.New("limit"))
+	if _, err := w.AddOnce("tree", dir, func(event *Event) {}); err == nil {
+		t.Fatal(`adding with failed sub-folder returns no error`)
+	}
+	if got := backend.Watched(); len(got) != 1 || got[0] != other {
+		t.Fatalf(`got watched paths %v after rollback, want [%s]`, got, other)
+	}
+	if len(w.Callbacks()) != 1 {
+		t.Fatalf(`got %d callbacks after rollback, want 1`, len(w.Callbacks()))
+	}
+	// The name is not registered by the failed adding.
+	backend.SetAddError(filepath.Join(dir, "t"), nil)
+	if _, err := w.AddOnce("tree", dir, func(event *Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	if count := len(backend.Watched()); count != 5 {
+		t.Fatalf(`got %d watched paths, want 5`, count)
+	}
+}
+
+func TestWatcher_BudgetPoll(t *testing.T) {
+	w, err := NewWithConfig(WatcherConfig{WatchBudget: 2, BudgetPolicy: BudgetPoll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	backend, ok := w.watcher.(*autoBackend)
+	if !ok {
+		t.Skip(`notification is unavailable`)
+	}
+	dir := newTestDir(t, "s/a", "t/b", "t/u/c")
+	// The paths beyond the budget are monitored by polling.
+	if _, err = w.Add(dir, func(event *Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	if count := w.WatchCount(); count != 2 {
+		t.Fatalf(`got watch count %d, want 2`, count)
+	}
+	if size := backend.polled.Size(); size != 2 {
+		t.Fatalf(`got %d polled paths, want 2`, size)
+	}
+}
+
+func TestWatcher_BudgetPollUnsupported(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t, WatcherConfig{WatchBudget: 2, BudgetPolicy: BudgetPoll})
+		dir        = newTestDir(t, "s/a", "t/b")
+	)
+	// The backend without polling fails the adding as BudgetFail, which happens after
+	// preflight as the polling policy does not fail preflight.
+	if _, err := w.Add(dir, func(event *Event) {}); !errors.HasCode(err, codes.CodeInvalidOperation) {
+		t.Fatalf(`got error %v, want budget exhausted`, err)
+	}
+	if len(backend.Watched()) != 0 || len(w.Callbacks()) != 0 {
+		t.Fatalf(`got watched paths %v after failed adding, want none`, backend.Watched())
+	}
+}
+
+func TestWatcher_BudgetConcurrent(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		var (
+			w, backend = newTestWatcher(t, WatcherConfig{WatchBudget: 4})
+			start      = make(chan struct{})
+			errs       = make(chan error, 8)
+		)
+		// Each of the trees needs 3 watches, of which only one fits in the budget, and the
+		// others fail at checking without monitoring any path.
+		for j := 0; j < 8; j++ {
+			dir := newTestDir(t, "s/a", "t/b")
+			go func() {
+				<-start
+				_, err := w.Add(dir, func(event *Event) {})
+				errs <- err
+			}()
+		}
+		close(start)
+		var failed int
+		for j := 0; j < 8; j++ {
+			if err := <-errs; err != nil {
+				if !errors.HasCode(err, codes.CodeInvalidOperation) || !strings.Contains(err.Error(), "needs 3 watches") {
+					t.Fatalf(`got error %v, want budget exceeded at checking`, err)
+				}
+				failed++
+			}
+		}
+		if failed != 7 || len(backend.Watched()) != 3 {
+			t.Fatalf(`got %d failed and watched paths %v, want one tree added`, failed, backend.Watched())
+		}
+	}
+}
+
+func TestWatcher_AddFailureWithoutBudget(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t, "s/a", "t/b", "u/c")
+	)
+	// The adding without budget keeps the callback and the other sub-folders.
+	backend.SetAddError(filepath.Join(dir, "t"), errors.New("limit"))
+	callback, err := w.Add(dir, func(event *Event) {})
+	if err != nil || callback == nil {
+		t.Fatalf(`got callback %v, error %v, want callback without error of the middle sub-folder`, callback, err)
+	}
+	if got := backend.Watched(); len(got) != 3 || backend.IsWatching(filepath.Join(dir, "t")) {
+		t.Fatalf(`got watched paths %v, want all but t`, got)
+	}
+	// The error of the last sub-folder is returned.
+	backend.SetAddError(filepath.Join(dir, "u"), errors.New("limit"))
+	if callback, err = w.Add(dir, func(event *Event) {}); err == nil || callback == nil {
+		t.Fatalf(`got callback %v, error %v, want callback with error of the last sub-folder`, callback, err)
+	}
+	if len(w.Callbacks()) != 2 {
+		t.Fatalf(`got %d callbacks, want 2`, len(w.Callbacks()))
+	}
+}
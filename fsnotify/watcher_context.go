@@ -0,0 +1,67 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"context"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+)
+
+// AddContext monitors `path` with callback function `callbackFunc` and custom options `options`
+// to the watcher, which is removed automatically when `ctx` is done.
+//
+// After `ctx` is done, it removes the callback, and also removes the monitors of `path` and
+// its sub-files that are not used by other callbacks, so that the request-scoped or test-scoped
+// monitoring does not leak. It returns error if `ctx` is already done.
+func (w *Watcher) AddContext(
+	ctx context.Context, path string, callbackFunc func(event *Event), options WatchOptions,
+) (callback *Callback, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, errors.WrapCode(codes.CodeInvalidOperation, err, `context is already done`)
+	}
+	if callback, err = w.addOnceWithOptions("", path, callbackFunc, options, nil); err != nil {
+		return nil, err
+	}
+	callback.setStopContext(context.AfterFunc(ctx, func() {
+		w.removeCallbackAndMonitors(callback)
+	}))
+	return callback, nil
+}
+
+// setStopContext sets the function stopping the context cleanup of the callback, which is
+// called at once if the callback is already released, like it's removed before the setting.
+func (c *Callback) setStopContext(stopContext func() bool) {
+	c.mu.Lock()
+	if !c.released {
+		c.stopContext = stopContext
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	stopContext()
+}
+
+// removeCallbackAndMonitors removes `callback` from watcher, and then removes the monitors of
+// its bound path and sub-files that have no callbacks.
+func (w *Watcher) removeCallbackAndMonitors(callback *Callback) {
+	w.RemoveCallback(callback.Id)
+	// The monitors of the entries created under the non-recursive path are not scanned.
+	if callback.created != nil {
+		for _, path := range callback.created.Slice() {
+			if w.checkPathCanBeRemoved(path) {
+				_ = w.watcher.Remove(path)
+			}
+		}
+	}
+	if !w.checkPathCanBeRemoved(callback.Path) {
+		return
+	}
+	if callback.recursive {
+		w.removeSubMonitors(callback.Path)
+	}
+	_ = w.watcher.Remove(callback.Path)
+}
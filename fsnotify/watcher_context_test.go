@@ -0,0 +1,166 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitCallbacks waits until the number of callbacks of `w` is `n`, and fails the test if not in time.
+func waitCallbacks(t *testing.T, w *Watcher, n int) {
+	t.Helper()
+	deadline := time.Now().Add(testEventTimeout)
+	for len(w.Callbacks()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf(`got %d callbacks, want %d`, len(w.Callbacks()), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitWatched waits until whether `path` is monitored by the backend of `w` is `watched`, and
+// fails the test if not in time.
+func waitWatched(t *testing.T, w *Watcher, path string, watched bool) {
+	t.Helper()
+	deadline := time.Now().Add(testEventTimeout)
+	for w.watcher.(WatchCounter).IsWatching(path) != watched {
+		if time.Now().After(deadline) {
+			t.Fatalf(`got path %s monitored %v, want %v`, path, !watched, watched)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatcher_AddContext(t *testing.T) {
+	var (
+		w           = newTestNotifyWatcher(t)
+		dir         = newTestDir(t, "sub/a", "other/b")
+		sub         = filepath.Join(dir, "sub")
+		other       = filepath.Join(dir, "other")
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+	if _, err := w.Add(other, func(event *Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.AddContext(ctx, dir, func(event *Event) {}, WatchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitWatched(t, w, dir, true)
+	waitWatched(t, w, sub, true)
+	cancel()
+	waitCallbacks(t, w, 1)
+	// The monitors are removed except the ones used by other callbacks.
+	waitWatched(t, w, dir, false)
+	waitWatched(t, w, sub, false)
+	waitWatched(t, w, other, true)
+	if _, err := w.AddContext(ctx, dir, func(event *Event) {}, WatchOptions{}); err == nil {
+		t.Fatal(`expected error for done context`)
+	}
+}
+
+func TestWatcher_AddContextNoRecursive(t *testing.T) {
+	var (
+		w           = newTestNotifyWatcher(t)
+		dir         = newTestDir(t)
+		a           = filepath.Join(dir, "a")
+		b           = filepath.Join(dir, "b")
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+	callback, err := w.AddContext(ctx, dir, func(event *Event) {}, WatchOptions{NoRecursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The files created under the non-recursive path are monitored for the callback.
+	for _, path := range []string{a, b} {
+		if err = os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		waitWatched(t, w, path, true)
+	}
+	// The removed file is not recorded any longer.
+	if err = os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(testEventTimeout)
+	for callback.created.Contains(b) {
+		if time.Now().After(deadline) {
+			t.Fatalf(`removed file %s is still recorded`, b)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The monitors of the created files are removed with the callback.
+	cancel()
+	waitCallbacks(t, w, 0)
+	waitWatched(t, w, dir, false)
+	waitWatched(t, w, a, false)
+}
+
+func TestWatcher_AddContextRemoved(t *testing.T) {
+	var (
+		w           = newTestNotifyWatcher(t)
+		dir         = newTestDir(t)
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+	callback, err := w.AddContext(ctx, dir, func(event *Event) {}, WatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The context cleanup is stopped if the callback is removed first.
+	w.RemoveCallback(callback.Id)
+	if callback.stopContext() {
+		t.Fatal(`context cleanup is not stopped after removing callback`)
+	}
+	// The callback removed before setting the context cleanup stops it at once.
+	var (
+		stopped bool
+		removed = &Callback{}
+	)
+	removed.release()
+	removed.setStopContext(func() bool {
+		stopped = true
+		return true
+	})
+	if !stopped || removed.stopContext != nil {
+		t.Fatal(`context cleanup is not stopped for released callback`)
+	}
+}
+
+func TestWatcher_AddContextConcurrent(t *testing.T) {
+	var (
+		w           = newTestNotifyWatcher(t)
+		dir         = newTestDir(t)
+		done        = make(chan struct{})
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+	// The callbacks are removed concurrently with adding.
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			for _, callback := range w.Callbacks() {
+				w.RemoveCallback(callback.Id)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := w.AddContext(ctx, dir, func(event *Event) {}, WatchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	for _, callback := range w.Callbacks() {
+		w.RemoveCallback(callback.Id)
+	}
+	// The cancelling does nothing for the removed callbacks.
+	cancel()
+	waitCallbacks(t, w, 0)
+}
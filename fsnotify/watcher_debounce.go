@@ -0,0 +1,109 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// debouncer merges the events of the same path in a time window into one delivery.
+type debouncer struct {
+	mu      sync.Mutex                  // mu ensures the concurrent safety of pending.
+	window  time.Duration               // window is the debounce window.
+	leading bool                        // leading delivers the first event of the window immediately.
+	pending map[string]*debouncePending // pending is the path to its pending delivery mapping.
+	deliver func(event *Event)          // deliver delivers the merged event.
+	closed  bool                        // closed marks the debouncer closed, which drops all pending events.
+}
+
+// debouncePending is the pending delivery of a path in the debounce window.
+type debouncePending struct {
+	event *Event      // Merged event, which is nil if no event needs delivering at the end of window.
+	timer *time.Timer // Timer for the end of window.
+}
+
+func newDebouncer(window time.Duration, leading bool, deliver func(event *Event)) *debouncer {
+	return &debouncer{
+		window:  window,
+		leading: leading,
+		pending: make(map[string]*debouncePending),
+		deliver: deliver,
+	}
+}
+
+// Push adds `event` to the debounce window of its path.
+func (d *debouncer) Push(event *Event) {
+	if d.push(event) {
+		d.deliver(event)
+	}
+}
+
+// push adds `event` to the debounce window of its path, and returns whether `event` should
+// be delivered immediately, which is the leading edge of the window.
+func (d *debouncer) push(event *Event) (deliverNow bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	if p, ok := d.pending[event.Path]; ok {
+		if p.event == nil {
+			p.event = d.copyEvent(event)
+		} else {
+			p.event.Op |= event.Op
+			p.event.event.Op |= fsnotify.Op(event.Op)
+		}
+		// The window restarts on each new event.
+		p.timer.Reset(d.window)
+		return false
+	}
+	p := &debouncePending{}
+	if d.leading {
+		deliverNow = true
+	} else {
+		p.event = d.copyEvent(event)
+	}
+	path := event.Path
+	p.timer = time.AfterFunc(d.window, func() {
+		d.flush(path, p)
+	})
+	d.pending[path] = p
+	return
+}
+
+// Close stops all the pending deliveries.
+func (d *debouncer) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	for path, p := range d.pending {
+		p.timer.Stop()
+		delete(d.pending, path)
+	}
+}
+
+// flush delivers the merged event of pending `p` of `path` at the end of its window.
+// It does nothing if `p` is already flushed.
+func (d *debouncer) flush(path string, p *debouncePending) {
+	d.mu.Lock()
+	ok := d.pending[path] == p
+	if ok {
+		delete(d.pending, path)
+	}
+	d.mu.Unlock()
+	if ok && p.event != nil {
+		d.deliver(p.event)
+	}
+}
+
+// copyEvent returns a copy of `event` for merging, as the original event is shared by
+// multiple callbacks.
+func (d *debouncer) copyEvent(event *Event) *Event {
+	e := *event
+	return &e
+}
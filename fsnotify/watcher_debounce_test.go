@@ -0,0 +1,147 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	testDebounceWindow = 30 * time.Millisecond // Debounce window for tests.
+)
+
+// newTestDebouncer creates a debouncer delivering to the returned channel.
+func newTestDebouncer(leading bool) (*debouncer, chan *Event) {
+	delivered := make(chan *Event, 10)
+	d := newDebouncer(testDebounceWindow, leading, func(event *Event) {
+		delivered <- event
+	})
+	return d, delivered
+}
+
+// receiveDelivered receives the next delivered event, and fails the test if nothing is
+// delivered in 10 windows.
+func receiveDelivered(t *testing.T, delivered <-chan *Event) *Event {
+	t.Helper()
+	select {
+	case event := <-delivered:
+		return event
+	case <-time.After(10 * testDebounceWindow):
+		t.Fatal(`timeout waiting for delivery`)
+	}
+	return nil
+}
+
+// expectNoDelivered fails the test if anything is delivered in 3 windows.
+func expectNoDelivered(t *testing.T, delivered <-chan *Event) {
+	t.Helper()
+	select {
+	case event := <-delivered:
+		t.Fatalf(`unexpected delivery %s %v`, event.Path, event.Op)
+	case <-time.After(3 * testDebounceWindow):
+	}
+}
+
+func TestDebouncer_Trailing(t *testing.T) {
+	var (
+		d, delivered = newTestDebouncer(false)
+		write        = &Event{Path: "a", Op: WRITE}
+	)
+	d.Push(write)
+	d.Push(&Event{Path: "a", Op: CHMOD})
+	d.Push(&Event{Path: "b", Op: WRITE})
+	// The events of the same path are merged into one delivery at the end of the window.
+	got := map[string]Op{}
+	for i := 0; i < 2; i++ {
+		event := receiveDelivered(t, delivered)
+		got[event.Path] = event.Op
+	}
+	if got["a"] != WRITE|CHMOD || got["b"] != WRITE {
+		t.Fatalf(`got %v, want a WRITE|CHMOD and b WRITE`, got)
+	}
+	// The pushed event is shared by callbacks, which is not changed by merging.
+	if write.Op != WRITE {
+		t.Fatalf(`got pushed event changed to %v`, write.Op)
+	}
+	expectNoDelivered(t, delivered)
+}
+
+func TestDebouncer_Restart(t *testing.T) {
+	d, delivered := newTestDebouncer(false)
+	// The window restarts on each event of the path.
+	var last time.Time
+	for i := 0; i < 4; i++ {
+		last = time.Now()
+		d.Push(&Event{Path: "a", Op: WRITE})
+		time.Sleep(testDebounceWindow / 2)
+	}
+	receiveDelivered(t, delivered)
+	if elapsed := time.Since(last); elapsed < testDebounceWindow {
+		t.Fatalf(`got delivery %v after the last event, want after the window %v`, elapsed, testDebounceWindow)
+	}
+	expectNoDelivered(t, delivered)
+}
+
+func TestDebouncer_Leading(t *testing.T) {
+	d, delivered := newTestDebouncer(true)
+	// The leading event is delivered in Push.
+	d.Push(&Event{Path: "a", Op: WRITE})
+	select {
+	case event := <-delivered:
+		if event.Op != WRITE {
+			t.Fatalf(`got leading %v, want WRITE`, event.Op)
+		}
+	default:
+		t.Fatal(`leading event is not delivered immediately`)
+	}
+	d.Push(&Event{Path: "a", Op: CHMOD})
+	d.Push(&Event{Path: "a", Op: WRITE})
+	if event := receiveDelivered(t, delivered); event.Op != WRITE|CHMOD {
+		t.Fatalf(`got trailing %v, want WRITE|CHMOD`, event.Op)
+	}
+	expectNoDelivered(t, delivered)
+}
+
+func TestDebouncer_LeadingOnly(t *testing.T) {
+	d, delivered := newTestDebouncer(true)
+	// There's nothing delivered at the end of the window if no event follows the leading one.
+	d.Push(&Event{Path: "a", Op: WRITE})
+	receiveDelivered(t, delivered)
+	expectNoDelivered(t, delivered)
+	// The next event after the window is leading again.
+	d.Push(&Event{Path: "a", Op: CHMOD})
+	if event := receiveDelivered(t, delivered); event.Op != CHMOD {
+		t.Fatalf(`got %v, want leading CHMOD`, event.Op)
+	}
+}
+
+func TestDebouncer_Close(t *testing.T) {
+	d, delivered := newTestDebouncer(false)
+	d.Push(&Event{Path: "a", Op: WRITE})
+	// The pending delivery and the events after closing are dropped.
+	d.Close()
+	d.Push(&Event{Path: "b", Op: WRITE})
+	expectNoDelivered(t, delivered)
+}
+
+func TestWatcher_DebounceRemoveCallback(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	called := make(chan *Event, 1)
+	callback, err := w.AddWithOptions(t.TempDir(), func(event *Event) {
+		called <- event
+	}, WatchOptions{Debounce: testDebounceWindow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback.debouncer.Push(&Event{Path: "a", Op: WRITE})
+	// The pending delivery is dropped after the callback is removed.
+	w.RemoveCallback(callback.Id)
+	expectNoDelivered(t, called)
+}
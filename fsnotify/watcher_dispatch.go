@@ -0,0 +1,94 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"sync"
+)
+
+// serialExecutor calls the callback function with the events one by one in order.
+type serialExecutor struct {
+	mu      sync.Mutex         // mu ensures the concurrent safety of the fields below.
+	events  []*Event           // events is the events waiting for calling.
+	running bool               // running marks the draining goroutine running.
+	closed  bool               // closed marks the executor closed, which drops all events.
+	call    func(event *Event) // call calls the callback function with the event.
+	done    func()             // done is called after each event is called or dropped.
+}
+
+func newSerialExecutor(call func(event *Event), done func()) *serialExecutor {
+	return &serialExecutor{
+		call: call,
+		done: done,
+	}
+}
+
+// Push appends `event` for calling after all the events pushed before.
+func (e *serialExecutor) Push(event *Event) {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		e.done()
+		return
+	}
+	e.events = append(e.events, event)
+	if !e.running {
+		e.running = true
+		go e.drain()
+	}
+	e.mu.Unlock()
+}
+
+// Close drops all the events waiting for calling.
+func (e *serialExecutor) Close() {
+	e.mu.Lock()
+	dropped := len(e.events)
+	e.events = nil
+	e.closed = true
+	e.mu.Unlock()
+	for i := 0; i < dropped; i++ {
+		e.done()
+	}
+}
+
+// drain calls the callback function with the waiting events until there's no event.
+func (e *serialExecutor) drain() {
+	for {
+		e.mu.Lock()
+		if len(e.events) == 0 {
+			e.running = false
+			e.mu.Unlock()
+			return
+		}
+		event := e.events[0]
+		e.events[0] = nil
+		e.events = e.events[1:]
+		e.mu.Unlock()
+		e.call(event)
+		e.done()
+	}
+}
+
+// acquireWorker acquires a worker for calling a callback function, which blocks if all the
+// workers are busy, so that the events stay in the queue of watcher.
+// It returns false if the watcher is closed while waiting.
+func (w *Watcher) acquireWorker() bool {
+	if w.workers == nil {
+		return true
+	}
+	select {
+	case w.workers <- struct{}{}:
+		return true
+	case <-w.closeChan:
+		return false
+	}
+}
+
+// releaseWorker releases the worker acquired by acquireWorker.
+func (w *Watcher) releaseWorker() {
+	if w.workers != nil {
+		<-w.workers
+	}
+}
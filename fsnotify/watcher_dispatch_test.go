@@ -0,0 +1,193 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runningCounter counts the callback functions running concurrently.
+type runningCounter struct {
+	mu      sync.Mutex
+	running int
+	maxRun  int
+}
+
+// Run counts the running of `f`.
+func (c *runningCounter) Run(f func()) {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.maxRun {
+		c.maxRun = c.running
+	}
+	c.mu.Unlock()
+	f()
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+}
+
+// MaxRunning returns the maximum number of the functions running concurrently.
+func (c *runningCounter) MaxRunning() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxRun
+}
+
+func TestWatcher_MaxWorkers(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t, WatcherConfig{MaxWorkers: 2})
+		dir        = newTestDir(t)
+		counter    runningCounter
+		wg         sync.WaitGroup
+	)
+	callbackFunc := func(event *Event) {
+		counter.Run(func() { time.Sleep(5 * time.Millisecond) })
+		wg.Done()
+	}
+	// The workers are shared by all the callbacks of watcher.
+	for i := 0; i < 2; i++ {
+		if _, err := w.Add(dir, callbackFunc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Add(20)
+	for i := 0; i < 10; i++ {
+		if err := backend.Emit(filepath.Join(dir, fmt.Sprint(i)), WRITE); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if maxRun := counter.MaxRunning(); maxRun != 2 {
+		t.Fatalf(`got %d callback functions called concurrently, want 2`, maxRun)
+	}
+}
+
+func TestWatcher_MaxWorkersInitialEvents(t *testing.T) {
+	var (
+		w, _    = newTestWatcher(t, WatcherConfig{MaxWorkers: 1})
+		counter runningCounter
+		wg      sync.WaitGroup
+	)
+	callbackFunc := func(event *Event) {
+		counter.Run(func() { time.Sleep(5 * time.Millisecond) })
+		wg.Done()
+	}
+	// The synthetic events of different callbacks share the workers of watcher.
+	wg.Add(6)
+	for i := 0; i < 2; i++ {
+		dir := newTestDir(t, "a", "b", "c")
+		if _, err := w.AddWithOptions(dir, callbackFunc, WatchOptions{InitialEvents: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if maxRun := counter.MaxRunning(); maxRun != 1 {
+		t.Fatalf(`got %d callback functions called concurrently, want 1`, maxRun)
+	}
+}
+
+func TestWatcher_MaxWorkersBackpressure(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t, WatcherConfig{MaxWorkers: 1})
+		dir        = newTestDir(t)
+		blocked    = make(chan struct{})
+		called     = make(chan *Event, 10)
+	)
+	if _, err := w.Add(dir, func(event *Event) {
+		called <- event
+		<-blocked
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		emitEvent(t, backend, filepath.Join(dir, fmt.Sprint(i)), WRITE)
+	}
+	receiveEvent(t, called)
+	// The events wait in the queue of watcher while all the workers are busy, of which one
+	// is taken by the event loop waiting for a worker.
+	deadline := time.Now().Add(testEventTimeout)
+	for w.events.Len() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf(`got %d events in queue, want 3`, w.events.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(blocked)
+	for i := 1; i < 5; i++ {
+		receiveEvent(t, called)
+	}
+}
+
+func TestWatcher_Serial(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t, WatcherConfig{MaxWorkers: 2})
+		dir        = newTestDir(t)
+		counter    runningCounter
+		mu         sync.Mutex
+		paths      []string
+		wg         sync.WaitGroup
+	)
+	_, err := w.AddWithOptions(dir, func(event *Event) {
+		counter.Run(func() { time.Sleep(time.Millisecond) })
+		mu.Lock()
+		paths = append(paths, event.Path)
+		mu.Unlock()
+		wg.Done()
+	}, WatchOptions{Serial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(20)
+	for i := 0; i < 20; i++ {
+		if err = backend.Emit(filepath.Join(dir, fmt.Sprint(i)), WRITE); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if maxRun := counter.MaxRunning(); maxRun != 1 {
+		t.Fatalf(`got %d serial callback functions called concurrently, want 1`, maxRun)
+	}
+	for i, path := range paths {
+		if want := filepath.Join(dir, fmt.Sprint(i)); path != want {
+			t.Fatalf(`got event %d of %s, want %s`, i, path, want)
+		}
+	}
+}
+
+func TestSerialExecutor_Close(t *testing.T) {
+	var (
+		called  = make(chan *Event)
+		blocked = make(chan struct{})
+		done    = make(chan struct{}, 3)
+	)
+	executor := newSerialExecutor(func(event *Event) {
+		called <- event
+		<-blocked
+	}, func() { done <- struct{}{} })
+	for i := 0; i < 3; i++ {
+		executor.Push(&Event{Path: fmt.Sprint(i)})
+	}
+	if event := <-called; event.Path != "0" {
+		t.Fatalf(`got event %s, want 0`, event.Path)
+	}
+	// The waiting events are dropped by closing, which are also done.
+	executor.Close()
+	executor.Push(&Event{Path: "3"})
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	close(blocked)
+	<-done
+	select {
+	case event := <-called:
+		t.Fatalf(`unexpected calling with event %s after closing`, event.Path)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
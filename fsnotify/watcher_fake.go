@@ -0,0 +1,228 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+)
+
+// FakeBackend is the in-memory Backend for tests, which produces no events by itself, but
+// the events injected by Emit, EmitMove, EmitOverflow and EmitError.
+//
+// The injecting returns after the watcher receives the event, and the events are dispatched
+// in injecting order without filtering the repeated ones, so that the dispatching can be
+// asserted by Subscribe, and Flush tells that nothing more is dispatched, without sleeping.
+// Note that the watcher still checks the existence of the paths of events on the file system,
+// like re-adding the monitor of the path that still exists after REMOVE event.
+type FakeBackend struct {
+	mu        sync.Mutex          // mu ensures the concurrent safety of the fields below.
+	watched   map[string]struct{} // watched is the monitored paths.
+	addErrors map[string]error    // addErrors is the errors returned by Add for the paths.
+	moves     map[string]string   // moves is the new path to old path mapping of moving.
+	events    chan fsnotify.Event // events is the channel of the injected events.
+	errors    chan error          // errors is the channel of the injected errors.
+	flushes   chan chan struct{}  // flushes is the channel of the flushing requests.
+	closeChan chan struct{}       // closeChan is used for closing notification.
+	closeOnce sync.Once           // closeOnce ensures the backend is closed only once.
+}
+
+// NewFakeBackend creates and returns a new in-memory backend for tests.
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		watched:   make(map[string]struct{}),
+		addErrors: make(map[string]error),
+		moves:     make(map[string]string),
+		events:    make(chan fsnotify.Event),
+		errors:    make(chan error),
+		flushes:   make(chan chan struct{}),
+		closeChan: make(chan struct{}),
+	}
+}
+
+// Add starts monitoring `path`, or returns the error set by SetAddError for `path`.
+func (b *FakeBackend) Add(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.isClosed() {
+		return fsnotify.ErrClosed
+	}
+	if err := b.addErrors[path]; err != nil {
+		return err
+	}
+	b.watched[path] = struct{}{}
+	return nil
+}
+
+// Remove stops monitoring `path`.
+func (b *FakeBackend) Remove(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.watched[path]; !ok {
+		return errors.NewCodef(codes.CodeInvalidParameter, `path "%s" is not monitored`, path)
+	}
+	delete(b.watched, path)
+	return nil
+}
+
+// Close stops monitoring all paths, and the injecting returns error after closing.
+func (b *FakeBackend) Close() error {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		close(b.closeChan)
+		b.watched = make(map[string]struct{})
+	})
+	return nil
+}
+
+// Events returns the channel of the injected events.
+func (b *FakeBackend) Events() <-chan fsnotify.Event {
+	return b.events
+}
+
+// Errors returns the channel of the injected errors.
+func (b *FakeBackend) Errors() <-chan error {
+	return b.errors
+}
+
+// RenamedFrom returns the old path if `ev` is the CREATE event injected by EmitMove, or else
+// it returns empty string.
+func (b *FakeBackend) RenamedFrom(ev fsnotify.Event) string {
+	if ev.Op&fsnotify.Create == 0 {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	oldPath := b.moves[ev.Name]
+	delete(b.moves, ev.Name)
+	return oldPath
+}
+
+// Emit injects the event of `op` on `path`, in which `op` is the union of CREATE, WRITE,
+// REMOVE, RENAME and CHMOD. It returns after the watcher receives the event.
+func (b *FakeBackend) Emit(path string, op Op) error {
+	if op&(MOVE|OVERFLOW) != 0 {
+		return errors.NewCodef(codes.CodeInvalidParameter, `invalid operation "%d" for emitting`, op)
+	}
+	return b.emit(fsnotify.Event{Name: path, Op: fsnotify.Op(op)})
+}
+
+// EmitMove injects the events of moving `oldPath` to `newPath`, which are the RENAME event
+// of `oldPath` and the CREATE event of `newPath` paired as one MOVE event by the watcher.
+func (b *FakeBackend) EmitMove(oldPath, newPath string) error {
+	if err := b.emit(fsnotify.Event{Name: oldPath, Op: fsnotify.Rename}); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.moves[newPath] = oldPath
+	b.mu.Unlock()
+	return b.emit(fsnotify.Event{Name: newPath, Op: fsnotify.Create})
+}
+
+// EmitOverflow injects the losing of events, which is dispatched as OVERFLOW event.
+func (b *FakeBackend) EmitOverflow() error {
+	return b.EmitError(fsnotify.ErrEventOverflow)
+}
+
+// EmitError injects `err` as the error of the underlying monitor.
+func (b *FakeBackend) EmitError(err error) error {
+	select {
+	case b.errors <- err:
+		return nil
+	case <-b.closeChan:
+		return fsnotify.ErrClosed
+	}
+}
+
+// Flush returns after the watcher dispatches all the events injected before, and the
+// subscribers of Subscribe receive all the events dispatched to them, which blocks until
+// they're received. It returns error if the backend is closed.
+//
+// Note that the callback functions might still be running, and the events might be still
+// pending in the debounce window.
+func (b *FakeBackend) Flush() error {
+	done := make(chan struct{})
+	select {
+	case b.flushes <- done:
+	case <-b.closeChan:
+		return fsnotify.ErrClosed
+	}
+	select {
+	case <-done:
+		return nil
+	case <-b.closeChan:
+		return fsnotify.ErrClosed
+	}
+}
+
+// SetAddError sets the error returned by Add for `path`, which simulates that `path` cannot
+// be monitored. It clears the error for `path` if `err` is nil.
+func (b *FakeBackend) SetAddError(path string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		delete(b.addErrors, path)
+		return
+	}
+	b.addErrors[path] = err
+}
+
+// Watched returns all the monitored paths in order.
+func (b *FakeBackend) Watched() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	paths := make([]string, 0, len(b.watched))
+	for path := range b.watched {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// WatchCount returns the number of the monitored paths, which implements WatchCounter.
+func (b *FakeBackend) WatchCount(bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.watched)
+}
+
+// IsWatching checks whether `path` is monitored, which implements WatchCounter.
+func (b *FakeBackend) IsWatching(path string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.watched[path]
+	return ok
+}
+
+// flushRequests returns the channel of the flushing requests, which implements injectingBackend.
+func (b *FakeBackend) flushRequests() <-chan chan struct{} {
+	return b.flushes
+}
+
+// emit sends `ev` to the watcher, which returns error if the backend is closed.
+func (b *FakeBackend) emit(ev fsnotify.Event) error {
+	select {
+	case b.events <- ev:
+		return nil
+	case <-b.closeChan:
+		return fsnotify.ErrClosed
+	}
+}
+
+// isClosed checks whether the backend is closed.
+func (b *FakeBackend) isClosed() bool {
+	select {
+	case <-b.closeChan:
+		return true
+	default:
+		return false
+	}
+}
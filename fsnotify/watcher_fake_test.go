@@ -0,0 +1,183 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/gocarp/errors"
+)
+
+func TestFakeBackend(t *testing.T) {
+	backend := NewFakeBackend()
+	defer backend.Close()
+	for _, path := range []string{"/b", "/a"} {
+		if err := backend.Add(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := backend.Watched(); !reflect.DeepEqual(got, []string{"/a", "/b"}) {
+		t.Fatalf(`got watched paths %v, want [/a /b]`, got)
+	}
+	// The error set for the path is returned by Add until it is cleared.
+	limit := errors.New("limit")
+	backend.SetAddError("/c", limit)
+	if err := backend.Add("/c"); err != limit || backend.IsWatching("/c") {
+		t.Fatalf(`got error %v, want %v`, err, limit)
+	}
+	backend.SetAddError("/c", nil)
+	if err := backend.Add("/c"); err != nil || !backend.IsWatching("/c") {
+		t.Fatalf(`got error %v after clearing, want nil`, err)
+	}
+	if err := backend.Remove("/c"); err != nil || backend.IsWatching("/c") {
+		t.Fatalf(`got error %v, want nil`, err)
+	}
+	if err := backend.Remove("/c"); err == nil {
+		t.Fatal(`removing path not monitored returns no error`)
+	}
+	if err := backend.Emit("/a", MOVE); err == nil {
+		t.Fatal(`emitting MOVE returns no error`)
+	}
+	if err := backend.Emit("/a", OVERFLOW); err == nil {
+		t.Fatal(`emitting OVERFLOW returns no error`)
+	}
+}
+
+func TestFakeBackend_EmitMove(t *testing.T) {
+	backend := NewFakeBackend()
+	defer backend.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- backend.EmitMove("/a", "/b")
+	}()
+	rename := <-backend.Events()
+	if rename.Name != "/a" || rename.Op != fsnotify.Rename {
+		t.Fatalf(`got %v, want RENAME of /a`, rename)
+	}
+	if oldPath := backend.RenamedFrom(rename); oldPath != "" {
+		t.Fatalf(`got old path %s of RENAME event, want empty`, oldPath)
+	}
+	create := <-backend.Events()
+	if create.Name != "/b" || create.Op != fsnotify.Create {
+		t.Fatalf(`got %v, want CREATE of /b`, create)
+	}
+	if oldPath := backend.RenamedFrom(create); oldPath != "/a" {
+		t.Fatalf(`got old path %s, want /a`, oldPath)
+	}
+	// The moving is paired only once.
+	if oldPath := backend.RenamedFrom(create); oldPath != "" {
+		t.Fatalf(`got old path %s of paired CREATE event, want empty`, oldPath)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeBackend_Close(t *testing.T) {
+	backend := NewFakeBackend()
+	if err := backend.Add("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.Watched()) != 0 {
+		t.Fatalf(`got watched paths %v after closing, want none`, backend.Watched())
+	}
+	if err := backend.Add("/a"); err != fsnotify.ErrClosed {
+		t.Fatalf(`got error %v, want %v`, err, fsnotify.ErrClosed)
+	}
+	if err := backend.Emit("/a", WRITE); err != fsnotify.ErrClosed {
+		t.Fatalf(`got error %v, want %v`, err, fsnotify.ErrClosed)
+	}
+	if err := backend.EmitMove("/a", "/b"); err != fsnotify.ErrClosed {
+		t.Fatalf(`got error %v, want %v`, err, fsnotify.ErrClosed)
+	}
+	if err := backend.EmitOverflow(); err != fsnotify.ErrClosed {
+		t.Fatalf(`got error %v, want %v`, err, fsnotify.ErrClosed)
+	}
+}
+
+func TestWatcher_FakeBackend(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t, "s/a", "b")
+		b          = filepath.Join(dir, "b")
+		events     = newTestSubscription(t, w, dir, SubscribeOptions{})
+	)
+	// The registration monitors the path and its sub-folders by the backend.
+	if got := backend.Watched(); !reflect.DeepEqual(got, []string{dir, filepath.Join(dir, "s")}) {
+		t.Fatalf(`got watched paths %v, want [%s %s]`, got, dir, filepath.Join(dir, "s"))
+	}
+	// The events are dispatched in injecting order, and the repeated events are not filtered.
+	ops := []Op{WRITE, WRITE, CHMOD, WRITE}
+	for _, op := range ops {
+		emitEvent(t, backend, b, op)
+	}
+	for _, op := range ops {
+		if event := receiveEvent(t, events); event.Path != b || event.Op != op {
+			t.Fatalf(`got %s %v, want %v of %s`, event.Path, event.Op, op, b)
+		}
+	}
+	expectFlushed(t, backend, events)
+	if err := backend.EmitOverflow(); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); !event.IsOverflow() || event.Path != dir {
+		t.Fatalf(`got %s %v, want OVERFLOW of %s`, event.Path, event.Op, dir)
+	}
+	w.Close()
+	if err := backend.Emit(b, WRITE); err != fsnotify.ErrClosed {
+		t.Fatalf(`got error %v after closing watcher, want %v`, err, fsnotify.ErrClosed)
+	}
+	if err := backend.Flush(); err != fsnotify.ErrClosed {
+		t.Fatalf(`got error %v of flushing after closing watcher, want %v`, err, fsnotify.ErrClosed)
+	}
+}
+
+func TestWatcher_FakeBackendFlush(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t)
+		a          = filepath.Join(dir, "a")
+		events     = newTestSubscription(t, w, dir, SubscribeOptions{})
+		flushed    = make(chan error, 1)
+	)
+	// The RENAME event of the path that does not exist waits for pairing, which is flushed.
+	emitEvent(t, backend, a, WRITE)
+	emitEvent(t, backend, a, RENAME)
+	go func() {
+		flushed <- backend.Flush()
+	}()
+	// The flushing is done after the subscriber receives the events.
+	for _, op := range []Op{WRITE, RENAME} {
+		select {
+		case <-flushed:
+			t.Fatalf(`flushing is done before receiving %v`, op)
+		case event := <-events:
+			if event.Path != a || event.Op != op {
+				t.Fatalf(`got %s %v, want %v of %s`, event.Path, event.Op, op, a)
+			}
+		case <-time.After(testEventTimeout):
+			t.Fatal(`timeout waiting for event`)
+		}
+	}
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(testEventTimeout):
+		t.Fatal(`timeout waiting for flushing`)
+	}
+}
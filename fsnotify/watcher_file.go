@@ -0,0 +1,237 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+)
+
+// fileWatch watches a single file through its parent directories, which follows the
+// symbolic link chain of the file and survives the atomic replacements of the file.
+type fileWatch struct {
+	mu      sync.Mutex           // mu ensures the concurrent safety of state and dirs.
+	callMu  sync.Mutex           // callMu ensures the changes are checked and called back in serial.
+	watcher *Watcher             // watcher is the parent watcher.
+	path    string               // path is the watched file path (absolute).
+	fn      func(event *Event)   // fn is the callback function.
+	primary *Callback            // primary is the callback of the parent directory of path.
+	dirs    map[string]*Callback // dirs is the directory to callback mapping of the link chain.
+	state   fileWatchState       // state is the last known state of the resolved file.
+	closed  bool                 // closed marks the watch closed.
+}
+
+// fileWatchState is the state of the resolved file of a fileWatch.
+type fileWatchState struct {
+	exists   bool      // exists specifies whether the resolved file exists.
+	realPath string    // realPath is the resolved file path following the link chain.
+	stat     pollState // stat is the stat of the resolved file.
+	hash     uint64    // hash is the hash of the content of the resolved file.
+	dirs     []string  // dirs is the real paths of the directories of the link chain.
+}
+
+const (
+	maxFileLinkDepth = 255 // Maximum depth of symbolic link chain.
+)
+
+// WatchFile monitors the single file `path` with callback function `callbackFunc`, which
+// survives the atomic replacements of the file by editors or Kubernetes ConfigMap updates.
+//
+// It monitors the parent directories of the file and all the symbolic links in its link
+// chain instead of the file itself, and calls `callbackFunc` with one event of `path`
+// whenever the resolved content changes: CREATE if the file appears, REMOVE if it disappears,
+// and WRITE if its content changes. The content is compared only if the stat of the resolved
+// file changes. The `path` itself might not exist, but its parent directory should exist.
+//
+// The callback function is called in serial. Calling RemoveCallback with the id of the returned
+// callback stops the monitoring of all the directories.
+func (w *Watcher) WatchFile(path string, callbackFunc func(event *Event)) (callback *Callback, err error) {
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, errors.Wrapf(err, `get absolute path failed for "%s"`, path)
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return nil, errors.NewCodef(codes.CodeInvalidParameter, `directory of "%s" does not exist`, path)
+	}
+	f := &fileWatch{
+		watcher: w,
+		path:    path,
+		fn:      callbackFunc,
+		dirs:    make(map[string]*Callback),
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state = f.resolve()
+	if f.primary, err = f.addDir(dir); err != nil {
+		return nil, err
+	}
+	f.primary.file = f
+	f.updateDirs(f.state.dirs)
+	return f.primary, nil
+}
+
+// Close stops monitoring the directories of the link chain.
+func (f *fileWatch) Close() {
+	f.mu.Lock()
+	f.closed = true
+	ids := make([]int, 0, len(f.dirs))
+	for _, callback := range f.dirs {
+		if callback != f.primary {
+			ids = append(ids, callback.Id)
+		}
+	}
+	f.dirs = nil
+	f.mu.Unlock()
+	for _, id := range ids {
+		f.watcher.RemoveCallback(id)
+	}
+}
+
+// check resolves the file again on any event of the monitored directories, and calls the
+// callback function if the resolved content changes.
+//
+// The change is computed and the callback function is called under callMu, so that the
+// overlapping checks call the callback function in the order of the changes.
+func (f *fileWatch) check(*Event) {
+	f.callMu.Lock()
+	defer f.callMu.Unlock()
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	state := f.resolve()
+	f.updateDirs(state.dirs)
+	op := f.compare(f.state, &state)
+	f.state = state
+	f.mu.Unlock()
+	if op == 0 {
+		return
+	}
+	if exit := f.call(op); exit && f.primary != nil {
+		f.watcher.RemoveCallback(f.primary.Id)
+	}
+}
+
+// call calls the callback function with the event of `op`, and returns whether the callback
+// function calls Exit.
+func (f *fileWatch) call(op Op) (exit bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			if err != callbackExitEventPanicStr {
+				panic(err)
+			}
+			exit = true
+		}
+	}()
+	f.fn(&Event{
+		event:   fsnotify.Event{Name: f.path, Op: fsnotify.Op(op)},
+		Path:    f.path,
+		Op:      op,
+		Watcher: f.watcher,
+	})
+	return
+}
+
+// resolve resolves the link chain of the file, and returns the state of the resolved file.
+func (f *fileWatch) resolve() (state fileWatchState) {
+	var (
+		path = f.path
+		dirs = make(map[string]struct{})
+	)
+	for i := 0; i < maxFileLinkDepth; i++ {
+		// The directory is monitored in its real path, as the directory might be a symbolic link.
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+			if _, ok := dirs[dir]; !ok {
+				dirs[dir] = struct{}{}
+				state.dirs = append(state.dirs, dir)
+			}
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			state.exists = !info.IsDir()
+			state.realPath = path
+			state.stat = newPollState(info)
+			return
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return
+}
+
+// compare compares the `last` and `current` states, and returns the operation of the change,
+// which is 0 if the resolved content does not change. It fills the content hash of `current`.
+func (f *fileWatch) compare(last fileWatchState, current *fileWatchState) Op {
+	switch {
+	case !current.exists:
+		if last.exists {
+			return REMOVE
+		}
+		return 0
+
+	case !last.exists:
+		current.hash, _ = fileContentHash(current.realPath)
+		return CREATE
+
+	case last.realPath == current.realPath && last.stat == current.stat:
+		current.hash = last.hash
+		return 0
+	}
+	var err error
+	if current.hash, err = fileContentHash(current.realPath); err != nil || current.hash != last.hash {
+		return WRITE
+	}
+	return 0
+}
+
+// updateDirs monitors the directories `dirs` of the link chain, and stops monitoring the
+// directories that are no longer in the link chain, except the parent directory of the file.
+// Note that it should be called with the lock held.
+func (f *fileWatch) updateDirs(dirs []string) {
+	current := make(map[string]struct{}, len(dirs))
+	for _, dir := range dirs {
+		current[dir] = struct{}{}
+		if _, ok := f.dirs[dir]; ok {
+			continue
+		}
+		if _, err := f.addDir(dir); err != nil {
+			f.watcher.handleError(err)
+		}
+	}
+	for dir, callback := range f.dirs {
+		if _, ok := current[dir]; ok || callback == f.primary {
+			continue
+		}
+		delete(f.dirs, dir)
+		f.watcher.RemoveCallback(callback.Id)
+	}
+}
+
+// addDir monitors directory `dir` non-recursively for the file.
+// Note that it should be called with the lock held.
+func (f *fileWatch) addDir(dir string) (*Callback, error) {
+	callback, err := f.watcher.addOnceWithOptions("", dir, f.check, WatchOptions{NoRecursive: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	f.dirs[dir] = callback
+	return callback, nil
+}
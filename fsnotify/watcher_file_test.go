@@ -0,0 +1,188 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestFileWatch watches file `path` of `w`, and returns the channel of the events.
+func newTestFileWatch(t *testing.T, w *Watcher, path string) <-chan *Event {
+	t.Helper()
+	events := make(chan *Event, 10)
+	if _, err := w.WatchFile(path, func(event *Event) { events <- event }); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// replaceTestFile replaces file `path` with `content` atomically.
+func replaceTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher_WatchFile(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t, "config")
+		config = filepath.Join(dir, "config")
+		events = newTestFileWatch(t, w, config)
+	)
+	// The atomic replacement with changed content is a WRITE.
+	replaceTestFile(t, config, "changed")
+	if event := receiveEvent(t, events); event.Path != config || event.Op != WRITE {
+		t.Fatalf(`got %s %v, want WRITE of %s`, event.Path, event.Op, config)
+	}
+	// The atomic replacement with the same content is nothing.
+	time.Sleep(10 * time.Millisecond)
+	replaceTestFile(t, config, "changed")
+	// The events of other files are nothing.
+	if err := os.WriteFile(filepath.Join(dir, "other"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectNoEvent(t, events, 50*time.Millisecond)
+
+	if err := os.Remove(config); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); event.Op != REMOVE {
+		t.Fatalf(`got %v, want REMOVE`, event.Op)
+	}
+	if err := os.WriteFile(config, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); event.Op != CREATE {
+		t.Fatalf(`got %v, want CREATE`, event.Op)
+	}
+}
+
+func TestWatcher_WatchFileLink(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t, "..v1/config", "..v2/config")
+		config = filepath.Join(dir, "config")
+		data   = filepath.Join(dir, "..data")
+	)
+	// It's the layout of Kubernetes ConfigMap volume, which updates by switching ..data.
+	if err := os.Symlink("..v1", data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "config"), config); err != nil {
+		t.Fatal(err)
+	}
+	events := newTestFileWatch(t, w, config)
+	if err := os.WriteFile(filepath.Join(dir, "..v2", "config"), []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..v2", data+".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(data+".tmp", data); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); event.Path != config || event.Op != WRITE {
+		t.Fatalf(`got %s %v, want WRITE of %s`, event.Path, event.Op, config)
+	}
+	// The change of the old target is nothing.
+	if err := os.WriteFile(filepath.Join(dir, "..v1", "config"), []byte("v1 changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectNoEvent(t, events, 50*time.Millisecond)
+}
+
+func TestWatcher_WatchFileRemoveCallback(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t, "target/config")
+		config = filepath.Join(dir, "config")
+	)
+	if err := os.Symlink(filepath.Join(dir, "target", "config"), config); err != nil {
+		t.Fatal(err)
+	}
+	callback, err := w.WatchFile(config, func(event *Event) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The directories of the link chain are monitored by the callbacks of the watch.
+	if len(w.Callbacks()) != 2 {
+		t.Fatalf(`got %d callbacks, want 2`, len(w.Callbacks()))
+	}
+	w.RemoveCallback(callback.Id)
+	if len(w.Callbacks()) != 0 {
+		t.Fatalf(`got %d callbacks after removing, want 0`, len(w.Callbacks()))
+	}
+	if _, err = w.WatchFile(filepath.Join(dir, "none", "config"), func(event *Event) {}); err == nil {
+		t.Fatal(`expected error for absent directory`)
+	}
+}
+
+func TestFileWatch_CheckOrder(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t, "config")
+		config = filepath.Join(dir, "config")
+		mu     sync.Mutex
+		ops    []Op
+	)
+	callback, err := w.WatchFile(config, func(event *Event) {
+		mu.Lock()
+		ops = append(ops, event.Op)
+		mu.Unlock()
+		time.Sleep(100 * time.Microsecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The overlapping checks call back the changes in order, in which the file is removed
+	// and created in turn.
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					callback.file.check(nil)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		if i%2 == 0 {
+			_ = os.Remove(config)
+		} else {
+			_ = os.WriteFile(config, nil, 0644)
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+	close(done)
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	last := CREATE
+	for i, op := range ops {
+		if op == last {
+			t.Fatalf(`got %v after %v at %d, want in turn`, op, last, i)
+		}
+		last = op
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+)
+
+// pathFilter filters the paths under the bound path of a callback using include and exclude
+// patterns in gitignore style.
+type pathFilter struct {
+	root    string        // root is the bound path of the callback.
+	include []pathPattern // include patterns, any of which the path should match if not empty.
+	exclude []pathPattern // exclude patterns, the last matched one decides whether the path is excluded.
+}
+
+// pathPattern is a compiled gitignore-style pattern.
+type pathPattern struct {
+	segments []string // segments is the slash separated pattern segments.
+	anchored bool     // anchored means the pattern matches from the root, or else it matches the name at any depth.
+	dirOnly  bool     // dirOnly means the pattern only matches directories.
+	negate   bool     // negate means the pattern re-includes the paths excluded by previous patterns.
+}
+
+// newPathFilter compiles and returns a pathFilter for `root` with given patterns.
+// It returns nil if there are no patterns.
+func newPathFilter(root string, include, exclude []string) (*pathFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	filter := &pathFilter{root: root}
+	for _, p := range include {
+		pattern, err := parsePathPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		if pattern.negate {
+			return nil, errors.NewCodef(codes.CodeInvalidParameter, `negation is not supported for include pattern "%s"`, p)
+		}
+		filter.include = append(filter.include, pattern)
+	}
+	for _, p := range exclude {
+		pattern, err := parsePathPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		filter.exclude = append(filter.exclude, pattern)
+	}
+	return filter, nil
+}
+
+// parsePathPattern compiles a gitignore-style pattern:
+//
+//  1. A pattern without slash, like "*.swp" or ".git", matches the name at any depth.
+//  2. A pattern with leading or middle slash, like "/build" or "docs/*.md", matches the path relative to the root.
+//  3. A pattern with trailing slash, like "node_modules/", matches only directories.
+//  4. The "**" segment matches zero or more directories, like "**/testdata" or "a/**/b".
+//  5. A pattern with leading "!" re-includes the paths excluded by previous patterns.
+//
+// A pattern matching a directory also matches all the paths under the directory.
+func parsePathPattern(p string) (pattern pathPattern, err error) {
+	p = strings.TrimSpace(filepath.ToSlash(p))
+	if strings.HasPrefix(p, "!") {
+		pattern.negate = true
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		pattern.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if strings.Contains(p, "/") {
+		pattern.anchored = true
+		p = strings.TrimLeft(p, "/")
+	}
+	if p == "" {
+		return pattern, errors.NewCode(codes.CodeInvalidParameter, `empty path pattern`)
+	}
+	pattern.segments = strings.Split(p, "/")
+	for _, segment := range pattern.segments {
+		if _, err = path.Match(segment, ""); err != nil {
+			return pattern, errors.WrapCodef(codes.CodeInvalidParameter, err, `invalid path pattern "%s"`, p)
+		}
+	}
+	return pattern, nil
+}
+
+// Match checks whether `filePath` should be dispatched to the callback.
+// The bound path itself and paths out of the bound path always match.
+func (f *pathFilter) Match(filePath string, isDir bool) bool {
+	if f == nil {
+		return true
+	}
+	segments := f.relativeSegments(filePath)
+	if len(segments) == 0 {
+		return true
+	}
+	if f.isExcluded(segments, isDir) {
+		return false
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if pattern.Match(segments, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExcludesDir checks whether directory `dirPath` is excluded, in which case the directory
+// and all its sub-paths need no monitoring.
+func (f *pathFilter) ExcludesDir(dirPath string) bool {
+	if f == nil {
+		return false
+	}
+	segments := f.relativeSegments(dirPath)
+	if len(segments) == 0 {
+		return false
+	}
+	return f.isExcluded(segments, true)
+}
+
+// isExcluded checks whether the path of `segments` is excluded by the exclude patterns.
+// The last matched pattern decides the result, like gitignore.
+func (f *pathFilter) isExcluded(segments []string, isDir bool) bool {
+	excluded := false
+	for _, pattern := range f.exclude {
+		if pattern.Match(segments, isDir) {
+			excluded = !pattern.negate
+		}
+	}
+	return excluded
+}
+
+// relativeSegments returns the slash separated segments of `filePath` relative to the root.
+// It returns nil if `filePath` is the root or out of the root.
+func (f *pathFilter) relativeSegments(filePath string) []string {
+	rel, err := filepath.Rel(f.root, filePath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	return strings.Split(filepath.ToSlash(rel), "/")
+}
+
+// Match checks whether the path of `segments` or any of its parent directories matches the pattern.
+func (p pathPattern) Match(segments []string, isDir bool) bool {
+	for i := 1; i <= len(segments); i++ {
+		// The parent directories are always directories.
+		if p.dirOnly && i == len(segments) && !isDir {
+			continue
+		}
+		if p.anchored {
+			if matchPathSegments(p.segments, segments[:i]) {
+				return true
+			}
+		} else if matchPathSegments(p.segments, segments[i-1:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPathSegments checks whether the `name` segments fully match the `pattern` segments,
+// in which "**" matches zero or more segments.
+func matchPathSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchPathSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPathFilter_Match(t *testing.T) {
+	filter, err := newPathFilter(
+		"/r",
+		[]string{"*.go", "docs/"},
+		[]string{".git/", "node_modules/", "*.swp", "/build", "a/**/b", "!keep.swp"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"/r", true, true},
+		{"/other/x.txt", false, true},
+		{"/r/main.go", false, true},
+		{"/r/x/y.go", false, true},
+		{"/r/x/y.txt", false, false},
+		{"/r/docs/a.md", false, true},
+		{"/r/docs", false, false},
+		{"/r/.git/HEAD", false, false},
+		{"/r/node_modules/x.go", false, false},
+		{"/r/a.go.swp", false, false},
+		{"/r/keep.swp", false, false},
+		{"/r/build/x.go", false, false},
+		{"/r/sub/build/x.go", false, true},
+		{"/r/a/b/z.go", false, false},
+		{"/r/a/x/y/b/z.go", false, false},
+		{"/r/a/x/z.go", false, true},
+	}
+	for _, c := range cases {
+		if got := filter.Match(c.path, c.isDir); got != c.want {
+			t.Errorf(`Match(%s, %v) = %v, want %v`, c.path, c.isDir, got, c.want)
+		}
+	}
+}
+
+func TestPathFilter_Negate(t *testing.T) {
+	filter, err := newPathFilter("/r", nil, []string{"*.log", "!important.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.Match("/r/debug.log", false) {
+		t.Fatal(`debug.log is not excluded`)
+	}
+	if !filter.Match("/r/important.log", false) {
+		t.Fatal(`important.log is not re-included`)
+	}
+}
+
+func TestPathFilter_ExcludesDir(t *testing.T) {
+	filter, err := newPathFilter("/r", nil, []string{".git/", "*.tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.ExcludesDir("/r/.git") || !filter.ExcludesDir("/r/x/.git") {
+		t.Fatal(`.git directories are not excluded`)
+	}
+	if filter.ExcludesDir("/r") || filter.ExcludesDir("/r/src") {
+		t.Fatal(`root or src directory is excluded`)
+	}
+	var nilFilter *pathFilter
+	if nilFilter.ExcludesDir("/r/.git") || !nilFilter.Match("/r/.git", true) {
+		t.Fatal(`nil filter filters paths`)
+	}
+}
+
+func TestPathFilter_Invalid(t *testing.T) {
+	for _, c := range []struct {
+		include []string
+		exclude []string
+	}{
+		{include: []string{"!x"}},
+		{exclude: []string{""}},
+		{exclude: []string{"/"}},
+		{exclude: []string{"[a"}},
+	} {
+		if _, err := newPathFilter("/r", c.include, c.exclude); err == nil {
+			t.Errorf(`expected error for include %v exclude %v`, c.include, c.exclude)
+		}
+	}
+}
+
+func TestWatcher_Filter(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t, ".git/objects/x", "src/a.go")
+		events     = newTestSubscription(t, w, dir, SubscribeOptions{
+			WatchOptions: WatchOptions{Exclude: []string{".git/", "*.swp"}},
+		})
+	)
+	// The excluded directories are not monitored.
+	if backend.IsWatching(filepath.Join(dir, ".git")) || backend.IsWatching(filepath.Join(dir, ".git", "objects")) {
+		t.Fatalf(`excluded directory is monitored: %v`, backend.Watched())
+	}
+	if !backend.IsWatching(filepath.Join(dir, "src")) {
+		t.Fatalf(`directory is not monitored: %v`, backend.Watched())
+	}
+	emitEvent(t, backend, filepath.Join(dir, ".git", "HEAD"), WRITE)
+	emitEvent(t, backend, filepath.Join(dir, "src", "a.go.swp"), WRITE)
+	emitEvent(t, backend, filepath.Join(dir, "src", "a.go"), WRITE)
+	if event := receiveEvent(t, events); event.Path != filepath.Join(dir, "src", "a.go") {
+		t.Fatalf(`got event of %s, want src/a.go`, event.Path)
+	}
+	// The moving from an excluded path to an included path is dispatched.
+	if err := backend.EmitMove(filepath.Join(dir, "src", "a.go.swp"), filepath.Join(dir, "src", "b.go")); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); event.Path != filepath.Join(dir, "src", "b.go") || !event.IsMove() {
+		t.Fatalf(`got event %s %v, want MOVE of src/b.go`, event.Path, event.Op)
+	}
+	expectFlushed(t, backend, events)
+}
+
+func TestWatcher_FilterRemovedDir(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var (
+		dir    = fileRealPath(t.TempDir())
+		events = make(chan *Event, 100)
+	)
+	for _, name := range []string{"docs", "build", "logs"} {
+		if err = os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = w.AddWithOptions(dir, func(event *Event) {
+		events <- event
+	}, WatchOptions{Include: []string{"docs/", "logs"}, Exclude: []string{"build/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The directory-only patterns match the removed directories, which are gone at dispatching.
+	for _, name := range []string{"build", "docs", "logs"} {
+		if err = os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := make(map[string]bool)
+	for len(got) < 2 {
+		select {
+		case event := <-events:
+			if event.IsRemove() {
+				got[filepath.Base(event.Path)] = true
+			}
+		case <-time.After(testEventTimeout):
+			t.Fatalf(`got removed %v, want docs and logs`, got)
+		}
+	}
+	if got["build"] {
+		t.Fatal(`removing of excluded directory is dispatched`)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"context"
+
+	"github.com/gocarp/helpers/intlog"
+)
+
+// LifecycleEvent is the notification of the bound path of a callback.
+type LifecycleEvent struct {
+	Path     string         // Bound path of the callback (absolute).
+	State    LifecycleState // State of the bound path.
+	Callback *Callback      // Callback bound to the path.
+	Error    error          // Error why the path becomes unwatchable, which is nil for other states.
+}
+
+// LifecycleState is the state of the bound path of a callback.
+type LifecycleState int
+
+const (
+	// LifecycleRemoved means the bound path is removed or moved away.
+	LifecycleRemoved LifecycleState = iota + 1
+
+	// LifecycleUnwatchable means the bound path exists but cannot be monitored anymore.
+	LifecycleUnwatchable
+)
+
+// String returns the state as string.
+func (s LifecycleState) String() string {
+	switch s {
+	case LifecycleRemoved:
+		return "REMOVED"
+	case LifecycleUnwatchable:
+		return "UNWATCHABLE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// handleError reports `err` to the error handler of the watcher, or to the internal logging
+// if no error handler is configured.
+func (w *Watcher) handleError(err error) {
+	if w.config.ErrorHandler != nil {
+		w.config.ErrorHandler(err)
+		return
+	}
+	intlog.Errorf(context.TODO(), `%+v`, err)
+}
+
+// notifyLifecycle notifies the lifecycle handler of the watcher with `state` of the callbacks
+// bound to `path`. It does nothing if no lifecycle handler is configured.
+func (w *Watcher) notifyLifecycle(path string, state LifecycleState, err error) {
+	if w.config.LifecycleHandler == nil {
+		return
+	}
+	for _, callback := range w.callbacks.Get(path) {
+		w.config.LifecycleHandler(&LifecycleEvent{
+			Path:     path,
+			State:    state,
+			Callback: callback,
+			Error:    err,
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+)
+
+func TestWatcher_ErrorHandlerPanic(t *testing.T) {
+	var (
+		errs       = make(chan error, 2)
+		w, backend = newTestWatcher(t, WatcherConfig{
+			ErrorHandler: func(err error) { errs <- err },
+		})
+		dir = newTestDir(t)
+	)
+	_, err := w.Add(dir, func(event *Event) {
+		if event.IsWrite() {
+			panic(errors.New("error"))
+		}
+		panic("string")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitEvent(t, backend, filepath.Join(dir, "a"), WRITE)
+	emitEvent(t, backend, filepath.Join(dir, "a"), CHMOD)
+	for i := 0; i < 2; i++ {
+		if err = receiveError(t, errs); !errors.HasCode(err, codes.CodeInternalPanic) {
+			t.Fatalf(`got error %v, want internal panic`, err)
+		}
+	}
+	// The callback still works after panics.
+	if len(w.Callbacks()) != 1 {
+		t.Fatal(`callback is removed after panics`)
+	}
+}
+
+func TestWatcher_LifecycleRemoved(t *testing.T) {
+	var (
+		lifecycles = make(chan *LifecycleEvent, 1)
+		w, backend = newTestWatcher(t, WatcherConfig{
+			LifecycleHandler: func(event *LifecycleEvent) { lifecycles <- event },
+		})
+		dir = newTestDir(t, "sub/a")
+		sub = filepath.Join(dir, "sub")
+	)
+	callback, err := w.Add(sub, func(event *Event) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.RemoveAll(sub); err != nil {
+		t.Fatal(err)
+	}
+	emitEvent(t, backend, sub, REMOVE)
+	event := receiveLifecycle(t, lifecycles)
+	if event.Path != sub || event.State != LifecycleRemoved || event.Callback != callback || event.Error != nil {
+		t.Fatalf(`got %s %v, want REMOVED of %s`, event.Path, event.State, sub)
+	}
+}
+
+func TestWatcher_LifecycleUnwatchable(t *testing.T) {
+	var (
+		errs       = make(chan error, 1)
+		lifecycles = make(chan *LifecycleEvent, 1)
+		w, backend = newTestWatcher(t, WatcherConfig{
+			ErrorHandler:     func(err error) { errs <- err },
+			LifecycleHandler: func(event *LifecycleEvent) { lifecycles <- event },
+		})
+		dir = newTestDir(t, "a")
+		a   = filepath.Join(dir, "a")
+	)
+	if _, err := w.Add(a, func(event *Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	// The path still exists after REMOVE event, but it cannot be monitored again.
+	backend.SetAddError(a, errors.New("limit"))
+	emitEvent(t, backend, a, REMOVE)
+	err := receiveError(t, errs)
+	event := receiveLifecycle(t, lifecycles)
+	if event.Path != a || event.State != LifecycleUnwatchable || event.Error != err {
+		t.Fatalf(`got %s %v %v, want UNWATCHABLE of %s with %v`, event.Path, event.State, event.Error, a, err)
+	}
+}
+
+func TestLifecycleState_String(t *testing.T) {
+	for state, want := range map[LifecycleState]string{
+		LifecycleRemoved:     "REMOVED",
+		LifecycleUnwatchable: "UNWATCHABLE",
+		0:                    "UNKNOWN",
+	} {
+		if got := state.String(); got != want {
+			t.Errorf(`got %s, want %s`, got, want)
+		}
+	}
+}
+
+// receiveLifecycle receives and returns the next lifecycle event of `events` in time.
+func receiveLifecycle(t *testing.T, events <-chan *LifecycleEvent) *LifecycleEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(testEventTimeout):
+		t.Fatal(`timeout waiting for lifecycle event`)
+	}
+	return nil
+}
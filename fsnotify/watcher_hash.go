@@ -0,0 +1,189 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentTracker tracks the content hashes of the files, which tells the WRITE events that
+// do not change the content of files.
+type contentTracker struct {
+	mu     sync.Mutex              // mu ensures the concurrent safety of states.
+	states map[string]contentState // states is the path to last known content state mapping.
+}
+
+// contentState is the last known content state of a file.
+type contentState struct {
+	stat    pollState // stat is the stat of the file when it's checked.
+	hash    uint64    // hash is the hash of the content of the file, which is valid only if hashed is true.
+	hashed  bool      // hashed specifies whether the content of the file is hashed.
+	checked time.Time // checked is the time when the file is checked.
+}
+
+const (
+	// contentRacyDuration is the granularity of modification time that some file systems have.
+	// The stat of a file modified within the duration before hashing cannot tell the changes,
+	// so that its content is always hashed again.
+	contentRacyDuration = 2 * time.Second
+)
+
+func newContentTracker() *contentTracker {
+	return &contentTracker{
+		states: make(map[string]contentState),
+	}
+}
+
+// Unchanged updates the state of the file of `event`, and returns whether `event` is a WRITE
+// event that does not change the content of the file.
+//
+// The WRITE event is changed if the size of the file changes, and the content is hashed only if
+// the size does not change but the modification time changes, or the file is modified within
+// contentRacyDuration before last checking. The WRITE event of the file without known hash is
+// treated as changed, which makes the hash known for the following events.
+func (t *contentTracker) Unchanged(event *Event) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case event.IsRemove() || event.IsRename():
+		t.remove(event.Path)
+		return false
+
+	case event.IsMove():
+		// The content is moved along with the file.
+		last, ok := t.states[event.OldPath]
+		t.remove(event.OldPath)
+		if ok {
+			t.states[event.Path] = last
+		} else {
+			t.check(event.Path)
+		}
+		return false
+
+	case event.IsCreate():
+		t.check(event.Path)
+		return false
+
+	case event.IsWrite():
+		unchanged := t.write(event.Path)
+		// It only suppresses the pure WRITE event, as other operations are changes.
+		return event.Op == WRITE && unchanged
+	}
+	return false
+}
+
+// Seed hashes the existing files of `paths` that have no known state, so that the first WRITE
+// events of the files can be told. The files are hashed without the lock held, as it's called
+// asynchronously at registration of callbacks.
+func (t *contentTracker) Seed(paths []string) {
+	for _, path := range paths {
+		t.mu.Lock()
+		_, ok := t.states[path]
+		t.mu.Unlock()
+		if ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		hash, err := fileContentHash(path)
+		if err != nil {
+			continue
+		}
+		t.mu.Lock()
+		// The state updated by event during hashing is newer.
+		if _, ok = t.states[path]; !ok {
+			t.states[path] = contentState{
+				stat:    newPollState(info),
+				hash:    hash,
+				hashed:  true,
+				checked: time.Now(),
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// check updates the state of file `path` with its stat, which does not hash the content.
+// It removes the state if `path` is not a regular file.
+// Note that it should be called with the lock held.
+func (t *contentTracker) check(path string) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		delete(t.states, path)
+		return
+	}
+	t.states[path] = contentState{
+		stat:    newPollState(info),
+		checked: time.Now(),
+	}
+}
+
+// write updates the state of file `path` for the WRITE event, and returns whether the content
+// does not change since last known state.
+// Note that it should be called with the lock held.
+func (t *contentTracker) write(path string) bool {
+	last, ok := t.states[path]
+	t.check(path)
+	current, exists := t.states[path]
+	switch {
+	case !ok || !exists || current.stat.size != last.stat.size:
+		return false
+
+	case last.hashed && current.stat == last.stat && last.checked.Sub(last.stat.modTime) > contentRacyDuration:
+		t.states[path] = last
+		return true
+	}
+	hash, err := fileContentHash(path)
+	if err != nil {
+		delete(t.states, path)
+		return false
+	}
+	current.hash, current.hashed = hash, true
+	t.states[path] = current
+	return last.hashed && current.hash == last.hash
+}
+
+// remove removes the states of `path` and its sub-files.
+// Note that it should be called with the lock held.
+func (t *contentTracker) remove(path string) {
+	// The path of known state is a file, which has no sub-files.
+	if _, ok := t.states[path]; ok {
+		delete(t.states, path)
+		return
+	}
+	prefix := path + string(filepath.Separator)
+	for p := range t.states {
+		if strings.HasPrefix(p, prefix) {
+			delete(t.states, p)
+		}
+	}
+}
+
+// seedContents hashes the existing files of the path of `callback` if it suppresses the
+// unchanged WRITE events.
+func (w *Watcher) seedContents(callback *Callback) {
+	if callback.options.SuppressUnchangedWrites {
+		w.contents.Seed(w.scanInitialPaths(callback))
+	}
+}
+
+// isWriteUnchanged checks whether `event` is a WRITE event that does not change the content
+// of the file, if any of `callbacks` suppresses the unchanged WRITE events.
+// It tracks the content only for the paths of such callbacks, and it should be called once
+// for each event, as the state of file is updated.
+func (w *Watcher) isWriteUnchanged(event *Event, callbacks []*Callback) bool {
+	for _, callback := range callbacks {
+		if callback.options.SuppressUnchangedWrites {
+			return w.contents.Unchanged(event)
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestFile writes `content` to file `path` with the modification time `seconds` seconds
+// ago, which is out of contentRacyDuration.
+func writeTestFile(t *testing.T, path, content string, seconds int) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-time.Duration(seconds) * time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContentTracker_Unchanged(t *testing.T) {
+	var (
+		tracker = newContentTracker()
+		dir     = newTestDir(t)
+		a       = filepath.Join(dir, "a")
+		write   = &Event{Path: a, Op: WRITE}
+	)
+	writeTestFile(t, a, "1", 100)
+	tracker.Seed([]string{a, dir})
+	if state := tracker.states[a]; !state.hashed {
+		t.Fatal(`existing file is not hashed by seeding`)
+	}
+	// The content is compared if the size does not change.
+	writeTestFile(t, a, "1", 90)
+	if !tracker.Unchanged(write) {
+		t.Fatal(`rewriting identical content is changed`)
+	}
+	writeTestFile(t, a, "2", 80)
+	if tracker.Unchanged(write) {
+		t.Fatal(`rewriting different content is unchanged`)
+	}
+	// The stat does not change.
+	if !tracker.Unchanged(write) {
+		t.Fatal(`unchanged stat is changed`)
+	}
+	if tracker.Unchanged(&Event{Path: a, Op: WRITE | CHMOD}) {
+		t.Fatal(`WRITE|CHMOD event is unchanged`)
+	}
+	// The size change is changed without hashing.
+	writeTestFile(t, a, "22", 70)
+	if tracker.Unchanged(write) || tracker.states[a].hashed {
+		t.Fatal(`size change is unchanged or hashed`)
+	}
+	// The first rewriting without known hash is changed, which makes the hash known.
+	writeTestFile(t, a, "22", 60)
+	if tracker.Unchanged(write) || !tracker.states[a].hashed {
+		t.Fatal(`rewriting without known hash is unchanged or not hashed`)
+	}
+	writeTestFile(t, a, "22", 50)
+	if !tracker.Unchanged(write) {
+		t.Fatal(`rewriting identical content is changed`)
+	}
+}
+
+func TestContentTracker_Move(t *testing.T) {
+	var (
+		tracker = newContentTracker()
+		dir     = newTestDir(t)
+		a       = filepath.Join(dir, "a")
+		b       = filepath.Join(dir, "b")
+	)
+	writeTestFile(t, a, "1", 100)
+	tracker.Seed([]string{a})
+	if err := os.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	tracker.Unchanged(&Event{Path: b, OldPath: a, Op: CREATE | MOVE})
+	if _, ok := tracker.states[a]; ok {
+		t.Fatal(`state of old path is left`)
+	}
+	writeTestFile(t, b, "1", 90)
+	if !tracker.Unchanged(&Event{Path: b, Op: WRITE}) {
+		t.Fatal(`known hash is not moved`)
+	}
+	tracker.Unchanged(&Event{Path: dir, Op: REMOVE})
+	if len(tracker.states) != 0 {
+		t.Fatal(`states of sub-files are left after removing directory`)
+	}
+}
+
+// overwriteTestFile overwrites file `path` with `content` in place, which does not truncate
+// the file, so that there's a single WRITE event of the final content.
+func overwriteTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = file.WriteAt([]byte(content), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher_SuppressUnchangedWrites(t *testing.T) {
+	var (
+		w   = newTestNotifyWatcher(t)
+		dir = newTestDir(t)
+		a   = filepath.Join(dir, "a")
+	)
+	writeTestFile(t, a, "1", 100)
+	events := newTestSubscription(t, w, dir, SubscribeOptions{
+		WatchOptions: WatchOptions{SuppressUnchangedWrites: true},
+	})
+	// It waits for the seeding at registration.
+	for i := 0; ; i++ {
+		w.contents.mu.Lock()
+		_, ok := w.contents.states[a]
+		w.contents.mu.Unlock()
+		if ok {
+			break
+		}
+		if i == 1000 {
+			t.Fatal(`existing file is not seeded`)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	overwriteTestFile(t, a, "1")
+	expectNoEvent(t, events, 50*time.Millisecond)
+	overwriteTestFile(t, a, "2")
+	if event := receiveEvent(t, events); event.Path != a || event.Op != WRITE {
+		t.Fatalf(`got %s %v, want WRITE of %s`, event.Path, event.Op, a)
+	}
+	// The other callbacks receive the unchanged writes.
+	all := newTestSubscription(t, w, dir, SubscribeOptions{})
+	time.Sleep(10 * time.Millisecond)
+	overwriteTestFile(t, a, "2")
+	if event := receiveEvent(t, all); event.Path != a || event.Op != WRITE {
+		t.Fatalf(`got %s %v, want WRITE of %s`, event.Path, event.Op, a)
+	}
+	expectNoEvent(t, events, 50*time.Millisecond)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// callbackInitializer holds the real events of a callback until the synthetic events of the
+// existing entries are dispatched, which keeps the synthetic events ordered before the real ones.
+type callbackInitializer struct {
+	mu   sync.Mutex // mu ensures the concurrent safety of held and done.
+	held []*Event   // held is the real events produced during the initialization.
+	done bool       // done marks the initialization done, after which the events are no longer held.
+}
+
+// Hold holds `event` if the initialization is not done, and returns whether `event` is held.
+func (i *callbackInitializer) Hold(event *Event) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.done {
+		return false
+	}
+	i.held = append(i.held, event)
+	return true
+}
+
+// emitInitialEvents dispatches the synthetic CREATE events of the existing entries of the path
+// of `callback`, and then dispatches the real events held during the initialization.
+func (w *Watcher) emitInitialEvents(callback *Callback) {
+	var (
+		initializer = callback.initializer
+		exists      = make(map[string]struct{})
+	)
+	if CREATE.Match(callback.options.Ops) {
+		for _, path := range w.scanInitialPaths(callback) {
+			// It stops if the callback is removed during the initialization.
+			if !w.callbackIds.Contains(callback.Id) {
+				break
+			}
+			exists[path] = struct{}{}
+			w.deliverInitial(callback, &Event{
+				event:   fsnotify.Event{Name: path, Op: fsnotify.Create},
+				Path:    path,
+				Op:      CREATE,
+				Watcher: w,
+				initial: true,
+			})
+		}
+	}
+	// The held events are dispatched in batches, as new events might be held during the dispatching.
+	for {
+		initializer.mu.Lock()
+		held := initializer.held
+		initializer.held = nil
+		if len(held) == 0 {
+			initializer.done = true
+		}
+		initializer.mu.Unlock()
+		if len(held) == 0 {
+			return
+		}
+		for _, event := range held {
+			// The CREATE event of the existing entry is the same creation of the synthetic event.
+			if _, ok := exists[event.Path]; ok && event.Op == CREATE {
+				continue
+			}
+			w.deliverInitial(callback, event)
+		}
+	}
+}
+
+// deliverInitial delivers the synthetic or held `event` to `callback` during the initialization.
+// The callback function is called synchronously if it's neither serial nor subscribed, so that
+// the synthetic and held events are delivered in order, and before the events after the
+// initialization. The others keep the events in order by themselves.
+func (w *Watcher) deliverInitial(callback *Callback, event *Event) {
+	if callback.subscription != nil || callback.serial != nil || callback.debouncer != nil {
+		w.dispatchReady(callback, event)
+		return
+	}
+	if !w.acquireWorker() {
+		return
+	}
+	defer w.releaseWorker()
+	w.doCallback(callback, event)
+}
+
+// scanInitialPaths returns the existing entries of the path of `callback` in order, which
+// match the filter of `callback`. It returns the path itself if it's a file.
+func (w *Watcher) scanInitialPaths(callback *Callback) []string {
+	if !fileIsDir(callback.Path) {
+		return []string{callback.Path}
+	}
+	list, err := fileScanDir(callback.Path, "*", callback.recursive)
+	if err != nil {
+		w.handleError(err)
+		return nil
+	}
+	// The entries under the excluded directories are also scanned, which are not matched.
+	paths := list[:0]
+	for _, path := range list {
+		if callback.filter.Match(path, fileIsDir(path)) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
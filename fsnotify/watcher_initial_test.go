@@ -0,0 +1,96 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher_InitialEvents(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t, "a", "s/t/b", ".git/c")
+		z      = filepath.Join(dir, "z")
+		events = newTestSubscription(t, w, dir, SubscribeOptions{
+			WatchOptions: WatchOptions{InitialEvents: true, Exclude: []string{".git/"}},
+		})
+	)
+	for i, want := range []string{"a", "s", "s/t", "s/t/b"} {
+		event := receiveEvent(t, events)
+		// The real event happens after the scanning, which is held until the synthetic events
+		// are dispatched.
+		if i == 0 {
+			if err := os.WriteFile(z, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if event.Path != filepath.Join(dir, want) || event.Op != CREATE || !event.IsInitial() {
+			t.Fatalf(`got %s %v, want initial CREATE of %s`, event.Path, event.Op, want)
+		}
+	}
+	// The real event is dispatched after the synthetic events.
+	if event := receiveEvent(t, events); event.Path != z || event.IsInitial() {
+		t.Fatalf(`got %s, want real event of %s`, event.Path, z)
+	}
+}
+
+func TestWatcher_InitialEventsOrder(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t)
+		z      = filepath.Join(dir, "z")
+		events = make(chan *Event, 100)
+		want   []string
+	)
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%02d", i))
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, path)
+	}
+	// The synthetic events of the plain callback function are delivered in order, and before
+	// the real events.
+	_, err := w.AddWithOptions(dir, func(event *Event) {
+		events <- event
+	}, WatchOptions{InitialEvents: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(want); i++ {
+		event := receiveEvent(t, events)
+		if i == 0 {
+			if err = os.WriteFile(z, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if event.Path != want[i] || !event.IsInitial() {
+			t.Fatalf(`got event %d of %s, initial %v, want initial event of %s`, i, event.Path, event.IsInitial(), want[i])
+		}
+	}
+	if event := receiveEvent(t, events); event.Path != z || event.IsInitial() {
+		t.Fatalf(`got %s, want real event of %s`, event.Path, z)
+	}
+}
+
+func TestWatcher_InitialEventsNoRecursive(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t, "a", "s/b")
+		events = newTestSubscription(t, w, dir, SubscribeOptions{
+			WatchOptions: WatchOptions{InitialEvents: true, NoRecursive: true},
+		})
+	)
+	for _, want := range []string{"a", "s"} {
+		if event := receiveEvent(t, events); event.Path != filepath.Join(dir, want) {
+			t.Fatalf(`got %s, want %s`, event.Path, want)
+		}
+	}
+	expectNoEvent(t, events, 50*time.Millisecond)
+}
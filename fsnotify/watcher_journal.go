@@ -0,0 +1,273 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+	"github.com/gocarp/helpers/intlog"
+	"github.com/gocarp/helpers/json"
+)
+
+// JournalEntry is the record of an event in the journal of watcher.
+type JournalEntry struct {
+	Seq     uint64    `json:"seq"`               // Sequence number, which increases by one for each event.
+	Time    time.Time `json:"time"`              // Time when the event is handled by watcher.
+	Path    string    `json:"path"`              // Absolute file path, which is empty for OVERFLOW event.
+	OldPath string    `json:"oldPath,omitempty"` // Absolute file path before moving, which is only set for MOVE event.
+	Op      Op        `json:"op"`                // File operation.
+}
+
+// journal is the bounded history of the events handled by watcher, which is optionally
+// persisted to file as JSON lines.
+type journal struct {
+	mu      sync.RWMutex    // mu ensures the concurrent safety of the journal.
+	entries []*JournalEntry // entries is the ring buffer of the recent entries.
+	head    int             // head is the index of the oldest entry in entries.
+	count   int             // count is the number of entries in entries.
+	seq     uint64          // seq is the sequence number of the latest entry.
+	path    string          // path is the file persisting the journal, which is empty if it's memory only.
+	file    *os.File        // file is the opened file of path for appending.
+	lines   int             // lines is the number of lines in file, which triggers compaction.
+	closed  bool            // closed marks the journal closed, which records no more entries.
+}
+
+// newJournal creates and returns a journal keeping `size` recent entries.
+// It loads the entries of the file `path` if `path` is not empty, so that the sequence numbers
+// continue across restarts.
+func newJournal(size int, path string) (*journal, error) {
+	j := &journal{
+		entries: make([]*JournalEntry, size),
+		path:    path,
+	}
+	if path == "" {
+		return j, nil
+	}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Record appends `event` to the journal with next sequence number.
+func (j *journal) Record(event *Event) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return nil
+	}
+	j.seq++
+	entry := &JournalEntry{
+		Seq:     j.seq,
+		Time:    time.Now(),
+		Path:    event.Path,
+		OldPath: event.OldPath,
+		Op:      event.Op,
+	}
+	j.append(entry)
+	if j.path == "" {
+		return nil
+	}
+	// The file is reopened by compaction if the last compaction fails, so that the failure
+	// is reported for each entry until the persistence recovers.
+	if j.file == nil {
+		return j.compact()
+	}
+	if err := j.write(j.file, entry); err != nil {
+		return errors.Wrapf(err, `write journal failed for file "%s"`, j.path)
+	}
+	j.lines++
+	// The file keeps at most twice the entries of memory before compaction.
+	if j.lines >= 2*len(j.entries) {
+		return j.compact()
+	}
+	return nil
+}
+
+// Since returns the entries whose sequence numbers are greater than `seq` in order.
+// The sequence number 0 returns all the retained entries.
+// It returns error if some of the entries are already dropped from the journal, in which
+// case the caller should treat the state as unknown and rescan.
+func (j *journal) Since(seq uint64) ([]*JournalEntry, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if seq > j.seq {
+		return nil, errors.NewCodef(
+			codes.CodeInvalidParameter, `sequence %d is ahead of the latest sequence %d`, seq, j.seq,
+		)
+	}
+	oldest := j.seq - uint64(j.count) + 1
+	if seq == 0 {
+		seq = oldest - 1
+	}
+	if seq+1 < oldest {
+		return nil, errors.NewCodef(
+			codes.CodeInvalidOperation, `entries since sequence %d are dropped, the oldest sequence is %d`, seq, oldest,
+		)
+	}
+	var (
+		offset  = int(seq + 1 - oldest)
+		entries = make([]*JournalEntry, 0, j.count-offset)
+	)
+	for i := offset; i < j.count; i++ {
+		entries = append(entries, j.entries[(j.head+i)%len(j.entries)])
+	}
+	return entries, nil
+}
+
+// Seq returns the sequence number of the latest entry, which is 0 if no entry is recorded.
+func (j *journal) Seq() uint64 {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.seq
+}
+
+// Close closes the journal and its file.
+func (j *journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.closed = true
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	if err != nil {
+		return errors.Wrapf(err, `close journal failed for file "%s"`, j.path)
+	}
+	return nil
+}
+
+// append appends `entry` to the ring buffer, which drops the oldest entry if it's full.
+// Note that it should be called with the lock held.
+func (j *journal) append(entry *JournalEntry) {
+	if j.count < len(j.entries) {
+		j.entries[(j.head+j.count)%len(j.entries)] = entry
+		j.count++
+		return
+	}
+	j.entries[j.head] = entry
+	j.head = (j.head + 1) % len(j.entries)
+}
+
+// load reads the entries of the journal file if it exists.
+// The malformed lines, like the last line partially written at crash, are skipped.
+func (j *journal) load() error {
+	file, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, `open journal failed for file "%s"`, j.path)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry *JournalEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry == nil {
+			intlog.Printf(context.TODO(), `skip malformed journal line in file "%s": %s`, j.path, scanner.Text())
+			continue
+		}
+		if entry.Seq <= j.seq {
+			continue
+		}
+		j.seq = entry.Seq
+		j.append(entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return errors.Wrapf(err, `read journal failed for file "%s"`, j.path)
+	}
+	return nil
+}
+
+// compact rewrites the journal file with the entries in memory atomically, and reopens it
+// for appending.
+// Note that it should be called with the lock held, or before the journal is shared.
+func (j *journal) compact() (err error) {
+	if j.file != nil {
+		_ = j.file.Close()
+		j.file = nil
+	}
+	tempPath := j.path + ".tmp"
+	temp, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, `create journal failed for file "%s"`, tempPath)
+	}
+	writer := bufio.NewWriter(temp)
+	for i := 0; i < j.count && err == nil; i++ {
+		err = j.write(writer, j.entries[(j.head+i)%len(j.entries)])
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, j.path)
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return errors.Wrapf(err, `compact journal failed for file "%s"`, j.path)
+	}
+	if j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return errors.Wrapf(err, `open journal failed for file "%s"`, j.path)
+	}
+	j.lines = j.count
+	return nil
+}
+
+// write writes `entry` to `writer` as one JSON line.
+func (j *journal) write(writer interface{ Write(p []byte) (int, error) }, entry *JournalEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(b, '\n'))
+	return err
+}
+
+// Since returns the events handled by the watcher whose sequence numbers are greater than `seq`
+// in order, which can be used by the consumer to catch up the changes while it's busy or restarting.
+// The sequence number 0 reads all the events retained in the journal.
+//
+// It returns error if the journal is not enabled by WatcherConfig.JournalSize, or if some of
+// the events since `seq` are already dropped from the bounded journal, in which case the
+// consumer should treat its state as unknown and rescan.
+func (w *Watcher) Since(seq uint64) ([]*JournalEntry, error) {
+	if w.journal == nil {
+		return nil, errors.NewCode(codes.CodeInvalidOperation, `journal is not enabled`)
+	}
+	return w.journal.Since(seq)
+}
+
+// JournalSeq returns the sequence number of the latest event in the journal, which is 0 if
+// no event is recorded or the journal is not enabled.
+func (w *Watcher) JournalSeq() uint64 {
+	if w.journal == nil {
+		return 0
+	}
+	return w.journal.Seq()
+}
+
+// record records `event` to the journal of watcher if it's enabled.
+func (w *Watcher) record(event *Event) {
+	if w.journal == nil {
+		return
+	}
+	if err := w.journal.Record(event); err != nil {
+		w.handleError(err)
+	}
+}
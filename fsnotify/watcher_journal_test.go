@@ -0,0 +1,207 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordTestJournal records `n` events to `j`, and fails the test on error.
+func recordTestJournal(t *testing.T, j *journal, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := j.Record(&Event{Path: fmt.Sprintf("/%d", i), Op: WRITE}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// journalSeqs returns the sequence numbers of `entries`.
+func journalSeqs(entries []*JournalEntry) []uint64 {
+	seqs := make([]uint64, len(entries))
+	for i, entry := range entries {
+		seqs[i] = entry.Seq
+	}
+	return seqs
+}
+
+// countTestLines returns the number of lines of file `path`.
+func countTestLines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var (
+		lines   int
+		scanner = bufio.NewScanner(file)
+	)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestJournal_Since(t *testing.T) {
+	j, err := newJournal(3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := j.Since(0); err != nil || len(entries) != 0 {
+		t.Fatalf(`got %v %v for empty journal, want nothing`, entries, err)
+	}
+	recordTestJournal(t, j, 5)
+	for seq, want := range map[uint64]string{
+		0: "[3 4 5]",
+		2: "[3 4 5]",
+		4: "[5]",
+		5: "[]",
+	} {
+		entries, err := j.Since(seq)
+		if err != nil {
+			t.Fatalf(`Since(%d) failed: %v`, seq, err)
+		}
+		if got := fmt.Sprint(journalSeqs(entries)); got != want {
+			t.Fatalf(`Since(%d) = %s, want %s`, seq, got, want)
+		}
+	}
+	// The entries since 1 are partially dropped, and 6 is not recorded yet.
+	for _, seq := range []uint64{1, 6} {
+		if _, err = j.Since(seq); err == nil {
+			t.Fatalf(`expected error for Since(%d)`, seq)
+		}
+	}
+}
+
+func TestJournal_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := newJournal(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordTestJournal(t, j, 10)
+	// The file keeps at most twice the entries of memory.
+	if lines := countTestLines(t, path); lines > 4 {
+		t.Fatalf(`got %d lines, want compacted`, lines)
+	}
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The partially written line at crash is skipped.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.WriteString(`{"seq":11,"pa`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	// The sequence numbers continue across restarts.
+	if j, err = newJournal(2, path); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.Seq() != 10 {
+		t.Fatalf(`got sequence %d after restart, want 10`, j.Seq())
+	}
+	recordTestJournal(t, j, 1)
+	entries, err := j.Since(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(journalSeqs(entries)); got != "[10 11]" {
+		t.Fatalf(`got %s after restart, want [10 11]`, got)
+	}
+}
+
+func TestJournal_CompactError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := newJournal(1, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	// The temporary file of compaction cannot be created.
+	if err = os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	recordTestJournal(t, j, 1)
+	if err = j.Record(&Event{Path: "/a", Op: WRITE}); err == nil {
+		t.Fatal(`expected error for failed compaction`)
+	}
+	// The failure is reported until the persistence recovers.
+	if err = j.Record(&Event{Path: "/b", Op: WRITE}); err == nil {
+		t.Fatal(`expected error for the entry after failed compaction`)
+	}
+	if err = os.Remove(path + ".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	recordTestJournal(t, j, 1)
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if j, err = newJournal(1, path); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.Seq() != 4 {
+		t.Fatalf(`got sequence %d of file, want 4`, j.Seq())
+	}
+}
+
+func TestWatcher_Since(t *testing.T) {
+	w, err := NewWithConfig(WatcherConfig{JournalSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var (
+		dir      = newTestDir(t, "a")
+		a        = filepath.Join(dir, "a")
+		events   = newTestSubscription(t, w, dir, SubscribeOptions{})
+		received []*Event
+	)
+	if err = os.Chmod(a, 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err = os.WriteFile(a, []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		event := receiveEvent(t, events)
+		received = append(received, event)
+		if event.Op == WRITE {
+			break
+		}
+	}
+	expectNoEvent(t, events, 50*time.Millisecond)
+	// The dispatched events are recorded in order.
+	if w.JournalSeq() != uint64(len(received)) {
+		t.Fatalf(`got sequence %d, want %d`, w.JournalSeq(), len(received))
+	}
+	entries, err := w.Since(0)
+	if err != nil || len(entries) != len(received) {
+		t.Fatalf(`got %d entries %v, want %d`, len(entries), err, len(received))
+	}
+	for i, entry := range entries {
+		if entry.Seq != uint64(i+1) || entry.Path != received[i].Path || entry.Op != received[i].Op {
+			t.Fatalf(`got entry %d %s %v, want %s %v`, entry.Seq, entry.Path, entry.Op, received[i].Path, received[i].Op)
+		}
+	}
+	if entries, err = w.Since(w.JournalSeq() - 1); err != nil || len(entries) != 1 || entries[0].Op != WRITE {
+		t.Fatalf(`got entries %v %v, want the last WRITE`, entries, err)
+	}
+	w2 := newTestNotifyWatcher(t)
+	if _, err = w2.Since(0); err == nil {
+		t.Fatal(`expected error for disabled journal`)
+	}
+}
@@ -6,15 +6,27 @@ package fsnotify
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
 
 	"github.com/gocarp/codes"
 	"github.com/gocarp/errors"
-	"github.com/gocarp/go/container/list"
+	"github.com/gocarp/go/container/set"
 	"github.com/gocarp/helpers/intlog"
 )
 
 // watchLoop starts the loop for event listening from underlying inotify monitor.
 func (w *Watcher) watchLoop() {
+	// The events of the injecting backend are exact, which are not filtered as repeated.
+	var (
+		flushes <-chan chan struct{}
+		exact   bool
+	)
+	if backend, ok := w.watcher.(injectingBackend); ok {
+		flushes, exact = backend.flushRequests(), true
+	}
 	go func() {
 		for {
 			select {
@@ -23,30 +35,59 @@ func (w *Watcher) watchLoop() {
 				return
 
 			// Event listening.
-			case ev, ok := <-w.watcher.Events:
+			case ev, ok := <-w.watcher.Events():
 				if !ok {
 					return
 				}
+				// Drop the event that no callback subscribes.
+				if !w.isEventSubscribed(ev.Name, Op(ev.Op)) {
+					continue
+				}
+				push := func() {
+					w.renames.Push(&Event{
+						event:   ev,
+						Path:    ev.Name,
+						Op:      Op(ev.Op),
+						Watcher: w,
+					}, w.watcher.RenamedFrom(ev))
+				}
+				if exact {
+					push()
+					continue
+				}
 				// Filter the repeated event in custom duration.
 				_, err := w.cache.SetIfNotExist(
 					context.Background(),
 					ev.String(),
 					func(ctx context.Context) (value interface{}, err error) {
-						w.events.Push(&Event{
-							event:   ev,
-							Path:    ev.Name,
-							Op:      Op(ev.Op),
-							Watcher: w,
-						})
+						push()
 						return struct{}{}, nil
 					}, repeatEventFilterDuration,
 				)
 				if err != nil {
-					intlog.Errorf(context.TODO(), `%+v`, err)
+					w.handleError(err)
 				}
 
-			case err := <-w.watcher.Errors:
-				intlog.Errorf(context.TODO(), `%+v`, err)
+			case err := <-w.watcher.Errors():
+				// The overflow means events lost, which is dispatched to all callbacks.
+				if err == fsnotify.ErrEventOverflow {
+					w.renames.Push(&Event{
+						event:   fsnotify.Event{Op: fsnotify.Op(OVERFLOW)},
+						Op:      OVERFLOW,
+						Watcher: w,
+					}, "")
+					continue
+				}
+				if err != nil {
+					w.handleError(errors.Wrap(err, `watcher error`))
+				}
+
+			// The flushing goes through the queue after the events received before.
+			case done := <-flushes:
+				w.renames.Push(&Event{
+					Watcher: w,
+					flushed: func() { close(done) },
+				}, "")
 			}
 		}
 	}()
@@ -58,8 +99,21 @@ func (w *Watcher) eventLoop() {
 		for {
 			if v := w.events.Pop(); v != nil {
 				event := v.(*Event)
+				if event.flushed != nil {
+					w.flushSubscriptions(event.flushed)
+					continue
+				}
+				if event.IsOverflow() {
+					w.record(event)
+					w.handleOverflow(event)
+					continue
+				}
 				// If there's no any callback of this path, it removes it from monitor.
 				callbacks := w.getCallbacks(event.Path)
+				if event.IsMove() {
+					// The callbacks of the old path are also notified of the moving out.
+					callbacks = w.mergeCallbacks(callbacks, w.getCallbacks(event.OldPath))
+				}
 				if len(callbacks) == 0 {
 					_ = w.watcher.Remove(event.Path)
 					continue
@@ -71,8 +125,8 @@ func (w *Watcher) eventLoop() {
 					if fileExists(event.Path) {
 						// It adds the path back to monitor.
 						// We need no worry about the repeat adding.
-						if err := w.watcher.Add(event.Path); err != nil {
-							intlog.Errorf(context.TODO(), `%+v`, err)
+						if err := w.addWatch(event.Path); err != nil {
+							w.handleReAddError(event.Path, err)
 						} else {
 							intlog.Printf(context.TODO(), "fake remove event, watcher re-adds monitor for: %s", event.Path)
 						}
@@ -87,8 +141,8 @@ func (w *Watcher) eventLoop() {
 					if fileExists(event.Path) {
 						// It might lost the monitoring for the path, so we add the path back to monitor.
 						// We need no worry about the repeat adding.
-						if err := w.watcher.Add(event.Path); err != nil {
-							intlog.Errorf(context.TODO(), `%+v`, err)
+						if err := w.addWatch(event.Path); err != nil {
+							w.handleReAddError(event.Path, err)
 						} else {
 							intlog.Printf(context.TODO(), "fake rename event, watcher re-adds monitor for: %s", event.Path)
 						}
@@ -96,49 +150,42 @@ func (w *Watcher) eventLoop() {
 						event.Op = CHMOD
 					}
 
-				case event.IsCreate():
+				case event.IsCreate() || event.IsMove():
 					// =========================================
 					// Note that it here just adds the path to monitor without any callback registering,
 					// because its parent already has the callbacks.
 					// =========================================
-					if fileIsDir(event.Path) {
-						// If it's a folder, it then does adding recursively to monitor.
-						for _, subPath := range fileAllDirs(event.Path) {
-							if fileIsDir(subPath) {
-								if err := w.watcher.Add(subPath); err != nil {
-									intlog.Errorf(context.TODO(), `%+v`, err)
-								} else {
-									intlog.Printf(context.TODO(), "folder creation event, watcher adds monitor for: %s", subPath)
-								}
-							}
-						}
-					} else {
-						// If it's a file, it directly adds it to monitor.
-						if err := w.watcher.Add(event.Path); err != nil {
-							intlog.Errorf(context.TODO(), `%+v`, err)
-						} else {
-							intlog.Printf(context.TODO(), "file creation event, watcher adds monitor for: %s", event.Path)
-						}
-					}
+					w.addCreatedMonitor(event.Path)
+				}
+				w.record(event)
+				// The path is removed or moved away, with its callbacks and monitor.
+				if event.IsMove() {
+					w.notifyLifecycle(event.OldPath, LifecycleRemoved, nil)
+					w.untrackCreatedMonitor(event.OldPath, callbacks)
+				} else if (event.IsRemove() || event.IsRename()) && !fileExists(event.Path) {
+					w.notifyLifecycle(event.Path, LifecycleRemoved, nil)
+					w.untrackCreatedMonitor(event.Path, callbacks)
 				}
 				// Calling the callbacks in order.
+				var (
+					isDir     = w.eventIsDir(event, callbacks)
+					unchanged = w.isWriteUnchanged(event, callbacks)
+				)
 				for _, callback := range callbacks {
-					go func(callback *Callback) {
-						defer func() {
-							if err := recover(); err != nil {
-								switch err {
-								case callbackExitEventPanicStr:
-									w.RemoveCallback(callback.Id)
-								default:
-									if e, ok := err.(error); ok {
-										panic(errors.WrapCode(codes.CodeInternalPanic, e))
-									}
-									panic(err)
-								}
-							}
-						}()
-						callback.Func(event)
-					}(callback)
+					if callback.snapshot != nil {
+						callback.snapshot.Update(event, isDir)
+					}
+					if !event.Op.Match(callback.options.Ops) {
+						continue
+					}
+					if !callback.filter.Match(event.Path, isDir) &&
+						(event.OldPath == "" || !callback.filter.Match(event.OldPath, isDir)) {
+						continue
+					}
+					if unchanged && callback.options.SuppressUnchangedWrites {
+						continue
+					}
+					w.dispatch(callback, event)
 				}
 			} else {
 				break
@@ -147,41 +194,234 @@ func (w *Watcher) eventLoop() {
 	}()
 }
 
-// getCallbacks searches and returns all callbacks with given `path`.
-// It also searches its parents for callbacks if they're recursive.
-func (w *Watcher) getCallbacks(path string) (callbacks []*Callback) {
-	// Firstly add the callbacks of itself.
-	if v := w.callbacks.Get(path); v != nil {
-		for _, v := range v.(*list.List).FrontAll() {
-			callback := v.(*Callback)
-			callbacks = append(callbacks, callback)
+// eventIsDir checks whether the path of `event` is a directory.
+//
+// The path of REMOVE and RENAME events is already gone, which is a directory if it's known
+// as a directory by the watcher, or by the snapshot of any of `callbacks`.
+func (w *Watcher) eventIsDir(event *Event, callbacks []*Callback) bool {
+	if event.OldPath != "" {
+		w.dirs.Remove(event.OldPath)
+	}
+	if info, err := os.Stat(event.Path); err == nil {
+		if info.IsDir() {
+			w.dirs.Add(event.Path)
+			return true
+		}
+		w.dirs.Remove(event.Path)
+		return false
+	}
+	if w.dirs.Contains(event.Path) {
+		w.dirs.Remove(event.Path)
+		return true
+	}
+	for _, callback := range callbacks {
+		if callback.snapshot != nil && callback.snapshot.IsDir(event.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDirs records `path` and its direct sub-folders as known directories if `path` is a
+// directory added to the monitor, so that the directories are known after they're removed,
+// including the sub-folders not monitored, like the excluded ones.
+func (w *Watcher) recordDirs(path string) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return
+	}
+	w.dirs.Add(path)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			w.dirs.Add(filepath.Join(path, entry.Name()))
+		}
+	}
+}
+
+// handleReAddError reports the failure of re-adding the existing `path` to monitor, and
+// notifies the callbacks bound to `path` that it becomes unwatchable.
+func (w *Watcher) handleReAddError(path string, err error) {
+	err = errors.Wrapf(err, `re-add watch failed for path "%s"`, path)
+	w.handleError(err)
+	w.notifyLifecycle(path, LifecycleUnwatchable, err)
+}
+
+// addCreatedMonitor adds the created `path` to monitor.
+// If it's a folder, it adds all its sub-folders recursively to monitor, except the folders
+// that are excluded by all the callbacks.
+//
+// The added monitors are recorded by the non-recursive callbacks of `path`, which are
+// removed with the callbacks.
+func (w *Watcher) addCreatedMonitor(path string) {
+	var created []*set.StrSet
+	for _, callback := range w.getCallbacks(path) {
+		if callback.created != nil {
+			created = append(created, callback.created)
 		}
 	}
-	// Secondly searches its direct parent for callbacks.
-	// It is special handling here, which is the different between `recursive` and `not recursive` logic
-	// for direct parent folder of `path` that events are from.
-	dirPath := fileDir(path)
-	if v := w.callbacks.Get(dirPath); v != nil {
-		for _, v := range v.(*list.List).FrontAll() {
-			callback := v.(*Callback)
-			callbacks = append(callbacks, callback)
+	track := func(path string) {
+		for _, monitors := range created {
+			monitors.Add(path)
 		}
 	}
-	// Lastly searches all the parents of directory of `path` recursively for callbacks.
-	for {
-		parentDirPath := fileDir(dirPath)
-		if parentDirPath == dirPath {
-			break
+	if fileIsDir(path) {
+		if w.isDirExcluded(path) {
+			return
 		}
-		if v := w.callbacks.Get(parentDirPath); v != nil {
-			for _, v := range v.(*list.List).FrontAll() {
-				callback := v.(*Callback)
-				if callback.recursive {
-					callbacks = append(callbacks, callback)
+		for _, subPath := range fileAllDirs(path, w.isDirExcluded) {
+			if fileIsDir(subPath) {
+				if err := w.addWatch(subPath); err != nil {
+					w.handleError(errors.Wrapf(err, `add watch failed for path "%s"`, subPath))
+				} else {
+					track(subPath)
+					intlog.Printf(context.TODO(), "folder creation event, watcher adds monitor for: %s", subPath)
 				}
 			}
 		}
-		dirPath = parentDirPath
+		return
 	}
-	return
+	// If it's a file, it directly adds it to monitor.
+	if err := w.addWatch(path); err != nil {
+		w.handleError(errors.Wrapf(err, `add watch failed for path "%s"`, path))
+	} else {
+		track(path)
+		intlog.Printf(context.TODO(), "file creation event, watcher adds monitor for: %s", path)
+	}
+}
+
+// untrackCreatedMonitor removes the monitor of the removed `path` from the records of
+// `callbacks`, which needs no removing with the callbacks.
+func (w *Watcher) untrackCreatedMonitor(path string, callbacks []*Callback) {
+	for _, callback := range callbacks {
+		if callback.created != nil {
+			callback.created.Remove(path)
+		}
+	}
+}
+
+// dispatch dispatches `event` to `callback`.
+// The event is held if the callback is emitting the synthetic events of the existing entries.
+func (w *Watcher) dispatch(callback *Callback, event *Event) {
+	if callback.initializer != nil && callback.initializer.Hold(event) {
+		return
+	}
+	w.dispatchReady(callback, event)
+}
+
+// dispatchReady dispatches `event` to `callback`, through its debouncer if debounce is enabled.
+func (w *Watcher) dispatchReady(callback *Callback, event *Event) {
+	if callback.debouncer != nil {
+		callback.debouncer.Push(event)
+		return
+	}
+	w.deliver(callback, event)
+}
+
+// deliver delivers `event` to `callback` immediately.
+// The events are pushed to the subscription in order if the callback is created by Subscribe,
+// or else the callback function is called asynchronously by a worker, in order if the callback
+// is serial. It blocks if all the workers are busy.
+func (w *Watcher) deliver(callback *Callback, event *Event) {
+	if callback.subscription != nil {
+		callback.subscription.Push(event)
+		return
+	}
+	if !w.acquireWorker() {
+		return
+	}
+	if callback.serial != nil {
+		callback.serial.Push(event)
+		return
+	}
+	go func() {
+		defer w.releaseWorker()
+		w.doCallback(callback, event)
+	}()
+}
+
+// doCallback calls the callback function with `event`.
+// It removes the callback from watcher if the callback function calls Exit.
+func (w *Watcher) doCallback(callback *Callback, event *Event) {
+	defer func() {
+		if err := recover(); err != nil {
+			switch err {
+			case callbackExitEventPanicStr:
+				w.RemoveCallback(callback.Id)
+			default:
+				if w.config.ErrorHandler != nil {
+					if e, ok := err.(error); ok {
+						w.handleError(errors.WrapCodef(codes.CodeInternalPanic, e, `callback panics for path "%s"`, event.Path))
+					} else {
+						w.handleError(errors.NewCodef(codes.CodeInternalPanic, `callback panics for path "%s": %+v`, event.Path, err))
+					}
+					return
+				}
+				if e, ok := err.(error); ok {
+					panic(errors.WrapCode(codes.CodeInternalPanic, e))
+				}
+				panic(err)
+			}
+		}
+	}()
+	callback.Func(event)
+}
+
+// isEventSubscribed checks whether the event of `op` on `path` needs queueing.
+// The event is needed if any callback subscribes it, or the watcher needs handling it
+// internally, or there's no callback of `path` that the watcher needs removing its monitor.
+//
+// The callbacks keeping the state of files, like Resync and SuppressUnchangedWrites, need
+// all the events for updating the state, whose operation mask is applied on dispatching.
+func (w *Watcher) isEventSubscribed(path string, op Op) bool {
+	if op&opsInternal != 0 {
+		return true
+	}
+	callbacks := w.getCallbacks(path)
+	if len(callbacks) == 0 {
+		return true
+	}
+	for _, callback := range callbacks {
+		if op.Match(callback.options.Ops) || callback.snapshot != nil || callback.options.SuppressUnchangedWrites {
+			return true
+		}
+	}
+	return false
+}
+
+// isDirExcluded checks whether directory `path` is excluded by all of its callbacks,
+// in which case it needs no monitoring.
+func (w *Watcher) isDirExcluded(path string) bool {
+	callbacks := w.getCallbacks(path)
+	if len(callbacks) == 0 {
+		return false
+	}
+	for _, callback := range callbacks {
+		if !callback.filter.ExcludesDir(path) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeCallbacks appends the callbacks of `others` to `callbacks` that are not in `callbacks`.
+func (w *Watcher) mergeCallbacks(callbacks, others []*Callback) []*Callback {
+	for _, other := range others {
+		found := false
+		for _, callback := range callbacks {
+			if callback.Id == other.Id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			callbacks = append(callbacks, other)
+		}
+	}
+	return callbacks
+}
+
+// getCallbacks searches and returns all callbacks with given `path`.
+// It also searches its parents for callbacks if they're recursive.
+func (w *Watcher) getCallbacks(path string) (callbacks []*Callback) {
+	return w.callbacks.Match(path)
 }
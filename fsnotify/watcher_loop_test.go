@@ -0,0 +1,206 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gocarp/errors"
+)
+
+func TestOp_Match(t *testing.T) {
+	cases := []struct {
+		op   Op
+		ops  Op
+		want bool
+	}{
+		{WRITE, 0, true},
+		{WRITE, WRITE, true},
+		{WRITE | CHMOD, CHMOD, true},
+		{WRITE, CREATE | REMOVE, false},
+		{OVERFLOW, WRITE, false},
+	}
+	for _, c := range cases {
+		if got := c.op.Match(c.ops); got != c.want {
+			t.Errorf(`%v.Match(%v) = %v, want %v`, c.op, c.ops, got, c.want)
+		}
+	}
+}
+
+func TestWatcher_Ops(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var (
+		dir    = newTestDir(t, "a")
+		a      = filepath.Join(dir, "a")
+		b      = filepath.Join(dir, "b")
+		events = make(chan *Event, 100)
+	)
+	_, err = w.AddWithOptions(dir, func(event *Event) {
+		events <- event
+	}, WatchOptions{Ops: CREATE | REMOVE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The events not in the mask are dropped before queueing.
+	if w.isEventSubscribed(a, WRITE) || w.isEventSubscribed(a, CHMOD) {
+		t.Fatal(`unsubscribed events are queued`)
+	}
+	if !w.isEventSubscribed(a, CREATE) || !w.isEventSubscribed(a, REMOVE) {
+		t.Fatal(`subscribed events are not queued`)
+	}
+	if err = os.WriteFile(a, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(b, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); event.Path != b || event.Op != CREATE {
+		t.Fatalf(`got %s %v, want CREATE of %s`, event.Path, event.Op, b)
+	}
+	if err = os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); event.Path != b || event.Op != REMOVE {
+		t.Fatalf(`got %s %v, want REMOVE of %s`, event.Path, event.Op, b)
+	}
+	expectNoEvent(t, events, 50*time.Millisecond)
+}
+
+func TestWatcher_OpsMultipleCallbacks(t *testing.T) {
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	var (
+		dir = newTestDir(t, "a")
+		a   = filepath.Join(dir, "a")
+	)
+	if _, err = w.AddWithOptions(dir, func(event *Event) {}, WatchOptions{Ops: CREATE}); err != nil {
+		t.Fatal(err)
+	}
+	// The event is queued if any of the callbacks subscribes it.
+	if w.isEventSubscribed(a, WRITE) {
+		t.Fatal(`unsubscribed WRITE event is queued`)
+	}
+	callback, err := w.AddWithOptions(dir, func(event *Event) {}, WatchOptions{Ops: WRITE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.isEventSubscribed(a, WRITE) {
+		t.Fatal(`WRITE event subscribed by one callback is not queued`)
+	}
+	w.RemoveCallback(callback.Id)
+	if w.isEventSubscribed(a, WRITE) {
+		t.Fatal(`WRITE event is queued after its callback is removed`)
+	}
+	// The event of the path without callback is queued for removing its monitor.
+	if !w.isEventSubscribed(filepath.Join(newTestDir(t), "x"), WRITE) {
+		t.Fatal(`event of path without callback is not queued`)
+	}
+}
+
+func TestWatcher_RemoveExisting(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t, "a")
+		a          = filepath.Join(dir, "a")
+		events     = newTestSubscription(t, w, dir, SubscribeOptions{})
+	)
+	// The REMOVE event of the existing path is fake, which re-adds the path as RENAME event.
+	emitEvent(t, backend, a, REMOVE)
+	if event := receiveEvent(t, events); event.Path != a || event.Op != RENAME {
+		t.Fatalf(`got %s %v, want RENAME of %s`, event.Path, event.Op, a)
+	}
+	if !backend.IsWatching(a) {
+		t.Fatalf(`existing path %s is not re-added after REMOVE event`, a)
+	}
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	emitEvent(t, backend, a, REMOVE)
+	if event := receiveEvent(t, events); event.Path != a || event.Op != REMOVE {
+		t.Fatalf(`got %s %v, want REMOVE of %s`, event.Path, event.Op, a)
+	}
+}
+
+func TestWatcher_RenameExisting(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t, "a")
+		a          = filepath.Join(dir, "a")
+		events     = newTestSubscription(t, w, dir, SubscribeOptions{})
+	)
+	// The RENAME event of the existing path is like saving by editors, which re-adds the path
+	// as CHMOD event.
+	emitEvent(t, backend, a, RENAME)
+	if event := receiveEvent(t, events); event.Path != a || event.Op != CHMOD {
+		t.Fatalf(`got %s %v, want CHMOD of %s`, event.Path, event.Op, a)
+	}
+	if !backend.IsWatching(a) {
+		t.Fatalf(`existing path %s is not re-added after RENAME event`, a)
+	}
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	emitEvent(t, backend, a, RENAME)
+	if event := receiveEvent(t, events); event.Path != a || event.Op != RENAME {
+		t.Fatalf(`got %s %v, want RENAME of %s`, event.Path, event.Op, a)
+	}
+}
+
+func TestWatcher_ReAddError(t *testing.T) {
+	var (
+		errs       = make(chan error, 1)
+		w, backend = newTestWatcher(t, WatcherConfig{
+			ErrorHandler: func(err error) { errs <- err },
+		})
+		dir    = newTestDir(t, "a")
+		a      = filepath.Join(dir, "a")
+		events = newTestSubscription(t, w, dir, SubscribeOptions{})
+	)
+	backend.SetAddError(a, os.ErrPermission)
+	emitEvent(t, backend, a, REMOVE)
+	if err := receiveError(t, errs); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf(`got error %v, want %v`, err, os.ErrPermission)
+	}
+	if event := receiveEvent(t, events); event.Op != RENAME {
+		t.Fatalf(`got %v, want RENAME`, event.Op)
+	}
+	if backend.IsWatching(a) {
+		t.Fatalf(`path %s is monitored after failed re-adding`, a)
+	}
+}
+
+func TestWatcher_RemoveUncovered(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t)
+		other      = newTestDir(t, "a")
+		a          = filepath.Join(other, "a")
+	)
+	newTestSubscription(t, w, dir, SubscribeOptions{})
+	// The path without any callback is removed from the backend at its first event.
+	if err := backend.Add(a); err != nil {
+		t.Fatal(err)
+	}
+	emitEvent(t, backend, a, WRITE)
+	if err := backend.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if backend.IsWatching(a) {
+		t.Fatalf(`path %s without callback is not removed from backend`, a)
+	}
+	if !backend.IsWatching(dir) {
+		t.Fatalf(`path %s with callback is removed from backend`, dir)
+	}
+}
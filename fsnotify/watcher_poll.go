@@ -0,0 +1,310 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+	"github.com/gocarp/go/timer"
+)
+
+// pollBackend is the backend polling the stats of the monitored paths periodically,
+// which produces the events by comparing the modification time, size, mode and inode.
+type pollBackend struct {
+	mu        sync.Mutex            // mu ensures the concurrent safety of watches.
+	watches   map[string]*pollWatch // watches is the monitored path to its last known state mapping.
+	moves     map[string]string     // moves is the new path to old path mapping of the moving produced by current polling.
+	lastMoves map[string]string     // lastMoves is the moves of last polling, which are dropped in next polling if not paired.
+	events    chan fsnotify.Event   // events is the channel of the produced events.
+	errors    chan error            // errors is the channel of the errors, which is never sent for now.
+	closeChan chan struct{}         // closeChan is used for closing notification.
+	closeOnce sync.Once             // closeOnce ensures the backend is closed only once.
+}
+
+// pollWatch is the last known state of a monitored path.
+type pollWatch struct {
+	state    pollState            // state is the state of the path itself.
+	children map[string]pollState // children is the path to state mapping of direct children if it's a directory.
+}
+
+// pollState is the stat of a path for comparison.
+type pollState struct {
+	modTime time.Time   // Modification time.
+	size    int64       // File size.
+	mode    os.FileMode // File mode.
+	inode   uint64      // Inode number, which is 0 if the system does not support.
+}
+
+const (
+	defaultPollInterval = time.Second // Default interval for polling backend.
+)
+
+func newPollBackend(interval time.Duration) *pollBackend {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	b := &pollBackend{
+		watches:   make(map[string]*pollWatch),
+		moves:     make(map[string]string),
+		events:    make(chan fsnotify.Event),
+		errors:    make(chan error),
+		closeChan: make(chan struct{}),
+	}
+	timer.AddSingleton(context.Background(), interval, b.poll)
+	return b
+}
+
+// Add starts monitoring `path`. It does nothing if `path` is already monitored.
+func (b *pollBackend) Add(path string) error {
+	watch, err := b.stat(path)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.watches[path]; !ok {
+		b.watches[path] = watch
+	}
+	return nil
+}
+
+// Remove stops monitoring `path`.
+func (b *pollBackend) Remove(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.watches[path]; !ok {
+		return errors.NewCodef(codes.CodeNotFound, `path "%s" is not monitored`, path)
+	}
+	delete(b.watches, path)
+	return nil
+}
+
+// Close stops monitoring all paths.
+func (b *pollBackend) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closeChan)
+		b.mu.Lock()
+		b.watches = make(map[string]*pollWatch)
+		b.moves = make(map[string]string)
+		b.lastMoves = nil
+		b.mu.Unlock()
+	})
+	return nil
+}
+
+// Events returns the channel of the raw events.
+func (b *pollBackend) Events() <-chan fsnotify.Event {
+	return b.events
+}
+
+// Errors returns the channel of the errors.
+func (b *pollBackend) Errors() <-chan error {
+	return b.errors
+}
+
+// RenamedFrom returns the old path if `ev` is the CREATE event of moving, or else it returns
+// empty string.
+func (b *pollBackend) RenamedFrom(ev fsnotify.Event) string {
+	if ev.Op&fsnotify.Create == 0 {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if oldPath, ok := b.moves[ev.Name]; ok {
+		delete(b.moves, ev.Name)
+		return oldPath
+	}
+	oldPath := b.lastMoves[ev.Name]
+	delete(b.lastMoves, ev.Name)
+	return oldPath
+}
+
+// poll compares the current stats of all the monitored paths with their last known states,
+// and sends the events of changes in the order of paths.
+func (b *pollBackend) poll(ctx context.Context) {
+	select {
+	case <-b.closeChan:
+		timer.Exit()
+		return
+	default:
+	}
+	b.mu.Lock()
+	paths := make([]string, 0, len(b.watches))
+	for path := range b.watches {
+		paths = append(paths, path)
+	}
+	// The moves that are not paired since last polling are stale, like the CREATE event is
+	// dropped as repeated event by the watcher, which are dropped.
+	b.lastMoves = b.moves
+	b.moves = make(map[string]string)
+	b.mu.Unlock()
+	sort.Strings(paths)
+
+	var (
+		events []fsnotify.Event
+		// The same change might be produced by both the path and its parent directory.
+		produced = make(map[fsnotify.Event]struct{})
+		produce  = func(path string, op fsnotify.Op) {
+			ev := fsnotify.Event{Name: path, Op: op}
+			if _, ok := produced[ev]; !ok {
+				produced[ev] = struct{}{}
+				events = append(events, ev)
+			}
+		}
+		// The removed and created children are paired by inode as moving.
+		removed = make(map[string]pollState)
+		created = make(map[string]pollState)
+	)
+	for _, path := range paths {
+		current, err := b.stat(path)
+		b.mu.Lock()
+		last, ok := b.watches[path]
+		if !ok {
+			// It's removed during the stat.
+			b.mu.Unlock()
+			continue
+		}
+		if err != nil || (last.state.inode != 0 && current.state.inode != last.state.inode) {
+			// The path is removed or replaced, which stops the monitoring like the notification.
+			delete(b.watches, path)
+			b.mu.Unlock()
+			produce(path, fsnotify.Remove)
+			continue
+		}
+		b.watches[path] = current
+		b.mu.Unlock()
+
+		if !current.state.mode.IsDir() {
+			if op := last.state.Compare(current.state); op != 0 {
+				produce(path, op)
+			}
+			continue
+		}
+		for childPath, lastChild := range last.children {
+			if _, ok = current.children[childPath]; !ok {
+				removed[childPath] = lastChild
+			}
+		}
+		for _, childPath := range sortedPollPaths(current.children) {
+			currentChild := current.children[childPath]
+			lastChild, ok := last.children[childPath]
+			if !ok {
+				created[childPath] = currentChild
+				continue
+			}
+			if lastChild.inode != 0 && currentChild.inode != lastChild.inode {
+				// It's replaced by another file, like moving another file to this path.
+				created[childPath] = currentChild
+				continue
+			}
+			if !currentChild.mode.IsDir() {
+				if op := lastChild.Compare(currentChild); op != 0 {
+					produce(childPath, op)
+				}
+			}
+		}
+	}
+	// The moving produces the RENAME event of the old path and the CREATE event of the new
+	// path in adjacency, like the notification.
+	movedFrom := make(map[uint64]string)
+	for childPath, state := range removed {
+		if state.inode != 0 {
+			movedFrom[state.inode] = childPath
+		}
+	}
+	for _, childPath := range sortedPollPaths(created) {
+		state := created[childPath]
+		if oldPath, ok := movedFrom[state.inode]; ok && state.inode != 0 {
+			delete(movedFrom, state.inode)
+			delete(removed, oldPath)
+			produce(oldPath, fsnotify.Rename)
+			produce(childPath, fsnotify.Create)
+			b.mu.Lock()
+			b.moves[childPath] = oldPath
+			b.mu.Unlock()
+			continue
+		}
+		produce(childPath, fsnotify.Create)
+	}
+	for _, childPath := range sortedPollPaths(removed) {
+		produce(childPath, fsnotify.Remove)
+	}
+	for _, ev := range events {
+		select {
+		case b.events <- ev:
+		case <-b.closeChan:
+			return
+		}
+	}
+}
+
+// stat retrieves and returns the current state of `path`, including the states of its direct
+// children if it's a directory.
+func (b *pollBackend) stat(path string) (*pollWatch, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, `stat failed for path "%s"`, path)
+	}
+	watch := &pollWatch{
+		state: newPollState(info),
+	}
+	if !info.IsDir() {
+		return watch, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, `read directory failed for path "%s"`, path)
+	}
+	watch.children = make(map[string]pollState, len(entries))
+	for _, entry := range entries {
+		childInfo, err := entry.Info()
+		if err != nil {
+			// It's removed after reading the directory.
+			continue
+		}
+		watch.children[filepath.Join(path, entry.Name())] = newPollState(childInfo)
+	}
+	return watch, nil
+}
+
+// sortedPollPaths returns the paths of `states` in order.
+func sortedPollPaths(states map[string]pollState) []string {
+	paths := make([]string, 0, len(states))
+	for path := range states {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func newPollState(info os.FileInfo) pollState {
+	return pollState{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		mode:    info.Mode(),
+		inode:   fileInode(info),
+	}
+}
+
+// Compare compares the state with the `current` state of the same file, and returns the
+// operations of the changes, which is 0 if there's no change.
+func (s pollState) Compare(current pollState) (op fsnotify.Op) {
+	if !current.modTime.Equal(s.modTime) || current.size != s.size {
+		op |= fsnotify.Write
+	}
+	if current.mode != s.mode {
+		op |= fsnotify.Chmod
+	}
+	return
+}
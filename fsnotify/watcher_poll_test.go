@@ -0,0 +1,142 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// newTestPollBackend creates and returns a polling backend monitoring `paths`, which is polled
+// only by pollTestBackend.
+func newTestPollBackend(t *testing.T, paths ...string) *pollBackend {
+	t.Helper()
+	b := newPollBackend(time.Hour)
+	t.Cleanup(func() { _ = b.Close() })
+	for _, path := range paths {
+		if err := b.Add(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+// pollTestBackend polls `b` once and returns the produced events.
+func pollTestBackend(b *pollBackend) []fsnotify.Event {
+	var (
+		events []fsnotify.Event
+		done   = make(chan struct{})
+	)
+	go func() {
+		b.poll(context.Background())
+		close(done)
+	}()
+	for {
+		select {
+		case ev := <-b.events:
+			events = append(events, ev)
+		case <-done:
+			return events
+		}
+	}
+}
+
+func TestPollBackend_Order(t *testing.T) {
+	var (
+		dir   = newTestDir(t, "x")
+		names = []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+		b     = newTestPollBackend(t, dir)
+	)
+	for i := len(names) - 1; i >= 0; i-- {
+		if err := os.WriteFile(filepath.Join(dir, names[i]), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var created []string
+	for _, ev := range pollTestBackend(b) {
+		if ev.Op == fsnotify.Create {
+			created = append(created, filepath.Base(ev.Name))
+		}
+	}
+	if len(created) != len(names) {
+		t.Fatalf(`got CREATE events of %v, want %v`, created, names)
+	}
+	for i, name := range names {
+		if created[i] != name {
+			t.Fatalf(`got CREATE events of %v, want in order %v`, created, names)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if events := pollTestBackend(b); len(events) != 0 {
+			t.Fatalf(`got events %v without changes`, events)
+		}
+	}
+}
+
+func TestPollBackend_Move(t *testing.T) {
+	var (
+		dir = newTestDir(t, "a")
+		a   = filepath.Join(dir, "a")
+		z   = filepath.Join(dir, "z")
+		b   = newTestPollBackend(t, dir)
+	)
+	if err := os.Rename(a, z); err != nil {
+		t.Fatal(err)
+	}
+	var create fsnotify.Event
+	for _, ev := range pollTestBackend(b) {
+		switch ev.Name {
+		case a:
+			if ev.Op != fsnotify.Rename {
+				t.Fatalf(`got %v of %s, want RENAME`, ev.Op, a)
+			}
+		case z:
+			create = ev
+		}
+	}
+	if create.Op != fsnotify.Create {
+		t.Fatalf(`got %v of %s, want CREATE`, create.Op, z)
+	}
+	if oldPath := b.RenamedFrom(create); oldPath != a {
+		t.Fatalf(`got old path "%s", want "%s"`, oldPath, a)
+	}
+	if oldPath := b.RenamedFrom(create); oldPath != "" {
+		t.Fatalf(`got old path "%s" of paired moving`, oldPath)
+	}
+}
+
+func TestPollBackend_MoveStale(t *testing.T) {
+	var (
+		dir = newTestDir(t, "a")
+		z   = filepath.Join(dir, "z")
+		b   = newTestPollBackend(t, dir)
+	)
+	if err := os.Rename(filepath.Join(dir, "a"), z); err != nil {
+		t.Fatal(err)
+	}
+	pollTestBackend(b)
+	// The moving is still paired in next polling.
+	pollTestBackend(b)
+	if oldPath := b.RenamedFrom(fsnotify.Event{Name: z, Op: fsnotify.Create}); oldPath == "" {
+		t.Fatal(`moving is dropped in next polling`)
+	}
+	// The moving that is never paired, like its CREATE event is dropped as repeated one, is dropped.
+	if err := os.Rename(z, filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	pollTestBackend(b)
+	pollTestBackend(b)
+	pollTestBackend(b)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.moves) != 0 || len(b.lastMoves) != 0 {
+		t.Fatalf(`got stale moves %v %v`, b.moves, b.lastMoves)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+	"github.com/gocarp/go/container/types"
+	"github.com/gocarp/helpers/intlog"
+)
+
+// Reloader reloads the value decoded from a file whenever the file changes, like a
+// configuration file. It keeps the last good value if the file cannot be read, decoded
+// or validated.
+type Reloader struct {
+	mu        sync.Mutex       // mu ensures the reloading is in serial.
+	errMu     sync.RWMutex     // errMu ensures the concurrent safety of lastErr.
+	config    ReloaderConfig   // config is the configuration of the reloader.
+	value     *types.Interface // value is the last good value, which is swapped atomically.
+	lastErr   error            // lastErr is the error of the last reloading, which is nil if it succeeds.
+	callback  *Callback        // callback is the callback monitoring the file.
+	debouncer *debouncer       // debouncer merges the events of the file in the debounce window.
+}
+
+// ReloaderConfig is the configuration for creating a Reloader.
+type ReloaderConfig struct {
+	// Path is the file to load, which is monitored across the atomic replacements.
+	Path string
+
+	// Decode decodes the content of the file into value. It is required.
+	Decode func(content []byte) (value interface{}, err error)
+
+	// Validate validates the decoded value before swapping, the value is discarded if it
+	// returns error. It is optional.
+	Validate func(value interface{}) error
+
+	// OnReload is called with the new value after it's swapped. It is optional.
+	OnReload func(value interface{})
+
+	// OnError is called with the error of reloading, in which case the last good value is kept.
+	// The errors are only logged internally if it's nil.
+	OnError func(err error)
+
+	// Debounce is the window for merging the changes of the file into one reloading,
+	// default is 100 milliseconds.
+	Debounce time.Duration
+
+	// Watcher is the watcher monitoring the file, default is the default watcher.
+	Watcher *Watcher
+}
+
+const (
+	defaultReloaderDebounce = 100 * time.Millisecond // Default debounce window for Reloader.
+)
+
+// NewReloader creates and returns a Reloader with custom configuration `config`, which loads
+// the file synchronously before returning. It returns error if the first loading fails, as
+// there's no good value to keep.
+func NewReloader(config ReloaderConfig) (*Reloader, error) {
+	if config.Decode == nil {
+		return nil, errors.NewCode(codes.CodeMissingParameter, `Decode of reloader is required`)
+	}
+	if config.Debounce <= 0 {
+		config.Debounce = defaultReloaderDebounce
+	}
+	if config.Watcher == nil {
+		w, err := getDefaultWatcher()
+		if err != nil {
+			return nil, err
+		}
+		config.Watcher = w
+	}
+	r := &Reloader{
+		config: config,
+		value:  types.NewInterface(),
+	}
+	r.debouncer = newDebouncer(config.Debounce, false, func(event *Event) {
+		if err := r.Reload(); err != nil {
+			r.handleError(err)
+		}
+	})
+	// It monitors the file before the first loading, so that no change is missed.
+	callback, err := config.Watcher.WatchFile(config.Path, r.debouncer.Push)
+	if err != nil {
+		return nil, err
+	}
+	r.callback = callback
+	if err = r.Reload(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Value returns the last good value.
+func (r *Reloader) Value() interface{} {
+	return r.value.Val()
+}
+
+// Err returns the error of the last reloading, which is nil if it succeeds.
+func (r *Reloader) Err() error {
+	r.errMu.RLock()
+	defer r.errMu.RUnlock()
+	return r.lastErr
+}
+
+// Reload reads, decodes and validates the file, and swaps the value if all succeed.
+// It keeps the last good value and returns error if any fails.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	value, err := r.load()
+	r.errMu.Lock()
+	r.lastErr = err
+	r.errMu.Unlock()
+	if err != nil {
+		return err
+	}
+	r.value.Set(value)
+	if r.config.OnReload != nil {
+		r.config.OnReload(value)
+	}
+	return nil
+}
+
+// Close stops monitoring the file, the last good value is still available.
+func (r *Reloader) Close() {
+	r.debouncer.Close()
+	r.config.Watcher.RemoveCallback(r.callback.Id)
+}
+
+// load reads, decodes and validates the file, and returns the decoded value.
+func (r *Reloader) load() (value interface{}, err error) {
+	content, err := os.ReadFile(r.config.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, `read file failed for path "%s"`, r.config.Path)
+	}
+	if value, err = r.config.Decode(content); err != nil {
+		return nil, errors.Wrapf(err, `decode file failed for path "%s"`, r.config.Path)
+	}
+	if value == nil {
+		return nil, errors.NewCodef(codes.CodeInvalidParameter, `decoded value is nil for path "%s"`, r.config.Path)
+	}
+	if r.config.Validate != nil {
+		if err = r.config.Validate(value); err != nil {
+			return nil, errors.Wrapf(err, `validate file failed for path "%s"`, r.config.Path)
+		}
+	}
+	return value, nil
+}
+
+// handleError reports `err` of the reloading to OnError, or to the internal logging if
+// OnError is nil.
+func (r *Reloader) handleError(err error) {
+	if r.config.OnError != nil {
+		r.config.OnError(err)
+		return
+	}
+	intlog.Errorf(context.TODO(), `%+v`, err)
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+)
+
+// newTestReloader creates a reloader of file `path` decoding non-negative integer with debounce
+// window `debounce`, and returns the channels of the reloaded values and the errors.
+func newTestReloader(t *testing.T, w *Watcher, path string, debounce time.Duration) (*Reloader, <-chan interface{}, <-chan error) {
+	t.Helper()
+	var (
+		reloaded = make(chan interface{}, 10)
+		errs     = make(chan error, 10)
+	)
+	r, err := NewReloader(ReloaderConfig{
+		Path: path,
+		Decode: func(content []byte) (interface{}, error) {
+			return strconv.Atoi(string(content))
+		},
+		Validate: func(value interface{}) error {
+			if value.(int) < 0 {
+				return errors.New("negative value")
+			}
+			return nil
+		},
+		OnReload: func(value interface{}) { reloaded <- value },
+		OnError:  func(err error) { errs <- err },
+		Debounce: debounce,
+		Watcher:  w,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r, reloaded, errs
+}
+
+// receiveValue receives and returns the next value of `values`, and fails the test if no value
+// is received in time.
+func receiveValue(t *testing.T, values <-chan interface{}) interface{} {
+	t.Helper()
+	select {
+	case value := <-values:
+		return value
+	case <-time.After(testEventTimeout):
+		t.Fatal(`timeout waiting for value`)
+	}
+	return nil
+}
+
+func TestReloader(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t)
+		config     = filepath.Join(dir, "config")
+	)
+	replaceTestFile(t, config, "1")
+	r, reloaded, _ := newTestReloader(t, w, config, 10*time.Millisecond)
+	// The first loading is synchronous.
+	if value := receiveValue(t, reloaded); value != 1 || r.Value() != 1 || r.Err() != nil {
+		t.Fatalf(`got value %v, error %v, want 1`, r.Value(), r.Err())
+	}
+	replaceTestFile(t, config, "2")
+	emitEvent(t, backend, config, CREATE)
+	if value := receiveValue(t, reloaded); value != 2 || r.Value() != 2 || r.Err() != nil {
+		t.Fatalf(`got value %v, error %v, want 2`, r.Value(), r.Err())
+	}
+}
+
+func TestReloader_AtomicSave(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t)
+		config = filepath.Join(dir, "config")
+	)
+	replaceTestFile(t, config, "1")
+	r, reloaded, _ := newTestReloader(t, w, config, 10*time.Millisecond)
+	receiveValue(t, reloaded)
+	// The file replaced by renaming, like the atomic saving of editors, is still monitored.
+	for i := 2; i <= 3; i++ {
+		replaceTestFile(t, config, strconv.Itoa(i))
+		if value := receiveValue(t, reloaded); value != i || r.Value() != i {
+			t.Fatalf(`got value %v, want %d`, r.Value(), i)
+		}
+	}
+}
+
+func TestReloader_Debounce(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t)
+		config     = filepath.Join(dir, "config")
+		debounce   = 50 * time.Millisecond
+	)
+	replaceTestFile(t, config, "1")
+	r, reloaded, _ := newTestReloader(t, w, config, debounce)
+	receiveValue(t, reloaded)
+	// The changes in the debounce window are merged into one reloading of the last content,
+	// and the value is swapped atomically for the concurrent readers.
+	var (
+		done    = make(chan struct{})
+		readErr = make(chan error, 1)
+	)
+	go func() {
+		defer close(readErr)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, ok := r.Value().(int); !ok {
+				readErr <- errors.Newf(`got value %v, want integer`, r.Value())
+				return
+			}
+		}
+	}()
+	for i := 2; i <= 5; i++ {
+		replaceTestFile(t, config, strconv.Itoa(i))
+		emitEvent(t, backend, config, CREATE)
+	}
+	if value := receiveValue(t, reloaded); value != 5 {
+		t.Fatalf(`got value %v, want 5`, value)
+	}
+	select {
+	case value := <-reloaded:
+		t.Fatalf(`unexpected reloading of value %v`, value)
+	case <-time.After(2 * debounce):
+	}
+	close(done)
+	if err := <-readErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader_Invalid(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t)
+		config     = filepath.Join(dir, "config")
+	)
+	replaceTestFile(t, config, "1")
+	r, reloaded, errs := newTestReloader(t, w, config, 10*time.Millisecond)
+	receiveValue(t, reloaded)
+	// The decoding and validating failures keep the last good value.
+	for _, content := range []string{"invalid", "-1"} {
+		replaceTestFile(t, config, content)
+		emitEvent(t, backend, config, CREATE)
+		err := receiveError(t, errs)
+		if err == nil || r.Err() != err || r.Value() != 1 {
+			t.Fatalf(`got value %v, error %v of content %s, want 1 and error`, r.Value(), r.Err(), content)
+		}
+	}
+	replaceTestFile(t, config, "3")
+	emitEvent(t, backend, config, CREATE)
+	if value := receiveValue(t, reloaded); value != 3 || r.Err() != nil {
+		t.Fatalf(`got value %v, error %v, want 3`, r.Value(), r.Err())
+	}
+}
+
+func TestReloader_LoadError(t *testing.T) {
+	var (
+		w, _   = newTestWatcher(t)
+		dir    = newTestDir(t, "config")
+		config = filepath.Join(dir, "config")
+	)
+	if _, err := NewReloader(ReloaderConfig{Path: config, Watcher: w}); !errors.HasCode(err, codes.CodeMissingParameter) {
+		t.Fatalf(`got error %v, want missing Decode`, err)
+	}
+	// The decoded nil value is not good value, which fails the first loading.
+	_, err := NewReloader(ReloaderConfig{
+		Path:    config,
+		Decode:  func(content []byte) (interface{}, error) { return nil, nil },
+		Watcher: w,
+	})
+	if !errors.HasCode(err, codes.CodeInvalidParameter) {
+		t.Fatalf(`got error %v, want nil value error`, err)
+	}
+	if len(w.Callbacks()) != 0 {
+		t.Fatalf(`got %d callbacks after failed loading, want 0`, len(w.Callbacks()))
+	}
+}
+
+func TestReloader_Close(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t)
+		config     = filepath.Join(dir, "config")
+	)
+	replaceTestFile(t, config, "1")
+	r, reloaded, _ := newTestReloader(t, w, config, 10*time.Millisecond)
+	receiveValue(t, reloaded)
+	r.Close()
+	if len(w.Callbacks()) != 0 {
+		t.Fatalf(`got %d callbacks after closing, want 0`, len(w.Callbacks()))
+	}
+	// The changes after closing are not reloaded, and the last good value is still available.
+	replaceTestFile(t, config, "2")
+	emitEvent(t, backend, config, CREATE)
+	if err := backend.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case value := <-reloaded:
+		t.Fatalf(`unexpected reloading of value %v after closing`, value)
+	default:
+	}
+	if r.Value() != 1 {
+		t.Fatalf(`got value %v after closing, want 1`, r.Value())
+	}
+}
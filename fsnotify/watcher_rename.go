@@ -0,0 +1,90 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"sync"
+	"time"
+)
+
+// renameTracker pairs the RENAME event of the old path and the following CREATE event of the
+// new path as one CREATE|MOVE event.
+type renameTracker struct {
+	mu      sync.Mutex         // mu ensures the concurrent safety of pending and closed.
+	pending *Event             // pending is the RENAME event waiting for pairing.
+	timer   *time.Timer        // timer dispatches the pending event if no pairing in duration.
+	push    func(event *Event) // push pushes the event to the queue of watcher.
+	closed  bool               // closed marks the tracker closed, which drops all events.
+}
+
+func newRenameTracker(push func(event *Event)) *renameTracker {
+	return &renameTracker{
+		push: push,
+	}
+}
+
+// Push pushes `event` to the queue of watcher in order.
+//
+// The `oldPath` is the old path if `event` is the CREATE event of moving paired by backend,
+// in which case it pushes `event` as CREATE|MOVE event instead of the pending RENAME event and
+// `event`, so that the moving is still the creating of the new path for the callbacks that
+// only check CREATE.
+// The RENAME event of the path that no longer exists waits for pairing in short duration,
+// and it is pushed as it is if no pairing, like the path is moved out of monitoring.
+func (t *renameTracker) Push(event *Event, oldPath string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	if oldPath != "" && event.IsCreate() {
+		if t.pending != nil && t.pending.Path == oldPath {
+			t.timer.Stop()
+			t.pending = nil
+		} else {
+			t.flush()
+		}
+		event.Op |= MOVE
+		event.OldPath = oldPath
+		t.push(event)
+		return
+	}
+	t.flush()
+	if event.IsRename() && !fileExists(event.Path) {
+		t.pending = event
+		t.timer = time.AfterFunc(renamePairDuration, func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.pending == event && !t.closed {
+				t.flush()
+			}
+		})
+		return
+	}
+	t.push(event)
+}
+
+// Close closes the tracker, which drops the pending event, and the following events are
+// no longer pushed.
+func (t *renameTracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.pending != nil {
+		t.timer.Stop()
+		t.pending = nil
+	}
+}
+
+// flush pushes the pending RENAME event if any.
+// Note that it should be called with the lock held.
+func (t *renameTracker) flush() {
+	if t.pending == nil {
+		return
+	}
+	t.timer.Stop()
+	t.push(t.pending)
+	t.pending = nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatcher_Move(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t, "a")
+		a          = filepath.Join(dir, "a")
+		b          = filepath.Join(dir, "b")
+		creates    = newTestSubscription(t, w, dir, SubscribeOptions{
+			WatchOptions: WatchOptions{Ops: CREATE},
+		})
+		moves = newTestSubscription(t, w, dir, SubscribeOptions{
+			WatchOptions: WatchOptions{Ops: MOVE},
+		})
+	)
+	if err := os.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.EmitMove(a, b); err != nil {
+		t.Fatal(err)
+	}
+	// The moving is still the creating of the new path.
+	for _, events := range []<-chan *Event{creates, moves} {
+		event := receiveEvent(t, events)
+		if event.Path != b || event.OldPath != a || event.Op != CREATE|MOVE {
+			t.Fatalf(`got %s %v from %s, want CREATE|MOVE of %s from %s`, event.Path, event.Op, event.OldPath, b, a)
+		}
+		if !event.IsCreate() || !event.IsMove() {
+			t.Fatal(`moving event is not create and move event`)
+		}
+	}
+	expectFlushed(t, backend, creates)
+	expectFlushed(t, backend, moves)
+}
+
+func TestWatcher_MoveUnpaired(t *testing.T) {
+	var (
+		w, backend = newTestWatcher(t)
+		dir        = newTestDir(t, "a")
+		a          = filepath.Join(dir, "a")
+		events     = newTestSubscription(t, w, dir, SubscribeOptions{})
+	)
+	// The RENAME event of the path moved out of monitoring is dispatched as it is.
+	if err := os.Rename(a, filepath.Join(t.TempDir(), "a")); err != nil {
+		t.Fatal(err)
+	}
+	emitEvent(t, backend, a, RENAME)
+	if event := receiveEvent(t, events); event.Path != a || event.Op != RENAME {
+		t.Fatalf(`got %s %v, want RENAME of %s`, event.Path, event.Op, a)
+	}
+}
+
+func TestWatcher_MoveNotify(t *testing.T) {
+	w, err := NewWithConfig(WatcherConfig{Backend: BackendNotify})
+	if err != nil {
+		t.Skipf(`notification is unavailable: %v`, err)
+	}
+	t.Cleanup(w.Close)
+	var (
+		dir    = newTestDir(t, "a", "sub/x")
+		a      = filepath.Join(dir, "a")
+		b      = filepath.Join(dir, "sub", "b")
+		events = newTestSubscription(t, w, dir, SubscribeOptions{})
+	)
+	if info, err := os.Lstat(a); err != nil || fileInode(info) == 0 {
+		t.Skip(`inode is unavailable`)
+	}
+	if err = os.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		event := receiveEvent(t, events)
+		if event.IsMove() {
+			if event.Path != b || event.OldPath != a || !event.IsCreate() {
+				t.Fatalf(`got %s %v from %s, want CREATE|MOVE of %s from %s`, event.Path, event.Op, event.OldPath, b, a)
+			}
+			return
+		}
+		if event.Path == b {
+			t.Fatalf(`got %v of %s, want CREATE|MOVE`, event.Op, b)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// treeSnapshot is the last known state of the entries under the bound path of a callback,
+// which is used for resynchronization after events are lost.
+type treeSnapshot struct {
+	snapshotScope                           // snapshotScope is the scope of the bound path of the callback.
+	mu            sync.Mutex                // mu ensures the concurrent safety of states and changes.
+	states        map[string]pollState      // states is the path to state mapping of the entries at last scanning.
+	changes       map[string]snapshotChange // changes is the path to the last change mapping of the dispatched events since last scanning.
+}
+
+// snapshotChange is the change of a path by the dispatched event, of which the state is
+// resolved lazily in resynchronization.
+type snapshotChange struct {
+	time    time.Time // time is the time of the event.
+	removed bool      // removed marks the path removed by the event.
+}
+
+func newTreeSnapshot(root string, recursive bool, filter *pathFilter) *treeSnapshot {
+	s := &treeSnapshot{
+		snapshotScope: snapshotScope{
+			root:      root,
+			recursive: recursive,
+			filter:    filter,
+		},
+		changes: make(map[string]snapshotChange),
+	}
+	s.states = s.scan(root)
+	return s
+}
+
+// IsDir checks whether `path` is a directory in the last known state.
+func (s *treeSnapshot) IsDir(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[path]
+	return ok && state.mode.IsDir()
+}
+
+// Update records the change of the paths of `event`, which keeps the snapshot in sync with
+// the dispatched events. The `isDir` specifies whether the path of `event` is a directory.
+//
+// It does no stat of the path, as it's called in the event loop, and the state of the path
+// is resolved in Resync as of the time of `event`.
+func (s *treeSnapshot) Update(event *Event, isDir bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if event.OldPath != "" {
+		s.remove(event.OldPath)
+	}
+	if !s.includes(event.Path, isDir) {
+		return
+	}
+	removed := event.IsRemove() || event.IsRename()
+	if removed {
+		s.remove(event.Path)
+	}
+	s.changes[event.Path] = snapshotChange{
+		time:    time.Now(),
+		removed: removed,
+	}
+}
+
+// Resync rescans the entries, and returns the events of the changes since the last known state.
+func (s *treeSnapshot) Resync() []*Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := s.scan(s.root)
+	events := diffTreeStates(s.resolve(states), states)
+	s.states = states
+	s.changes = make(map[string]snapshotChange)
+	return events
+}
+
+// resolve returns the last known states for comparing with the current `states`, in which
+// the states of the paths changed by the dispatched events are resolved from `states` as of
+// the time of the events.
+//
+// The changed entry that is modified after its event is known as modified at the time of the
+// event, so that the modification is dispatched as missed. The new entry under the changed
+// folder is known as it is if it's not modified after the event of the folder, like the
+// entries of the folder moved in. The other entries are resolved from the last scanning.
+func (s *treeSnapshot) resolve(states map[string]pollState) map[string]pollState {
+	if len(s.changes) == 0 {
+		return s.states
+	}
+	last := make(map[string]pollState, len(s.states))
+	for path, state := range s.states {
+		last[path] = state
+	}
+	for path, change := range s.changes {
+		if _, ok := states[path]; ok || change.removed {
+			continue
+		}
+		// It's created or modified by event, and then removed with the event missed.
+		if _, ok := last[path]; !ok {
+			last[path] = pollState{modTime: change.time}
+		}
+	}
+	for path, state := range states {
+		if change, ok := s.changes[path]; ok {
+			switch {
+			case !state.modTime.After(change.time):
+				last[path] = state
+
+			case change.removed:
+				// It's created again after removed.
+				delete(last, path)
+
+			default:
+				state.modTime = change.time
+				last[path] = state
+			}
+			continue
+		}
+		if _, ok := last[path]; ok {
+			continue
+		}
+		// The entry of the folder created or moved in is known if it's not modified after.
+		if change, ok := s.parentChange(path); ok && !state.modTime.After(change.time) {
+			last[path] = state
+		}
+	}
+	return last
+}
+
+// parentChange returns the change of the nearest changed parent of `path` in the snapshot.
+func (s *treeSnapshot) parentChange(path string) (snapshotChange, bool) {
+	for path != s.root {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		if change, ok := s.changes[parent]; ok {
+			return change, true
+		}
+		path = parent
+	}
+	return snapshotChange{}, false
+}
+
+// remove removes the states and changes of `path` and all its entries.
+func (s *treeSnapshot) remove(path string) {
+	prefix := path + string(filepath.Separator)
+	for p := range s.states {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(s.states, p)
+		}
+	}
+	for p := range s.changes {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(s.changes, p)
+		}
+	}
+}
+
+// handleOverflow dispatches the OVERFLOW event to all callbacks subscribing it, and then
+// dispatches the missed changes to the callbacks which enable resynchronization.
+func (w *Watcher) handleOverflow(event *Event) {
+	for _, callback := range w.allCallbacks() {
+		if OVERFLOW.Match(callback.options.Ops) {
+			w.dispatch(callback, &Event{
+				event:   event.event,
+				Path:    callback.Path,
+				Op:      OVERFLOW,
+				Watcher: w,
+			})
+		}
+		if callback.snapshot == nil {
+			continue
+		}
+		for _, e := range callback.snapshot.Resync() {
+			e.Watcher = w
+			// The folders created during the overflow are not monitored yet.
+			if e.IsCreate() || e.IsMove() {
+				w.addCreatedMonitor(e.Path)
+			}
+			if e.Op.Match(callback.options.Ops) {
+				w.dispatch(callback, e)
+			}
+		}
+	}
+}
+
+// allCallbacks returns all the callbacks of the watcher.
+func (w *Watcher) allCallbacks() []*Callback {
+	return w.callbacks.All()
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// resyncTestEvents returns the events of resynchronizing `s` as relative path of `dir` to
+// event mapping.
+func resyncTestEvents(t *testing.T, s *treeSnapshot, dir string) map[string]*Event {
+	t.Helper()
+	got := make(map[string]*Event)
+	for _, event := range s.Resync() {
+		path, _ := filepath.Rel(dir, event.Path)
+		got[filepath.ToSlash(path)] = event
+	}
+	return got
+}
+
+// emitTestOverflow injects the overflow of the backend to `w`, like the events are lost.
+func emitTestOverflow(w *Watcher) {
+	w.renames.Push(&Event{
+		event:   fsnotify.Event{Op: fsnotify.Op(OVERFLOW)},
+		Op:      OVERFLOW,
+		Watcher: w,
+	}, "")
+}
+
+func TestTreeSnapshot_Resync(t *testing.T) {
+	var (
+		dir = newTestDir(t, "a", "r", "w")
+		s   = newTreeSnapshot(dir, true, nil)
+	)
+	time.Sleep(20 * time.Millisecond)
+	if err := os.MkdirAll(filepath.Join(dir, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "d", "x"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "r")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "w"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The changes without events are all found.
+	got := resyncTestEvents(t, s, dir)
+	if len(got) != 5 {
+		t.Fatalf(`got %d events %v, want 5`, len(got), got)
+	}
+	if e := got["b"]; e == nil || !e.IsMove() || e.OldPath != filepath.Join(dir, "a") {
+		t.Fatalf(`got %v, want MOVE of b from a`, e)
+	}
+	for path, op := range map[string]Op{"d": CREATE, "d/x": CREATE, "r": REMOVE, "w": WRITE} {
+		if e := got[path]; e == nil || e.Op != op {
+			t.Fatalf(`got %v for %s, want %v`, e, path, op)
+		}
+	}
+	// The snapshot is in sync after resynchronization.
+	if got = resyncTestEvents(t, s, dir); len(got) != 0 {
+		t.Fatalf(`got %v after resynchronization, want none`, got)
+	}
+}
+
+func TestTreeSnapshot_Update(t *testing.T) {
+	var (
+		dir     = newTestDir(t, "a", "b", "r")
+		outside = newTestDir(t, "m/y")
+		s       = newTreeSnapshot(dir, true, nil)
+		update  = func(path string, op Op, isDir bool) {
+			s.Update(&Event{Path: path, Op: op}, isDir)
+		}
+	)
+	time.Sleep(20 * time.Millisecond)
+	// The changes of dispatched events are not found again.
+	if err := os.WriteFile(filepath.Join(dir, "c"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	update(filepath.Join(dir, "c"), CREATE, false)
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	update(filepath.Join(dir, "a"), WRITE, false)
+	if err := os.Remove(filepath.Join(dir, "r")); err != nil {
+		t.Fatal(err)
+	}
+	update(filepath.Join(dir, "r"), REMOVE, false)
+	// The entries of the folder moved in are not created.
+	if err := os.Rename(filepath.Join(outside, "m"), filepath.Join(dir, "m")); err != nil {
+		t.Fatal(err)
+	}
+	s.Update(&Event{
+		Path:    filepath.Join(dir, "m"),
+		OldPath: filepath.Join(outside, "m"),
+		Op:      CREATE | MOVE,
+	}, true)
+	// The write to file b after its event is missed.
+	if err := os.WriteFile(filepath.Join(dir, "b"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	update(filepath.Join(dir, "b"), WRITE, false)
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(dir, "b"), []byte("changed again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := resyncTestEvents(t, s, dir)
+	if e := got["b"]; len(got) != 1 || e == nil || e.Op != WRITE {
+		t.Fatalf(`got %v, want WRITE of b`, got)
+	}
+}
+
+func TestTreeSnapshot_UpdateChmod(t *testing.T) {
+	var (
+		dir = newTestDir(t, "a")
+		a   = filepath.Join(dir, "a")
+		s   = newTreeSnapshot(dir, true, nil)
+	)
+	time.Sleep(20 * time.Millisecond)
+	// The mode change of the dispatched CHMOD event is not found again with the missed write.
+	if err := os.Chmod(a, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.Update(&Event{Path: a, Op: CHMOD}, false)
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(a, []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got := resyncTestEvents(t, s, dir)
+	if e := got["a"]; len(got) != 1 || e == nil || e.Op != WRITE {
+		t.Fatalf(`got %v, want WRITE of a`, got)
+	}
+}
+
+func TestWatcher_Resync(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t, "a")
+		a      = filepath.Join(dir, "a")
+		events = newTestSubscription(t, w, dir, SubscribeOptions{
+			WatchOptions: WatchOptions{Ops: WRITE | OVERFLOW, Resync: true},
+		})
+	)
+	// The events not subscribed still update the state for resynchronization.
+	if !w.isEventSubscribed(a, CHMOD) {
+		t.Fatal(`CHMOD event is dropped for the callback keeping state`)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := os.WriteFile(a, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if event := receiveEvent(t, events); event.Path != a || event.Op != WRITE {
+		t.Fatalf(`got %s %v, want WRITE of %s`, event.Path, event.Op, a)
+	}
+	// The dispatched changes are not dispatched again after the overflow.
+	time.Sleep(20 * time.Millisecond)
+	emitTestOverflow(w)
+	if event := receiveEvent(t, events); !event.IsOverflow() || event.Path != dir {
+		t.Fatalf(`got %s %v, want OVERFLOW of %s`, event.Path, event.Op, dir)
+	}
+	expectNoEvent(t, events, 50*time.Millisecond)
+}
+
+func TestWatcher_OverflowOps(t *testing.T) {
+	var (
+		w       = newTestNotifyWatcher(t)
+		dir     = newTestDir(t)
+		all     = newTestSubscription(t, w, dir, SubscribeOptions{})
+		creates = newTestSubscription(t, w, dir, SubscribeOptions{
+			WatchOptions: WatchOptions{Ops: CREATE},
+		})
+	)
+	emitTestOverflow(w)
+	if event := receiveEvent(t, all); !event.IsOverflow() {
+		t.Fatalf(`got %v, want OVERFLOW`, event.Op)
+	}
+	// The OVERFLOW event is not dispatched to the callback not subscribing it.
+	expectNoEvent(t, creates, 50*time.Millisecond)
+}
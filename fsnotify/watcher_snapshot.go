@@ -0,0 +1,202 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/gocarp/codes"
+	"github.com/gocarp/errors"
+)
+
+// Snapshot is the state of a path and its entries at a point in time, which is immutable.
+// The changes between two snapshots can be computed by Diff, independently of the watcher.
+type Snapshot struct {
+	Root   string               // Root is the path of the snapshot (absolute).
+	Time   time.Time            // Time is the time when the snapshot is taken.
+	states map[string]pollState // states is the path to state mapping of the entries.
+}
+
+// snapshotScope is the scope of the entries in a snapshot.
+type snapshotScope struct {
+	root      string      // root is the path of the snapshot.
+	recursive bool        // recursive specifies whether the sub-folders are included.
+	filter    *pathFilter // filter is the path filter of the entries.
+}
+
+// NewSnapshot takes and returns the snapshot of `path` and its entries.
+// The NoRecursive, Include and Exclude of `options` specify the entries in the snapshot as
+// the same as adding `path` to watcher, and the other options are ignored.
+func NewSnapshot(path string, options WatchOptions) (*Snapshot, error) {
+	root := fileRealPath(path)
+	if root == "" {
+		return nil, errors.NewCodef(codes.CodeInvalidParameter, `"%s" does not exist`, path)
+	}
+	filter, err := newPathFilter(root, options.Include, options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	scope := snapshotScope{
+		root:      root,
+		recursive: !options.NoRecursive,
+		filter:    filter,
+	}
+	return &Snapshot{
+		Root:   root,
+		Time:   time.Now(),
+		states: scope.scan(root),
+	}, nil
+}
+
+// Len returns the number of the entries in the snapshot, including the root.
+func (s *Snapshot) Len() int {
+	return len(s.states)
+}
+
+// Contains checks whether `path` is in the snapshot.
+func (s *Snapshot) Contains(path string) bool {
+	_, ok := s.states[path]
+	return ok
+}
+
+// Paths returns the paths of all the entries in the snapshot in order, including the root.
+func (s *Snapshot) Paths() []string {
+	paths := make([]string, 0, len(s.states))
+	for path := range s.states {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Diff compares the snapshots `from` and `to`, and returns the events of the changes from
+// `from` to `to`: CREATE for the added entries, REMOVE for the removed entries, WRITE or
+// CHMOD for the modified files, and CREATE|MOVE for the entries added or replaced by the
+// removed entries of the same inode, like the atomic saving of a file by renaming.
+//
+// The events are ordered as MOVE, CREATE, WRITE/CHMOD and REMOVE events, each in path order,
+// except that the REMOVE events of entries are ordered before their parents.
+// The Watcher of the events is nil.
+func Diff(from, to *Snapshot) []*Event {
+	return diffTreeStates(from.states, to.states)
+}
+
+// scan returns the states of `path` and its entries that match the snapshot.
+func (s snapshotScope) scan(path string) map[string]pollState {
+	states := make(map[string]pollState)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return states
+	}
+	states[path] = newPollState(info)
+	if !info.IsDir() {
+		return states
+	}
+	paths, _ := fileScanDir(path, "*", s.recursive)
+	for _, subPath := range paths {
+		if !s.contains(subPath) {
+			continue
+		}
+		if subInfo, err := os.Lstat(subPath); err == nil {
+			states[subPath] = newPollState(subInfo)
+		}
+	}
+	return states
+}
+
+// contains checks whether `path` is in the scope of the snapshot.
+func (s snapshotScope) contains(path string) bool {
+	return s.includes(path, fileIsDir(path))
+}
+
+// includes checks whether `path` is in the scope of the snapshot, in which `isDir` specifies
+// whether `path` is a directory.
+func (s snapshotScope) includes(path string, isDir bool) bool {
+	if path == s.root {
+		return true
+	}
+	if !strings.HasPrefix(path, s.root+string(filepath.Separator)) {
+		return false
+	}
+	if !s.recursive && filepath.Dir(path) != s.root {
+		return false
+	}
+	return s.filter.Match(path, isDir)
+}
+
+// diffTreeStates compares the `oldStates` and `newStates`, and returns the events of the
+// changes, see Diff.
+func diffTreeStates(oldStates, newStates map[string]pollState) []*Event {
+	var (
+		moves, creates, changes, removes []*Event
+		movedFrom                        = make(map[uint64]string)
+		moved                            = make(map[string]struct{})
+		newEvent                         = func(path string, op Op) *Event {
+			return &Event{
+				event: fsnotify.Event{Name: path, Op: fsnotify.Op(op)},
+				Path:  path,
+				Op:    op,
+			}
+		}
+	)
+	for path, state := range oldStates {
+		if _, ok := newStates[path]; !ok && state.inode != 0 {
+			movedFrom[state.inode] = path
+		}
+	}
+	for path, state := range newStates {
+		oldState, ok := oldStates[path]
+		switch {
+		case !ok, oldState.inode != 0 && state.inode != oldState.inode:
+			// It's added, or replaced by another file.
+			if oldPath, ok := movedFrom[state.inode]; ok && state.inode != 0 {
+				delete(movedFrom, state.inode)
+				moved[oldPath] = struct{}{}
+				event := newEvent(path, CREATE|MOVE)
+				event.event.Op = fsnotify.Create
+				event.OldPath = oldPath
+				moves = append(moves, event)
+				continue
+			}
+			creates = append(creates, newEvent(path, CREATE))
+
+		case !state.mode.IsDir():
+			if op := oldState.Compare(state); op != 0 {
+				changes = append(changes, newEvent(path, Op(op)))
+			}
+		}
+	}
+	for path := range oldStates {
+		if _, ok := newStates[path]; ok {
+			continue
+		}
+		if _, ok := moved[path]; !ok {
+			removes = append(removes, newEvent(path, REMOVE))
+		}
+	}
+	sortEvents := func(events []*Event, reverse bool) {
+		sort.Slice(events, func(i, j int) bool {
+			if reverse {
+				return events[i].Path > events[j].Path
+			}
+			return events[i].Path < events[j].Path
+		})
+	}
+	sortEvents(moves, false)
+	sortEvents(creates, false)
+	sortEvents(changes, false)
+	sortEvents(removes, true)
+	events := make([]*Event, 0, len(moves)+len(creates)+len(changes)+len(removes))
+	events = append(events, moves...)
+	events = append(events, creates...)
+	events = append(events, changes...)
+	return append(events, removes...)
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestSnapshot takes the snapshot of `path` with `options`, which fails the test on error.
+func newTestSnapshot(t *testing.T, path string, options WatchOptions) *Snapshot {
+	t.Helper()
+	snapshot, err := NewSnapshot(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+// diffTestEvents returns the events of Diff as the relative path to operation mapping, and the
+// relative paths in order of the events.
+func diffTestEvents(from, to *Snapshot) (ops map[string]Op, paths []string) {
+	ops = make(map[string]Op)
+	for _, event := range Diff(from, to) {
+		path, _ := filepath.Rel(to.Root, event.Path)
+		ops[filepath.ToSlash(path)] = event.Op
+		paths = append(paths, filepath.ToSlash(path))
+	}
+	return
+}
+
+func TestNewSnapshot(t *testing.T) {
+	dir := newTestDir(t, "a", "s/b", "s/t/c", "skip/d")
+	snapshot := newTestSnapshot(t, dir, WatchOptions{Exclude: []string{"skip/"}})
+	want := []string{dir}
+	for _, name := range []string{"a", "s", "s/b", "s/t", "s/t/c"} {
+		want = append(want, filepath.Join(dir, filepath.FromSlash(name)))
+	}
+	if got := snapshot.Paths(); !reflect.DeepEqual(got, want) || snapshot.Len() != len(want) {
+		t.Fatalf(`got paths %v, want %v`, got, want)
+	}
+	if snapshot.Contains(filepath.Join(dir, "skip")) {
+		t.Fatal(`excluded folder is in snapshot`)
+	}
+	snapshot = newTestSnapshot(t, dir, WatchOptions{NoRecursive: true})
+	if snapshot.Len() != 4 || snapshot.Contains(filepath.Join(dir, "s", "b")) {
+		t.Fatalf(`got paths %v, want direct entries only`, snapshot.Paths())
+	}
+	if _, err := NewSnapshot(filepath.Join(dir, "none"), WatchOptions{}); err == nil {
+		t.Fatal(`snapshot of path not existing returns no error`)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	var (
+		dir  = newTestDir(t, "a", "w", "m", "r/x", "r/y/z")
+		from = newTestSnapshot(t, dir, WatchOptions{})
+	)
+	if err := os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "w"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "m"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "c"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "r")); err != nil {
+		t.Fatal(err)
+	}
+	to := newTestSnapshot(t, dir, WatchOptions{})
+	ops, paths := diffTestEvents(from, to)
+	wantOps := map[string]Op{
+		"b": CREATE | MOVE, "c": CREATE, "m": CHMOD, "w": WRITE,
+		"r/y/z": REMOVE, "r/y": REMOVE, "r/x": REMOVE, "r": REMOVE,
+	}
+	if !reflect.DeepEqual(ops, wantOps) {
+		t.Fatalf(`got events %v, want %v`, ops, wantOps)
+	}
+	wantPaths := []string{"b", "c", "m", "w", "r/y/z", "r/y", "r/x", "r"}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Fatalf(`got events in order %v, want %v`, paths, wantPaths)
+	}
+	events := Diff(from, to)
+	if !events[0].IsCreate() || !events[0].IsMove() || events[0].OldPath != filepath.Join(dir, "a") {
+		t.Fatalf(`got %s %v from %s, want MOVE from %s`, events[0].Path, events[0].Op, events[0].OldPath, filepath.Join(dir, "a"))
+	}
+	if len(Diff(to, to)) != 0 {
+		t.Fatal(`got events between the same snapshots, want none`)
+	}
+}
+
+func TestDiff_AtomicSave(t *testing.T) {
+	var (
+		dir    = newTestDir(t, "config", "config.tmp")
+		config = filepath.Join(dir, "config")
+		tmp    = filepath.Join(dir, "config.tmp")
+		from   = newTestSnapshot(t, dir, WatchOptions{})
+	)
+	// The file is replaced by renaming the temporary file of the same folder.
+	if err := os.Rename(tmp, config); err != nil {
+		t.Fatal(err)
+	}
+	events := Diff(from, newTestSnapshot(t, dir, WatchOptions{}))
+	if len(events) != 1 {
+		t.Fatalf(`got %d events, want 1`, len(events))
+	}
+	if event := events[0]; event.Path != config || event.Op != CREATE|MOVE || event.OldPath != tmp {
+		t.Fatalf(`got %s %v from %s, want MOVE from %s`, event.Path, event.Op, event.OldPath, tmp)
+	}
+	// The file replaced by the file not in the snapshot is created.
+	from = newTestSnapshot(t, dir, WatchOptions{})
+	replaceTestFile(t, config, "replaced")
+	ops, _ := diffTestEvents(from, newTestSnapshot(t, dir, WatchOptions{}))
+	if !reflect.DeepEqual(ops, map[string]Op{"config": CREATE}) {
+		t.Fatalf(`got events %v, want CREATE of config`, ops)
+	}
+}
+
+func TestDiff_MoveDir(t *testing.T) {
+	var (
+		dir  = newTestDir(t, "s/b", "s/t/c")
+		from = newTestSnapshot(t, dir, WatchOptions{})
+	)
+	// The entries of the moved folder are moved by their inodes as well.
+	if err := os.Rename(filepath.Join(dir, "s"), filepath.Join(dir, "u")); err != nil {
+		t.Fatal(err)
+	}
+	events := Diff(from, newTestSnapshot(t, dir, WatchOptions{}))
+	var got []string
+	for _, event := range events {
+		if !event.IsMove() {
+			t.Fatalf(`got %s %v, want MOVE`, event.Path, event.Op)
+		}
+		oldPath, _ := filepath.Rel(dir, event.OldPath)
+		path, _ := filepath.Rel(dir, event.Path)
+		got = append(got, filepath.ToSlash(oldPath)+"->"+filepath.ToSlash(path))
+	}
+	want := []string{"s->u", "s/b->u/b", "s/t->u/t", "s/t/c->u/t/c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf(`got moves %v, want %v`, got, want)
+	}
+}
+
+func TestDiff_Scope(t *testing.T) {
+	var (
+		dir     = newTestDir(t, "a", "skip/b", "s/c")
+		options = WatchOptions{Exclude: []string{"skip/"}, NoRecursive: true}
+		from    = newTestSnapshot(t, dir, options)
+	)
+	// The changes out of the snapshot scope are not found.
+	if err := os.WriteFile(filepath.Join(dir, "skip", "b"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "s", "d"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "e"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ops, _ := diffTestEvents(from, newTestSnapshot(t, dir, options))
+	if !reflect.DeepEqual(ops, map[string]Op{"e": CREATE}) {
+		t.Fatalf(`got events %v, want CREATE of e`, ops)
+	}
+}
+
+func TestDiff_Resync(t *testing.T) {
+	var (
+		dir  = newTestDir(t, "a", "r", "w", "s/x")
+		from = newTestSnapshot(t, dir, WatchOptions{})
+		tree = newTreeSnapshot(dir, true, nil)
+	)
+	if err := os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "w"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "s", "y"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// It's removed after the creating, so that its inode is not reused by the created file.
+	if err := os.Remove(filepath.Join(dir, "r")); err != nil {
+		t.Fatal(err)
+	}
+	// The resynchronization of watcher finds the same changes as Diff.
+	var (
+		got  []string
+		want []string
+	)
+	for _, event := range tree.Resync() {
+		got = append(got, fmt.Sprintf("%s %v", event.Path, event.Op))
+	}
+	for _, event := range Diff(from, newTestSnapshot(t, dir, WatchOptions{})) {
+		want = append(want, fmt.Sprintf("%s %v", event.Path, event.Op))
+	}
+	if len(want) != 4 || !reflect.DeepEqual(got, want) {
+		t.Fatalf(`got resynchronized events %v, want %v`, got, want)
+	}
+}
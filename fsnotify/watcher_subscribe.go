@@ -0,0 +1,222 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// SubscribeOptions is the options for subscribing the events of a path.
+type SubscribeOptions struct {
+	WatchOptions
+
+	// BufferSize is the maximum number of events buffered for the subscriber, default is 64.
+	BufferSize int
+
+	// Overflow is the policy when the buffer is full, default is OverflowDropOldest.
+	Overflow OverflowPolicy
+}
+
+// OverflowPolicy is the policy for a subscription when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest drops the oldest buffered event for the new event.
+	OverflowDropOldest OverflowPolicy = iota
+
+	// OverflowBlock blocks the event dispatching of the watcher until the subscriber receives.
+	// Be very note that, it also blocks the other callbacks of the watcher.
+	OverflowBlock
+
+	// OverflowCoalesce merges the new event into the buffered event of the same path, whose
+	// Op is the union of both events. It drops the oldest buffered event if there's no buffered
+	// event of the same path.
+	OverflowCoalesce
+)
+
+const (
+	defaultSubscribeBufferSize = 64
+)
+
+// subscription delivers the events to the subscriber channel in order with bounded buffering.
+type subscription struct {
+	mu       sync.Mutex     // mu ensures the concurrent safety of buffer and closed.
+	cond     *sync.Cond     // cond notifies the changes of buffer and closed.
+	buffer   []*Event       // buffer is the events waiting for delivering.
+	size     int            // size is the maximum number of buffered events.
+	overflow OverflowPolicy // overflow is the policy when the buffer is full.
+	events   chan *Event    // events is the channel that the subscriber receives from.
+	done     chan struct{}  // done is closed when the subscription is closed.
+	once     sync.Once      // once ensures the subscription is closed only once.
+	closed   bool           // closed marks the subscription closed, which drops all buffered events.
+}
+
+// Subscribe monitors `path` with custom options `options` to the watcher, and returns the
+// channel receiving the events and the function cancelling the subscription.
+//
+// Different from the callback functions, the events are delivered to the channel in order,
+// and the events are buffered at most `BufferSize` for the subscriber, and the `Overflow`
+// policy takes effect if the buffer is full. The channel is closed after cancelling or the
+// watcher is closed.
+func (w *Watcher) Subscribe(path string, options SubscribeOptions) (events <-chan *Event, cancel func(), err error) {
+	if options.BufferSize <= 0 {
+		options.BufferSize = defaultSubscribeBufferSize
+	}
+	s := newSubscription(options.BufferSize, options.Overflow)
+	callback, err := w.addOnceWithOptions("", path, nil, options.WatchOptions, s)
+	if err != nil {
+		if callback != nil {
+			w.RemoveCallback(callback.Id)
+		}
+		s.Close()
+		return nil, nil, err
+	}
+	return s.events, func() {
+		w.RemoveCallback(callback.Id)
+	}, nil
+}
+
+// flushSubscriptions calls `flushed` after the subscribers receive all the events pushed to
+// the subscriptions before.
+func (w *Watcher) flushSubscriptions(flushed func()) {
+	var wg sync.WaitGroup
+	for _, callback := range w.allCallbacks() {
+		if callback.subscription != nil {
+			wg.Add(1)
+			callback.subscription.Flush(wg.Done)
+		}
+	}
+	go func() {
+		wg.Wait()
+		flushed()
+	}()
+}
+
+func newSubscription(size int, overflow OverflowPolicy) *subscription {
+	s := &subscription{
+		buffer:   make([]*Event, 0, size),
+		size:     size,
+		overflow: overflow,
+		events:   make(chan *Event),
+		done:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.pump()
+	return s
+}
+
+// Push adds `event` to the buffer of the subscription.
+func (s *subscription) Push(event *Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if len(s.buffer) >= s.size {
+		switch s.overflow {
+		case OverflowBlock:
+			for len(s.buffer) >= s.size && !s.closed {
+				s.cond.Wait()
+			}
+			if s.closed {
+				return
+			}
+
+		case OverflowCoalesce:
+			for i := len(s.buffer) - 1; i >= 0; i-- {
+				if s.buffer[i].Path == event.Path && s.buffer[i].flushed == nil {
+					// It merges into a copy, as the buffered event is shared by multiple callbacks.
+					e := *s.buffer[i]
+					e.Op |= event.Op
+					e.event.Op |= fsnotify.Op(event.Op)
+					s.buffer[i] = &e
+					return
+				}
+			}
+			s.dropOldest()
+
+		default:
+			s.dropOldest()
+		}
+	}
+	s.buffer = append(s.buffer, event)
+	s.cond.Broadcast()
+}
+
+// Flush calls `flushed` after the subscriber receives all the events pushed before, which is
+// not limited by the buffer size. It calls `flushed` at once if the subscription is closed.
+func (s *subscription) Flush(flushed func()) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		flushed()
+		return
+	}
+	s.buffer = append(s.buffer, &Event{flushed: flushed})
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// dropOldest drops the oldest buffered event. The flushing is done if it's the oldest, as the
+// events before are received.
+// Note that it should be called with the lock held.
+func (s *subscription) dropOldest() {
+	oldest := s.buffer[0]
+	s.buffer = s.buffer[1:]
+	if oldest.flushed != nil {
+		oldest.flushed()
+	}
+}
+
+// Close closes the subscription, which drops all buffered events and closes the channel.
+// The buffered flushing is done, as no more events are received.
+func (s *subscription) Close() {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		for _, event := range s.buffer {
+			if event.flushed != nil {
+				event.flushed()
+			}
+		}
+		s.buffer = nil
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		close(s.done)
+	})
+}
+
+// pump sends the buffered events to the channel in order until the subscription is closed.
+func (s *subscription) pump() {
+	defer close(s.events)
+	for {
+		s.mu.Lock()
+		for len(s.buffer) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		event := s.buffer[0]
+		s.buffer = s.buffer[1:]
+		// It notifies the blocked pushing that the buffer has room.
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		// The flushing is not delivered, which is done as the events before are received.
+		if event.flushed != nil {
+			event.flushed()
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestNotifyWatcher creates and returns a watcher on the real backend, which is closed
+// after the test.
+func newTestNotifyWatcher(t *testing.T) *Watcher {
+	t.Helper()
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Close)
+	return w
+}
+
+func TestWatcher_Subscribe(t *testing.T) {
+	var (
+		w   = newTestNotifyWatcher(t)
+		dir = newTestDir(t)
+	)
+	events, cancel, err := w.Subscribe(dir, SubscribeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = os.WriteFile(filepath.Join(dir, fmt.Sprint(i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The events are received in order.
+	for i := 0; i < 10; {
+		event := receiveEvent(t, events)
+		if !event.IsCreate() {
+			continue
+		}
+		if event.Path != filepath.Join(dir, fmt.Sprint(i)) {
+			t.Fatalf(`got creating %d of %s, want in order`, i, event.Path)
+		}
+		i++
+	}
+	// The channel is closed after cancelling.
+	cancel()
+	for {
+		select {
+		case _, ok := <-events:
+			if ok {
+				continue
+			}
+		case <-time.After(testEventTimeout):
+			t.Fatal(`channel is not closed after cancelling`)
+		}
+		break
+	}
+	if len(w.Callbacks()) != 0 {
+		t.Fatal(`callback is not removed after cancelling`)
+	}
+}
+
+func TestWatcher_SubscribeClose(t *testing.T) {
+	var (
+		w      = newTestNotifyWatcher(t)
+		dir    = newTestDir(t)
+		events = newTestSubscription(t, w, dir, SubscribeOptions{})
+	)
+	w.Close()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal(`got event after closing`)
+		}
+	case <-time.After(testEventTimeout):
+		t.Fatal(`channel is not closed after closing watcher`)
+	}
+}
+
+func TestWatcher_SubscribeError(t *testing.T) {
+	w := newTestNotifyWatcher(t)
+	if _, _, err := w.Subscribe(filepath.Join(t.TempDir(), "none"), SubscribeOptions{}); err == nil {
+		t.Fatal(`expected error for absent path`)
+	}
+	if len(w.Callbacks()) != 0 {
+		t.Fatal(`callback is left after failed subscribing`)
+	}
+}
+
+func TestSubscription_Order(t *testing.T) {
+	s := newSubscription(4, OverflowBlock)
+	defer s.Close()
+	go func() {
+		for i := 0; i < 100; i++ {
+			s.Push(&Event{Path: fmt.Sprint(i), Op: WRITE})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if event := receiveEvent(t, s.events); event.Path != fmt.Sprint(i) {
+			t.Fatalf(`got event %s, want %d`, event.Path, i)
+		}
+	}
+}
+
+// newTestBlockedSubscription returns a subscription whose pump holds the first event of "a"
+// without receiving, so that the following events stay in the buffer.
+func newTestBlockedSubscription(t *testing.T, size int, overflow OverflowPolicy) *subscription {
+	t.Helper()
+	s := newSubscription(size, overflow)
+	t.Cleanup(s.Close)
+	s.Push(&Event{Path: "a", Op: CREATE})
+	// It waits for the pump taking the event from the buffer.
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		n := len(s.buffer)
+		s.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return s
+}
+
+func TestSubscription_DropOldest(t *testing.T) {
+	s := newTestBlockedSubscription(t, 2, OverflowDropOldest)
+	s.Push(&Event{Path: "b", Op: CREATE})
+	s.Push(&Event{Path: "c", Op: CREATE})
+	s.Push(&Event{Path: "d", Op: CREATE})
+	for _, want := range []string{"a", "c", "d"} {
+		if event := receiveEvent(t, s.events); event.Path != want {
+			t.Fatalf(`got %s, want %s`, event.Path, want)
+		}
+	}
+}
+
+func TestSubscription_Coalesce(t *testing.T) {
+	s := newTestBlockedSubscription(t, 2, OverflowCoalesce)
+	b := &Event{Path: "b", Op: CREATE}
+	s.Push(b)
+	s.Push(&Event{Path: "c", Op: CREATE})
+	s.Push(&Event{Path: "b", Op: WRITE})
+	if event := receiveEvent(t, s.events); event.Path != "a" {
+		t.Fatalf(`got %s, want a`, event.Path)
+	}
+	if event := receiveEvent(t, s.events); event.Path != "b" || event.Op != CREATE|WRITE {
+		t.Fatalf(`got %s %v, want b CREATE|WRITE`, event.Path, event.Op)
+	}
+	// The merging does not change the event shared with other callbacks.
+	if b.Op != CREATE {
+		t.Fatalf(`shared event is changed to %v`, b.Op)
+	}
+	if event := receiveEvent(t, s.events); event.Path != "c" {
+		t.Fatalf(`got %s, want c`, event.Path)
+	}
+}
+
+func TestSubscription_Block(t *testing.T) {
+	var (
+		s      = newTestBlockedSubscription(t, 1, OverflowBlock)
+		pushed = make(chan struct{})
+	)
+	s.Push(&Event{Path: "b", Op: CREATE})
+	go func() {
+		s.Push(&Event{Path: "c", Op: CREATE})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal(`pushing is not blocked by the full buffer`)
+	case <-time.After(20 * time.Millisecond):
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		if event := receiveEvent(t, s.events); event.Path != want {
+			t.Fatalf(`got %s, want %s`, event.Path, want)
+		}
+	}
+	<-pushed
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"testing"
+)
+
+func TestWatcher_Isolation(t *testing.T) {
+	var (
+		w1, _ = newTestWatcher(t)
+		w2, _ = newTestWatcher(t)
+		dir   = newTestDir(t)
+	)
+	c1, err := w1.Add(dir, func(event *Event) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := w2.Add(dir, func(event *Event) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ids are generated by each watcher.
+	if c1.Id != 1 || c2.Id != 1 {
+		t.Fatalf(`got ids %d and %d, want 1 of each watcher`, c1.Id, c2.Id)
+	}
+	// The names of AddOnce are registered by each watcher.
+	if _, err = w1.AddOnce("name", dir, func(event *Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := w2.AddOnce("name", dir, func(event *Event) {}); c == nil {
+		t.Fatal(`name of other watcher is registered`)
+	}
+	if c, _ := w1.AddOnce("name", dir, func(event *Event) {}); c != nil {
+		t.Fatal(`name is registered twice`)
+	}
+	// The callbacks of other watcher are not removed.
+	w1.RemoveCallback(c1.Id)
+	if len(w1.Callbacks()) != 1 || len(w2.Callbacks()) != 2 {
+		t.Fatalf(`got %d and %d callbacks, want 1 and 2`, len(w1.Callbacks()), len(w2.Callbacks()))
+	}
+	w1.Close()
+	w1.Close()
+	if len(w1.Callbacks()) != 0 || w1.nameSet.Size() != 0 {
+		t.Fatal(`callbacks are left after closing`)
+	}
+	if len(w2.Callbacks()) != 2 || w2.nameSet.Size() != 1 {
+		t.Fatal(`callbacks of other watcher are removed by closing`)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// callbackTrie is the path to callbacks registry indexed by path segments, in which the
+// lookup, recursive matching and removal are proportional to the depth of the path.
+type callbackTrie struct {
+	mu   sync.RWMutex      // mu ensures the concurrent safety of the trie.
+	root *callbackTrieNode // root is the node of empty path.
+	size int               // size is the number of the callbacks.
+}
+
+// callbackTrieNode is the node of a path segment.
+type callbackTrieNode struct {
+	parent    *callbackTrieNode            // parent is the node of the parent path.
+	segment   string                       // segment is the key of the node in its parent.
+	children  map[string]*callbackTrieNode // children is the segment to node mapping of the sub-paths.
+	callbacks []*Callback                  // callbacks is the callbacks bound to the path in registering order.
+}
+
+func newCallbackTrie() *callbackTrie {
+	return &callbackTrie{
+		root: &callbackTrieNode{},
+	}
+}
+
+// Add registers `callback` to its bound path.
+func (t *callbackTrie) Add(callback *Callback) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node := t.root
+	forEachPathSegment(callback.Path, func(segment string) bool {
+		child, ok := node.children[segment]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*callbackTrieNode)
+			}
+			child = &callbackTrieNode{parent: node, segment: segment}
+			node.children[segment] = child
+		}
+		node = child
+		return true
+	})
+	node.callbacks = append(node.callbacks, callback)
+	t.size++
+}
+
+// Remove unregisters `callback`, and returns whether it's registered.
+func (t *callbackTrie) Remove(callback *Callback) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node := t.find(callback.Path)
+	if node == nil {
+		return false
+	}
+	for i, c := range node.callbacks {
+		if c == callback {
+			node.callbacks = append(node.callbacks[:i:i], node.callbacks[i+1:]...)
+			t.size--
+			t.prune(node)
+			return true
+		}
+	}
+	return false
+}
+
+// RemovePath unregisters and returns all the callbacks bound to `path`.
+func (t *callbackTrie) RemovePath(path string) []*Callback {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node := t.find(path)
+	if node == nil {
+		return nil
+	}
+	callbacks := node.callbacks
+	node.callbacks = nil
+	t.size -= len(callbacks)
+	t.prune(node)
+	return callbacks
+}
+
+// Get returns the callbacks bound to `path`.
+func (t *callbackTrie) Get(path string) []*Callback {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if node := t.find(path); node != nil && len(node.callbacks) > 0 {
+		return append([]*Callback(nil), node.callbacks...)
+	}
+	return nil
+}
+
+// Match returns the callbacks that receive the events of `path`, which are the callbacks of
+// `path` itself and its direct parent, and the recursive callbacks of its other ancestors.
+// The callbacks of the nearer path are returned first.
+func (t *callbackTrie) Match(path string) (callbacks []*Callback) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var (
+		node  = t.root
+		nodes = make([]*callbackTrieNode, 0, 16)
+	)
+	forEachPathSegment(path, func(segment string) bool {
+		if node = node.children[segment]; node == nil {
+			return false
+		}
+		nodes = append(nodes, node)
+		return true
+	})
+	// The nodes are the ancestors of path if it's not fully matched.
+	total := pathSegmentCount(path)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		// The node of the path itself or its direct parent matches all its callbacks.
+		matchAll := i >= total-2
+		for _, callback := range nodes[i].callbacks {
+			if matchAll || callback.recursive {
+				callbacks = append(callbacks, callback)
+			}
+		}
+	}
+	return
+}
+
+// Covers checks whether `path` or any of its ancestors has callbacks.
+func (t *callbackTrie) Covers(path string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var (
+		node    = t.root
+		covered = false
+	)
+	forEachPathSegment(path, func(segment string) bool {
+		if node = node.children[segment]; node == nil {
+			return false
+		}
+		covered = len(node.callbacks) > 0
+		return !covered
+	})
+	return covered
+}
+
+// All returns all the callbacks.
+func (t *callbackTrie) All() []*Callback {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var (
+		callbacks = make([]*Callback, 0, t.size)
+		walk      func(node *callbackTrieNode)
+	)
+	walk = func(node *callbackTrieNode) {
+		callbacks = append(callbacks, node.callbacks...)
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	walk(t.root)
+	return callbacks
+}
+
+// find returns the node of `path`, or nil if it does not exist.
+// Note that it should be called with the lock held.
+func (t *callbackTrie) find(path string) *callbackTrieNode {
+	node := t.root
+	forEachPathSegment(path, func(segment string) bool {
+		node = node.children[segment]
+		return node != nil
+	})
+	return node
+}
+
+// prune removes `node` and its ancestors that have neither callbacks nor children.
+// Note that it should be called with the lock held.
+func (t *callbackTrie) prune(node *callbackTrieNode) {
+	for node != t.root && len(node.callbacks) == 0 && len(node.children) == 0 {
+		delete(node.parent.children, node.segment)
+		node = node.parent
+	}
+}
+
+// forEachPathSegment calls `f` with the segments of `path` in order until `f` returns false.
+// The segments are split by the path separator without allocation, and the trailing separator
+// is ignored, so that the root path has one empty segment, like the leading segment of others.
+func forEachPathSegment(path string, f func(segment string) bool) {
+	path = strings.TrimSuffix(path, string(filepath.Separator))
+	for {
+		i := strings.IndexByte(path, filepath.Separator)
+		if i == -1 {
+			f(path)
+			return
+		}
+		if !f(path[:i]) {
+			return
+		}
+		path = path[i+1:]
+	}
+}
+
+// pathSegmentCount returns the number of segments of `path` split by forEachPathSegment.
+func pathSegmentCount(path string) int {
+	path = strings.TrimSuffix(path, string(filepath.Separator))
+	return strings.Count(path, string(filepath.Separator)) + 1
+}
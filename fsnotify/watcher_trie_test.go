@@ -0,0 +1,189 @@
+// Copyright (c) 2022-2024 The Focela Authors, All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsnotify
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// callbackMap is the path to callbacks mapping searching every ancestor of the path, which is
+// the registry before callbackTrie, used as reference for tests and benchmarks.
+type callbackMap map[string][]*Callback
+
+func (m callbackMap) Match(path string) (callbacks []*Callback) {
+	callbacks = append(callbacks, m[path]...)
+	dirPath := filepath.Dir(path)
+	if dirPath == path {
+		return
+	}
+	callbacks = append(callbacks, m[dirPath]...)
+	for {
+		parentDirPath := filepath.Dir(dirPath)
+		if parentDirPath == dirPath {
+			return
+		}
+		for _, callback := range m[parentDirPath] {
+			if callback.recursive {
+				callbacks = append(callbacks, callback)
+			}
+		}
+		dirPath = parentDirPath
+	}
+}
+
+func (m callbackMap) Covers(path string) bool {
+	for {
+		if len(m[path]) > 0 {
+			return true
+		}
+		dirPath := filepath.Dir(path)
+		if dirPath == path {
+			return false
+		}
+		path = dirPath
+	}
+}
+
+// newTestCallbacks returns `n` callbacks bound to the paths under "/data" with depth up to 4.
+func newTestCallbacks(n int) []*Callback {
+	var (
+		r         = rand.New(rand.NewSource(1))
+		callbacks = make([]*Callback, n)
+	)
+	for i := range callbacks {
+		path := "/data"
+		for depth := r.Intn(4); depth >= 0; depth-- {
+			path = filepath.Join(path, fmt.Sprintf("d%d", r.Intn(10)))
+		}
+		callbacks[i] = &Callback{Id: i, Path: path, recursive: r.Intn(2) == 0}
+	}
+	return callbacks
+}
+
+func TestCallbackTrie(t *testing.T) {
+	var (
+		trie = newCallbackTrie()
+		root = &Callback{Id: 1, Path: "/", recursive: true}
+		a    = &Callback{Id: 2, Path: "/a"}
+		ab   = &Callback{Id: 3, Path: "/a/b", recursive: true}
+		ids  = func(callbacks []*Callback) string {
+			var ids []int
+			for _, callback := range callbacks {
+				ids = append(ids, callback.Id)
+			}
+			return fmt.Sprint(ids)
+		}
+	)
+	for _, callback := range []*Callback{root, a, ab} {
+		trie.Add(callback)
+	}
+	for path, want := range map[string]string{
+		"/":        "[1]",
+		"/a":       "[2 1]",
+		"/a/x":     "[2 1]",
+		"/a/x/y":   "[1]",
+		"/a/b/c/d": "[3 1]",
+	} {
+		if got := ids(trie.Match(path)); got != want {
+			t.Errorf(`Match(%s) = %s, want %s`, path, got, want)
+		}
+	}
+	if !trie.Covers("/q") || len(trie.All()) != 3 {
+		t.Fatal(`root callback does not cover all paths`)
+	}
+	if !trie.Remove(root) || trie.Remove(root) {
+		t.Fatal(`callback is not removed once`)
+	}
+	if trie.Covers("/q") || !trie.Covers("/a/x/y") {
+		t.Fatal(`got wrong covering after removing root callback`)
+	}
+	if got := ids(trie.RemovePath("/a/b")); got != "[3]" {
+		t.Fatalf(`RemovePath = %s, want [3]`, got)
+	}
+	trie.Remove(a)
+	// The nodes without callbacks are pruned.
+	if len(trie.All()) != 0 || len(trie.root.children) != 0 {
+		t.Fatalf(`got nodes %v after removing all callbacks`, trie.root.children)
+	}
+}
+
+func TestCallbackTrie_Reference(t *testing.T) {
+	var (
+		trie      = newCallbackTrie()
+		reference = make(callbackMap)
+	)
+	for _, callback := range newTestCallbacks(1000) {
+		trie.Add(callback)
+		reference[callback.Path] = append(reference[callback.Path], callback)
+	}
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 1000; i++ {
+		path := "/data"
+		for depth := r.Intn(6); depth >= 0; depth-- {
+			path = filepath.Join(path, fmt.Sprintf("d%d", r.Intn(12)))
+		}
+		if got, want := fmt.Sprint(trie.Match(path)), fmt.Sprint(reference.Match(path)); got != want {
+			t.Fatalf(`Match(%s) = %s, want %s`, path, got, want)
+		}
+		if got, want := trie.Covers(path), reference.Covers(path); got != want {
+			t.Fatalf(`Covers(%s) = %v, want %v`, path, got, want)
+		}
+	}
+}
+
+// benchmarkCallbacks benchmarks `f` with the registry of `n` callbacks for the callback counts.
+func benchmarkCallbacks(b *testing.B, f func(b *testing.B, trie *callbackTrie, reference callbackMap)) {
+	for _, n := range []int{10, 1000, 10000} {
+		var (
+			trie      = newCallbackTrie()
+			reference = make(callbackMap)
+		)
+		for _, callback := range newTestCallbacks(n) {
+			trie.Add(callback)
+			reference[callback.Path] = append(reference[callback.Path], callback)
+		}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			f(b, trie, reference)
+		})
+	}
+}
+
+const benchmarkCallbackPath = "/data/d1/d2/d3/d4/d5/d6/file.txt"
+
+func BenchmarkCallbackTrie_Match(b *testing.B) {
+	benchmarkCallbacks(b, func(b *testing.B, trie *callbackTrie, _ callbackMap) {
+		for i := 0; i < b.N; i++ {
+			trie.Match(benchmarkCallbackPath)
+		}
+	})
+}
+
+func BenchmarkCallbackMap_Match(b *testing.B) {
+	benchmarkCallbacks(b, func(b *testing.B, _ *callbackTrie, reference callbackMap) {
+		for i := 0; i < b.N; i++ {
+			reference.Match(benchmarkCallbackPath)
+		}
+	})
+}
+
+func BenchmarkWatcher_CheckPathCanBeRemoved(b *testing.B) {
+	benchmarkCallbacks(b, func(b *testing.B, trie *callbackTrie, _ callbackMap) {
+		w := &Watcher{callbacks: trie}
+		for i := 0; i < b.N; i++ {
+			w.checkPathCanBeRemoved(benchmarkCallbackPath)
+		}
+	})
+}
+
+func BenchmarkCallbackMap_Covers(b *testing.B) {
+	benchmarkCallbacks(b, func(b *testing.B, _ *callbackTrie, reference callbackMap) {
+		for i := 0; i < b.N; i++ {
+			reference.Covers(benchmarkCallbackPath)
+		}
+	})
+}
@@ -1,10 +1,13 @@
 module github.com/gocarp/go
 
-go 1.22
+go 1.23
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/gocarp/codes v1.0.0 // indirect
 	github.com/gocarp/errors v1.0.1 // indirect
 	github.com/gocarp/helpers v1.1.1 // indirect
 	github.com/gocarp/utils v1.0.2 // indirect
 )
+
+require golang.org/x/sys v0.13.0 // indirect